@@ -5,7 +5,6 @@ package collector
 import (
 	"errors"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -455,7 +454,7 @@ func NewADCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *ADCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting ad metrics:", desc, err)
 		return err
 	}
@@ -614,10 +613,10 @@ type Win32_PerfRawData_DirectoryServices_DirectoryServices struct {
 	TransitivesuboperationsPersec                                    uint32
 }
 
-func (c *ADCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *ADCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_DirectoryServices_DirectoryServices
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -3,7 +3,6 @@ package collector
 import (
 	"strconv"
 
-	"github.com/leoluk/perflib_exporter/perflib"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"golang.org/x/sys/windows/registry"
@@ -56,16 +55,19 @@ type Collector interface {
 	Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (err error)
 }
 
+// ScrapeContext holds the state shared by all collectors during a single
+// scrape.
 type ScrapeContext struct {
-	perfObjects map[string]*perflib.PerfObject
+	collector   string
+	perfObjects perflibObjects
+	filtered    *filteredInstances
+	wmiQueries  *wmiQueryCache
 }
 
-// PrepareScrapeContext creates a ScrapeContext to be used during a single scrape
-func PrepareScrapeContext() (*ScrapeContext, error) {
-	objs, err := getPerflibSnapshot()
-	if err != nil {
-		return nil, err
+func newScrapeContext(perfObjects perflibObjects, query wmiQueryFunc) *ScrapeContext {
+	return &ScrapeContext{
+		perfObjects: perfObjects,
+		filtered:    &filteredInstances{},
+		wmiQueries:  newWMIQueryCache(query),
 	}
-
-	return &ScrapeContext{objs}, nil
 }
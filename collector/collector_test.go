@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// collectorAdapter registers a Collector with a prometheus registry, running
+// it with the given scrape context.
+type collectorAdapter struct {
+	t   *testing.T
+	c   Collector
+	ctx *ScrapeContext
+}
+
+func (a collectorAdapter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (a collectorAdapter) Collect(ch chan<- prometheus.Metric) {
+	if err := a.c.Collect(a.ctx, ch); err != nil {
+		a.t.Errorf("Collect failed: %v", err)
+	}
+}
+
+// gatherCollector collects c with ctx, and returns the metrics in the text
+// exposition format, sorted by name and labels.
+func gatherCollector(t *testing.T, c Collector, ctx *ScrapeContext) string {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectorAdapter{t: t, c: c, ctx: ctx})
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&b, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
@@ -3,9 +3,11 @@
 package collector
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
 )
 
 func init() {
@@ -13,13 +15,15 @@ func init() {
 }
 
 type cpuCollectorBasic struct {
-	CStateSecondsTotal *prometheus.Desc
-	TimeTotal          *prometheus.Desc
-	InterruptsTotal    *prometheus.Desc
-	DPCsTotal          *prometheus.Desc
+	CStateSecondsTotal     *prometheus.Desc
+	CStateTransitionsTotal *prometheus.Desc
+	TimeTotal              *prometheus.Desc
+	InterruptsTotal        *prometheus.Desc
+	DPCsTotal              *prometheus.Desc
 }
 type cpuCollectorFull struct {
 	CStateSecondsTotal       *prometheus.Desc
+	CStateTransitionsTotal   *prometheus.Desc
 	TimeTotal                *prometheus.Desc
 	InterruptsTotal          *prometheus.Desc
 	DPCsTotal                *prometheus.Desc
@@ -29,12 +33,15 @@ type cpuCollectorFull struct {
 	ProcessorFrequencyMHz    *prometheus.Desc
 	ProcessorMaxFrequencyMHz *prometheus.Desc
 	ProcessorPerformance     *prometheus.Desc
+	ProcessorUtilityTotal    *prometheus.Desc
+	UtilityBaseTotal         *prometheus.Desc
+	Info                     *prometheus.Desc
+
+	topology *cpuTopology
 }
 
 // newCPUCollector constructs a new cpuCollector, appropriate for the running OS
 func newCPUCollector() (Collector, error) {
-	const subsystem = "cpu"
-
 	version := getWindowsVersion()
 	// For Windows 2008 (version 6.0) or earlier we only have the "Processor"
 	// class. As of Windows 2008 R2 (version 6.1) the more detailed
@@ -43,33 +50,55 @@ func newCPUCollector() (Collector, error) {
 	// them).
 	// Value 6.05 was selected to split between Windows versions.
 	if version < 6.05 {
-		return &cpuCollectorBasic{
-			CStateSecondsTotal: prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, subsystem, "cstate_seconds_total"),
-				"Time spent in low-power idle state",
-				[]string{"core", "state"},
-				nil,
-			),
-			TimeTotal: prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, subsystem, "time_total"),
-				"Time that processor spent in different modes (idle, user, system, ...)",
-				[]string{"core", "mode"},
-				nil,
-			),
-			InterruptsTotal: prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, subsystem, "interrupts_total"),
-				"Total number of received and serviced hardware interrupts",
-				[]string{"core"},
-				nil,
-			),
-			DPCsTotal: prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, subsystem, "dpcs_total"),
-				"Total number of received and serviced deferred procedure calls (DPCs)",
-				[]string{"core"},
-				nil,
-			),
-		}, nil
+		return newCPUCollectorBasic(), nil
+	}
+
+	topology, err := getProcessorTopology()
+	if err != nil {
+		log.Warnf("Could not read the processor topology, the socket of processors is unknown: %v", err)
 	}
+	return newCPUCollectorFull(topology), nil
+}
+
+func newCPUCollectorBasic() *cpuCollectorBasic {
+	const subsystem = "cpu"
+
+	return &cpuCollectorBasic{
+		CStateSecondsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "cstate_seconds_total"),
+			"Time spent in low-power idle state",
+			[]string{"core", "state"},
+			nil,
+		),
+		CStateTransitionsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "cstate_transitions_total"),
+			"Total number of transitions into low-power idle state",
+			[]string{"core", "state"},
+			nil,
+		),
+		TimeTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "time_total"),
+			"Time that processor spent in different modes (idle, user, system, ...)",
+			[]string{"core", "mode"},
+			nil,
+		),
+		InterruptsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "interrupts_total"),
+			"Total number of received and serviced hardware interrupts",
+			[]string{"core"},
+			nil,
+		),
+		DPCsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "dpcs_total"),
+			"Total number of received and serviced deferred procedure calls (DPCs)",
+			[]string{"core"},
+			nil,
+		),
+	}
+}
+
+func newCPUCollectorFull(topology *cpuTopology) *cpuCollectorFull {
+	const subsystem = "cpu"
 
 	return &cpuCollectorFull{
 		CStateSecondsTotal: prometheus.NewDesc(
@@ -78,6 +107,12 @@ func newCPUCollector() (Collector, error) {
 			[]string{"core", "state"},
 			nil,
 		),
+		CStateTransitionsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "cstate_transitions_total"),
+			"Total number of transitions into low-power idle state",
+			[]string{"core", "state"},
+			nil,
+		),
 		TimeTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "time_total"),
 			"Time that processor spent in different modes (idle, user, system, ...)",
@@ -126,7 +161,26 @@ func newCPUCollector() (Collector, error) {
 			[]string{"core"},
 			nil,
 		),
-	}, nil
+		ProcessorUtilityTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "utility_total"),
+			"Amount of work the processor is completing in different modes (processor, privileged), scaled by its frequency. Divide the rate by the rate of wmi_cpu_utility_base_total to get the utility in percent, as shown by Task Manager",
+			[]string{"core", "mode"},
+			nil,
+		),
+		UtilityBaseTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "utility_base_total"),
+			"Base of the processor utility counters in different modes (processor, privileged)",
+			[]string{"core", "mode"},
+			nil,
+		),
+		Info: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "info"),
+			"Labels the logical processors with their NUMA node, physical core and socket",
+			[]string{"core", "numa_node", "logical_processor", "physical_core", "socket"},
+			nil,
+		),
+		topology: topology,
+	}
 }
 
 type perflibProcessor struct {
@@ -137,9 +191,9 @@ type perflibProcessor struct {
 	DPCRate               float64 `perflib:"DPC Rate"`
 	DPCsQueued            float64 `perflib:"DPCs Queued/sec"`
 	Interrupts            float64 `perflib:"Interrupts/sec"`
-	PercentC2Time         float64 `perflib:"% C1 Time"`
-	PercentC3Time         float64 `perflib:"% C2 Time"`
-	PercentC1Time         float64 `perflib:"% C3 Time"`
+	PercentC1Time         float64 `perflib:"% C1 Time"`
+	PercentC2Time         float64 `perflib:"% C2 Time"`
+	PercentC3Time         float64 `perflib:"% C3 Time"`
 	PercentDPCTime        float64 `perflib:"% DPC Time"`
 	PercentIdleTime       float64 `perflib:"% Idle Time"`
 	PercentInterruptTime  float64 `perflib:"% Interrupt Time"`
@@ -179,6 +233,24 @@ func (c *cpuCollectorBasic) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 			cpu.PercentC3Time,
 			core, "c3",
 		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C1Transitions,
+			core, "c1",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C2Transitions,
+			core, "c2",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C3Transitions,
+			core, "c3",
+		)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.TimeTotal,
@@ -248,10 +320,12 @@ type perflibProcessorInformation struct {
 	PriorityTimeSeconds      float64 `perflib:"% Priority Time"`
 	PrivilegedTimeSeconds    float64 `perflib:"% Privileged Time"`
 	PrivilegedUtilitySeconds float64 `perflib:"% Privileged Utility"`
+	PrivilegedUtilityBase    float64 `perflib:"% Privileged Utility_Base"`
 	ProcessorFrequencyMHz    float64 `perflib:"Processor Frequency"`
 	ProcessorPerformance     float64 `perflib:"% Processor Performance"`
 	ProcessorTimeSeconds     float64 `perflib:"% Processor Time"`
 	ProcessorUtilityRate     float64 `perflib:"% Processor Utility"`
+	ProcessorUtilityBase     float64 `perflib:"% Processor Utility_Base"`
 	UserTimeSeconds          float64 `perflib:"% User Time"`
 }
 
@@ -286,6 +360,24 @@ func (c *cpuCollectorFull) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 			cpu.C3TimeSeconds,
 			core, "c3",
 		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C1TransitionsTotal,
+			core, "c1",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C2TransitionsTotal,
+			core, "c2",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.CStateTransitionsTotal,
+			prometheus.CounterValue,
+			cpu.C3TransitionsTotal,
+			core, "c3",
+		)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.TimeTotal,
@@ -362,6 +454,51 @@ func (c *cpuCollectorFull) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 			cpu.ProcessorPerformance,
 			core,
 		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ProcessorUtilityTotal,
+			prometheus.CounterValue,
+			cpu.ProcessorUtilityRate,
+			core, "processor",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.UtilityBaseTotal,
+			prometheus.CounterValue,
+			cpu.ProcessorUtilityBase,
+			core, "processor",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.ProcessorUtilityTotal,
+			prometheus.CounterValue,
+			cpu.PrivilegedUtilitySeconds,
+			core, "privileged",
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.UtilityBaseTotal,
+			prometheus.CounterValue,
+			cpu.PrivilegedUtilityBase,
+			core, "privileged",
+		)
+
+		if node, processor, ok := parseProcessorInstance(core); ok {
+			var physicalCore, socket string
+			if p, ok := c.topology.physicalCore(node, processor); ok {
+				physicalCore = strconv.Itoa(p)
+			}
+			if s, ok := c.topology.socket(node, processor); ok {
+				socket = strconv.Itoa(s)
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.Info,
+				prometheus.GaugeValue,
+				1,
+				core,
+				strconv.Itoa(node),
+				strconv.Itoa(processor),
+				physicalCore,
+				socket,
+			)
+		}
 	}
 
 	return nil
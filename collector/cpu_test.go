@@ -0,0 +1,168 @@
+// +build windows
+
+package collector
+
+import (
+	"testing"
+
+	perflibCollector "github.com/leoluk/perflib_exporter/collector"
+)
+
+// Recorded on Windows Server 2008 with two logical processors.
+var recordedProcessor = recordedInstances(
+	[]string{"0", "1", "_Total"},
+	[]recordedCounter{
+		{"% Processor Time", perflibCollector.PERF_100NSEC_TIMER_INV, []int64{8523112500000, 8611040000000, 17134152500000}},
+		{"% User Time", perflibCollector.PERF_100NSEC_TIMER, []int64{612345000000, 551234000000, 1163579000000}},
+		{"% Privileged Time", perflibCollector.PERF_100NSEC_TIMER, []int64{401234000000, 380000000000, 781234000000}},
+		{"Interrupts/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{123456789, 111111111, 234567900}},
+		{"% DPC Time", perflibCollector.PERF_100NSEC_TIMER, []int64{23456000000, 20000000000, 43456000000}},
+		{"% Interrupt Time", perflibCollector.PERF_100NSEC_TIMER, []int64{12345000000, 10000000000, 22345000000}},
+		{"DPCs Queued/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{2345678, 2222222, 4567900}},
+		{"DPC Rate", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{3, 1, 4}},
+		{"% Idle Time", perflibCollector.PERF_100NSEC_TIMER, []int64{8523112500000, 8611040000000, 17134152500000}},
+		{"% C1 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{5012345000000, 5111111000000, 10123456000000}},
+		{"% C2 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{3004567000000, 3022222000000, 6026789000000}},
+		{"% C3 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{402100000000, 433333000000, 835433000000}},
+		{"C1 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{98765432, 87654321, 186419753}},
+		{"C2 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{12345678, 11111111, 23456789}},
+		{"C3 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{1234567, 1111111, 2345678}},
+	},
+)
+
+// Recorded on Windows Server 2016 with two logical processors in one NUMA
+// node, the second of which was parked.
+var recordedProcessorInformation = recordedInstances(
+	[]string{"0,0", "0,1", "0,_Total", "_Total"},
+	[]recordedCounter{
+		{"% Processor Time", perflibCollector.PERF_100NSEC_TIMER_INV, []int64{9735512340000, 10123456780000, 19858969120000, 19858969120000}},
+		{"% User Time", perflibCollector.PERF_100NSEC_TIMER, []int64{321654000000, 210987000000, 532641000000, 532641000000}},
+		{"% Privileged Time", perflibCollector.PERF_100NSEC_TIMER, []int64{154321000000, 98765000000, 253086000000, 253086000000}},
+		{"Interrupts/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{456789012, 234567890, 691356902, 691356902}},
+		{"% DPC Time", perflibCollector.PERF_100NSEC_TIMER, []int64{4567000000, 1234000000, 5801000000, 5801000000}},
+		{"% Interrupt Time", perflibCollector.PERF_100NSEC_TIMER, []int64{3456000000, 987000000, 4443000000, 4443000000}},
+		{"DPCs Queued/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{34567890, 12345678, 46913568, 46913568}},
+		{"% Idle Time", perflibCollector.PERF_PRECISION_100NS_TIMER, []int64{9735512340000, 10123456780000, 19858969120000, 19858969120000}},
+		{"% C1 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{1234567000000, 987654000000, 2222221000000, 2222221000000}},
+		{"% C2 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{7654321000000, 8765432000000, 16419753000000, 16419753000000}},
+		{"% C3 Time", perflibCollector.PERF_100NSEC_TIMER, []int64{0, 0, 0, 0}},
+		{"C1 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{345678901, 123456789, 469135690, 469135690}},
+		{"C2 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{56789012, 45678901, 102467913, 102467913}},
+		{"C3 Transitions/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{0, 0, 0, 0}},
+		{"% Priority Time", perflibCollector.PERF_100NSEC_TIMER_INV, []int64{9876543210000, 10234567890000, 20111111100000, 20111111100000}},
+		{"Parking Status", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{0, 1, 1, 1}},
+		{"Processor Frequency", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{2594, 2594, 2594, 2594}},
+		{"% of Maximum Frequency", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{100, 100, 100, 100}},
+		{"Processor State Flags", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{0, 0, 0, 0}},
+		{"Clock Interrupts/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{234567890, 123456789, 358024679, 358024679}},
+		{"Idle Break Events/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{123456789, 23456789, 146913578, 146913578}},
+		{"% Processor Performance", perflibCollector.PERF_AVERAGE_BULK, []int64{11823456, 9765432, 21588888, 21588888}},
+		{"% Processor Performance", perflibCollector.PERF_AVERAGE_BASE, []int64{98765, 98765, 197530, 197530}},
+		{"% Processor Utility", perflibCollector.PERF_AVERAGE_BULK, []int64{67891234, 34567891, 102459125, 102459125}},
+		{"% Processor Utility", perflibCollector.PERF_AVERAGE_BASE, []int64{987654321, 987654321, 1975308642, 1975308642}},
+		{"% Privileged Utility", perflibCollector.PERF_AVERAGE_BULK, []int64{23456789, 12345678, 35802467, 35802467}},
+		{"% Privileged Utility", perflibCollector.PERF_AVERAGE_BASE, []int64{987654321, 987654321, 1975308642, 1975308642}},
+	},
+)
+
+// checkIdleTime checks that the time spent in C-states is part of the idle
+// time of every core.
+func checkIdleTime(t *testing.T, samples map[string]float64, cores ...string) {
+	for _, core := range cores {
+		var cstates float64
+		for _, state := range []string{"c1", "c2", "c3"} {
+			cstates += samples[`wmi_cpu_cstate_seconds_total{core="`+core+`",state="`+state+`"}`]
+		}
+		idle := samples[`wmi_cpu_time_total{core="`+core+`",mode="idle"}`]
+		if cstates > idle {
+			t.Errorf("Core %s spent %v seconds in C-states, but was only idle for %v seconds", core, cstates, idle)
+		}
+	}
+}
+
+func TestCPUCollectorBasic(t *testing.T) {
+	ctx := newScrapeContext(perflibObjects{
+		"Processor": newPerflibFixture("Processor", 1e7, recordedProcessor...),
+	}, nil)
+	samples := parseSamples(t, gatherCollector(t, newCPUCollectorBasic(), ctx))
+
+	checkSamples(t, samples, map[string]float64{
+		`wmi_cpu_cstate_seconds_total{core="0",state="c1"}`:     501234.5,
+		`wmi_cpu_cstate_seconds_total{core="0",state="c2"}`:     300456.7,
+		`wmi_cpu_cstate_seconds_total{core="0",state="c3"}`:     40210,
+		`wmi_cpu_cstate_seconds_total{core="1",state="c1"}`:     511111.1,
+		`wmi_cpu_cstate_transitions_total{core="0",state="c1"}`: 98765432,
+		`wmi_cpu_cstate_transitions_total{core="0",state="c2"}`: 12345678,
+		`wmi_cpu_cstate_transitions_total{core="0",state="c3"}`: 1234567,
+		`wmi_cpu_time_total{core="0",mode="idle"}`:              852311.25,
+		`wmi_cpu_time_total{core="0",mode="user"}`:              61234.5,
+		`wmi_cpu_time_total{core="0",mode="privileged"}`:        40123.4,
+		`wmi_cpu_time_total{core="0",mode="interrupt"}`:         1234.5,
+		`wmi_cpu_time_total{core="0",mode="dpc"}`:               2345.6,
+		`wmi_cpu_interrupts_total{core="1"}`:                    111111111,
+		`wmi_cpu_dpcs_total{core="1"}`:                          2222222,
+	})
+	checkIdleTime(t, samples, "0", "1")
+}
+
+func TestCPUCollectorFull(t *testing.T) {
+	ctx := newScrapeContext(perflibObjects{
+		"Processor Information": newPerflibFixture("Processor Information", 1e7, recordedProcessorInformation...),
+	}, nil)
+	topology := &cpuTopology{
+		nodes:    map[int]groupAffinity{0: {0, 0x3}},
+		packages: [][]groupAffinity{{{0, 0x3}}},
+	}
+	samples := parseSamples(t, gatherCollector(t, newCPUCollectorFull(topology), ctx))
+
+	checkSamples(t, samples, map[string]float64{
+		`wmi_cpu_cstate_seconds_total{core="0,0",state="c1"}`:                                      123456.7,
+		`wmi_cpu_cstate_seconds_total{core="0,0",state="c2"}`:                                      765432.1,
+		`wmi_cpu_cstate_seconds_total{core="0,0",state="c3"}`:                                      0,
+		`wmi_cpu_cstate_transitions_total{core="0,1",state="c1"}`:                                  123456789,
+		`wmi_cpu_cstate_transitions_total{core="0,1",state="c2"}`:                                  45678901,
+		`wmi_cpu_time_total{core="0,0",mode="idle"}`:                                               973551.234,
+		`wmi_cpu_time_total{core="0,1",mode="user"}`:                                               21098.7,
+		`wmi_cpu_clock_interrupts_total{core="0,0"}`:                                               234567890,
+		`wmi_cpu_idle_break_events_total{core="0,1"}`:                                              23456789,
+		`wmi_cpu_parking_status{core="0,0"}`:                                                       0,
+		`wmi_cpu_parking_status{core="0,1"}`:                                                       1,
+		`wmi_cpu_core_frequency_mhz{core="0,1"}`:                                                   2594,
+		`wmi_cpu_processor_performance{core="0,0"}`:                                                11823456,
+		`wmi_cpu_utility_total{core="0,0",mode="processor"}`:                                       67891234,
+		`wmi_cpu_utility_base_total{core="0,0",mode="processor"}`:                                  987654321,
+		`wmi_cpu_utility_total{core="0,1",mode="privileged"}`:                                      12345678,
+		`wmi_cpu_utility_base_total{core="0,1",mode="privileged"}`:                                 987654321,
+		`wmi_cpu_info{core="0,1",logical_processor="1",numa_node="0",physical_core="",socket="0"}`: 1,
+	})
+	checkIdleTime(t, samples, "0,0", "0,1")
+
+	samples = parseSamples(t, gatherCollector(t, newCPUCollectorFull(nil), ctx))
+	checkSamples(t, samples, map[string]float64{
+		`wmi_cpu_info{core="0,1",logical_processor="1",numa_node="0",physical_core="",socket=""}`: 1,
+	})
+}
+
+func TestCPUCollectorFullTopology(t *testing.T) {
+	topology := &cpuTopology{
+		nodes: map[int]groupAffinity{0: {0, 0x3}, 1: {0, 0xc}},
+		// Two threads per physical core.
+		cores:    [][]groupAffinity{{{0, 0x3}}, {{0, 0xc}}},
+		packages: [][]groupAffinity{{{0, 0x3}}, {{0, 0xc}}},
+	}
+	ctx := newScrapeContext(perflibObjects{
+		"Processor Information": newPerflibFixture("Processor Information", 1e7,
+			recordedInstances([]string{"0,0", "0,1", "0,_Total", "1,0", "1,1", "1,_Total", "_Total"}, []recordedCounter{
+				{"Processor Frequency", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{2594, 2594, 2594, 2394, 2394, 2394, 2494}},
+			})...,
+		),
+	}, nil)
+	samples := parseSamples(t, gatherCollector(t, newCPUCollectorFull(topology), ctx))
+
+	checkSamples(t, samples, map[string]float64{
+		`wmi_cpu_info{core="0,0",logical_processor="0",numa_node="0",physical_core="0",socket="0"}`: 1,
+		`wmi_cpu_info{core="0,1",logical_processor="1",numa_node="0",physical_core="0",socket="0"}`: 1,
+		`wmi_cpu_info{core="1,1",logical_processor="1",numa_node="1",physical_core="1",socket="1"}`: 1,
+		`wmi_cpu_core_frequency_mhz{core="1,0"}`:                                                    2394,
+	})
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseProcessorInstance splits the name of a "Processor Information"
+// instance, such as "1,3", into the NUMA node and the index of the logical
+// processor within the node. Totals such as "_Total" and "0,_Total" are not
+// processors.
+func parseProcessorInstance(name string) (node, processor int, ok bool) {
+	parts := strings.Split(name, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	node, err := strconv.Atoi(parts[0])
+	if err != nil || node < 0 {
+		return 0, 0, false
+	}
+	processor, err = strconv.Atoi(parts[1])
+	if err != nil || processor < 0 {
+		return 0, 0, false
+	}
+	return node, processor, true
+}
+
+// Relationships of SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX.
+const (
+	relationProcessorCore    = 0
+	relationNumaNode         = 1
+	relationProcessorPackage = 3
+)
+
+// groupAffinity mirrors GROUP_AFFINITY.
+type groupAffinity struct {
+	group uint16
+	mask  uint64
+}
+
+func (a groupAffinity) contains(group uint16, bit uint) bool {
+	return a.group == group && a.mask&(1<<bit) != 0
+}
+
+// cpuTopology maps logical processors to NUMA nodes, physical cores and
+// sockets, as returned by GetLogicalProcessorInformationEx.
+type cpuTopology struct {
+	nodes    map[int]groupAffinity
+	cores    [][]groupAffinity
+	packages [][]groupAffinity
+}
+
+// parseLogicalProcessorInformation parses the SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX
+// records in buf. ptrSize is the size of KAFFINITY, the affinity mask of a
+// GROUP_AFFINITY, on the platform that returned the records.
+func parseLogicalProcessorInformation(buf []byte, ptrSize int) (*cpuTopology, error) {
+	const (
+		headerSize = 8  // Relationship, Size
+		maskOffset = 24 // offset of GroupMask in all relationships
+	)
+	affinitySize := ptrSize + 8 // Mask, Group, Reserved[3]
+
+	readAffinity := func(b []byte) groupAffinity {
+		a := groupAffinity{group: binary.LittleEndian.Uint16(b[ptrSize:])}
+		if ptrSize == 8 {
+			a.mask = binary.LittleEndian.Uint64(b)
+		} else {
+			a.mask = uint64(binary.LittleEndian.Uint32(b))
+		}
+		return a
+	}
+
+	t := &cpuTopology{nodes: make(map[int]groupAffinity)}
+	for len(buf) > 0 {
+		if len(buf) < headerSize {
+			return nil, fmt.Errorf("truncated processor information record")
+		}
+		relationship := binary.LittleEndian.Uint32(buf)
+		size := int(binary.LittleEndian.Uint32(buf[4:]))
+		if size < headerSize || size > len(buf) {
+			return nil, fmt.Errorf("invalid processor information record size %d", size)
+		}
+		record := buf[headerSize:size]
+		buf = buf[size:]
+
+		switch relationship {
+		case relationNumaNode:
+			if len(record) < maskOffset+affinitySize {
+				return nil, fmt.Errorf("truncated NUMA node record")
+			}
+			node := int(binary.LittleEndian.Uint32(record))
+			t.nodes[node] = readAffinity(record[maskOffset:])
+		case relationProcessorCore, relationProcessorPackage:
+			// Both are PROCESSOR_RELATIONSHIP records.
+			if len(record) < maskOffset {
+				return nil, fmt.Errorf("truncated processor record")
+			}
+			groupCount := int(binary.LittleEndian.Uint16(record[maskOffset-2:]))
+			if len(record) < maskOffset+groupCount*affinitySize {
+				return nil, fmt.Errorf("truncated processor record")
+			}
+			masks := make([]groupAffinity, 0, groupCount)
+			for i := 0; i < groupCount; i++ {
+				masks = append(masks, readAffinity(record[maskOffset+i*affinitySize:]))
+			}
+			if relationship == relationProcessorCore {
+				t.cores = append(t.cores, masks)
+			} else {
+				t.packages = append(t.packages, masks)
+			}
+		}
+	}
+	return t, nil
+}
+
+// socket returns the index of the processor package containing the given
+// logical processor of a NUMA node.
+func (t *cpuTopology) socket(node, processor int) (int, bool) {
+	if t == nil {
+		return 0, false
+	}
+	return t.find(t.packages, node, processor)
+}
+
+// physicalCore returns the index of the physical core containing the given
+// logical processor of a NUMA node. The logical processors of a core share
+// its index if simultaneous multithreading is enabled.
+func (t *cpuTopology) physicalCore(node, processor int) (int, bool) {
+	if t == nil {
+		return 0, false
+	}
+	return t.find(t.cores, node, processor)
+}
+
+// find returns the index of the entry of sets whose affinity contains the
+// given logical processor of a NUMA node.
+func (t *cpuTopology) find(sets [][]groupAffinity, node, processor int) (int, bool) {
+	affinity, ok := t.nodes[node]
+	if !ok {
+		return 0, false
+	}
+
+	// The processors of a node are numbered in the order of its affinity mask.
+	n := 0
+	for bit := uint(0); bit < 64; bit++ {
+		if affinity.mask&(1<<bit) == 0 {
+			continue
+		}
+		if n == processor {
+			for i, masks := range sets {
+				for _, m := range masks {
+					if m.contains(affinity.group, bit) {
+						return i, true
+					}
+				}
+			}
+			return 0, false
+		}
+		n++
+	}
+	return 0, false
+}
@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseProcessorInstance(t *testing.T) {
+	cases := []struct {
+		name      string
+		node      int
+		processor int
+		ok        bool
+	}{
+		{"0,0", 0, 0, true},
+		{"1,15", 1, 15, true},
+		{"_Total", 0, 0, false},
+		{"0,_Total", 0, 0, false},
+		{"3", 0, 0, false},
+		{"-1,2", 0, 0, false},
+		{"0,1,2", 0, 0, false},
+	}
+	for _, tc := range cases {
+		node, processor, ok := parseProcessorInstance(tc.name)
+		if node != tc.node || processor != tc.processor || ok != tc.ok {
+			t.Errorf("parseProcessorInstance(%q) = %d, %d, %v, expected %d, %d, %v", tc.name, node, processor, ok, tc.node, tc.processor, tc.ok)
+		}
+	}
+}
+
+// processorRecord encodes a SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX record
+// with 64-bit affinity masks.
+func processorRecord(relationship uint32, number uint32, affinities ...groupAffinity) []byte {
+	b := make([]byte, 8+24+16*len(affinities))
+	binary.LittleEndian.PutUint32(b, relationship)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)))
+	if relationship == relationNumaNode {
+		binary.LittleEndian.PutUint32(b[8:], number)
+	} else {
+		binary.LittleEndian.PutUint16(b[8+22:], uint16(len(affinities)))
+	}
+	for i, a := range affinities {
+		binary.LittleEndian.PutUint64(b[8+24+16*i:], a.mask)
+		binary.LittleEndian.PutUint16(b[8+24+16*i+8:], a.group)
+	}
+	return b
+}
+
+func TestParseLogicalProcessorInformation(t *testing.T) {
+	// Two sockets with two NUMA nodes each, all in processor group 0. Node 1
+	// has its processors interleaved with node 0. The first socket has two
+	// threads per physical core.
+	var buf []byte
+	for _, mask := range []uint64{0x0003, 0x000c, 0x0030, 0x00c0} {
+		buf = append(buf, processorRecord(relationProcessorCore, 0, groupAffinity{0, mask})...)
+	}
+	for bit := uint(8); bit < 16; bit++ {
+		buf = append(buf, processorRecord(relationProcessorCore, 0, groupAffinity{0, 1 << bit})...)
+	}
+	buf = append(buf, processorRecord(relationProcessorPackage, 0, groupAffinity{0, 0x00ff})...)
+	buf = append(buf, processorRecord(relationProcessorPackage, 0, groupAffinity{0, 0xff00})...)
+	buf = append(buf, processorRecord(relationNumaNode, 0, groupAffinity{0, 0x0055})...)
+	buf = append(buf, processorRecord(relationNumaNode, 1, groupAffinity{0, 0x00aa})...)
+	buf = append(buf, processorRecord(relationNumaNode, 2, groupAffinity{0, 0x0f00})...)
+	buf = append(buf, processorRecord(relationNumaNode, 3, groupAffinity{0, 0xf000})...)
+	// Records of other relationships are skipped.
+	buf = append(buf, processorRecord(2, 0, groupAffinity{0, 0x1})...)
+
+	topology, err := parseLogicalProcessorInformation(buf, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		node, processor int
+		core, socket    int
+		ok              bool
+	}{
+		{0, 0, 0, 0, true},
+		{0, 3, 3, 0, true},
+		{1, 0, 0, 0, true},
+		{1, 2, 2, 0, true},
+		{2, 0, 4, 1, true},
+		{3, 3, 11, 1, true},
+		{3, 4, 0, 0, false},
+		{4, 0, 0, 0, false},
+	}
+	for _, tc := range cases {
+		socket, ok := topology.socket(tc.node, tc.processor)
+		if socket != tc.socket || ok != tc.ok {
+			t.Errorf("socket(%d, %d) = %d, %v, expected %d, %v", tc.node, tc.processor, socket, ok, tc.socket, tc.ok)
+		}
+		core, ok := topology.physicalCore(tc.node, tc.processor)
+		if core != tc.core || ok != tc.ok {
+			t.Errorf("physicalCore(%d, %d) = %d, %v, expected %d, %v", tc.node, tc.processor, core, ok, tc.core, tc.ok)
+		}
+	}
+
+	if _, ok := (*cpuTopology)(nil).socket(0, 0); ok {
+		t.Error("Expected an unknown topology to have no sockets")
+	}
+	if _, ok := (*cpuTopology)(nil).physicalCore(0, 0); ok {
+		t.Error("Expected an unknown topology to have no physical cores")
+	}
+}
+
+func TestParseLogicalProcessorInformationInvalid(t *testing.T) {
+	record := processorRecord(relationNumaNode, 0, groupAffinity{0, 1})
+	for name, buf := range map[string][]byte{
+		"truncated header": record[:4],
+		"truncated record": record[:len(record)-1],
+		"short record":     processorRecord(relationNumaNode, 0)[:8],
+	} {
+		if _, err := parseLogicalProcessorInformation(buf, 8); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
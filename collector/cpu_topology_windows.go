@@ -0,0 +1,34 @@
+// +build windows
+
+package collector
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetLogicalProcessorInformationEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetLogicalProcessorInformationEx")
+
+// getProcessorTopology reads the NUMA nodes and processor packages of the
+// system.
+func getProcessorTopology() (*cpuTopology, error) {
+	const relationAll = 0xffff
+
+	if err := procGetLogicalProcessorInformationEx.Find(); err != nil {
+		return nil, err
+	}
+
+	size := uint32(4096)
+	for {
+		buf := make([]byte, size)
+		r, _, err := procGetLogicalProcessorInformationEx.Call(relationAll, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+		if r != 0 {
+			return parseLogicalProcessorInformation(buf[:size], int(unsafe.Sizeof(uintptr(0))))
+		}
+		if err != syscall.ERROR_INSUFFICIENT_BUFFER || size <= uint32(len(buf)) {
+			return nil, err
+		}
+	}
+}
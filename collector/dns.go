@@ -5,7 +5,6 @@ package collector
 import (
 	"errors"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -182,7 +181,7 @@ func NewDNSCollector() (Collector, error) {
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *DNSCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting dns metrics:", desc, err)
 		return err
 	}
@@ -235,10 +234,10 @@ type Win32_PerfRawData_DNS_DNS struct {
 	ZoneTransferSOARequestSent     uint32
 }
 
-func (c *DNSCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *DNSCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_DNS_DNS
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -3,15 +3,24 @@
 package collector
 
 import (
+	"errors"
 	"regexp"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 const subsystem string = "exchange"
 
+var exchangeEnabledCollectors = kingpin.Flag(
+	"collectors.exchange.classes-enabled",
+	"Comma-separated list of exchange WMI classes to use.").
+	Default(exchangeAvailableClassCollectors()).String()
+
+func exchangeAvailableClassCollectors() string {
+	return "adaccess,transportqueues,database,httpproxy,activesync,availservice,owa,autodiscover,workloadmanagement,rpcclientaccess"
+}
+
 type exchangeCollector struct {
 	LDAPReadTime                               *prometheus.Desc
 	LDAPSearchTime                             *prometheus.Desc
@@ -55,6 +64,9 @@ type exchangeCollector struct {
 	UserCount                                  *prometheus.Desc
 
 	invalidProcName *regexp.Regexp
+
+	classCollectors map[string]subCollectorFunc
+	subCollectors   *subCollectors
 }
 
 type win32_PerfRawData_MSExchangeRpcClientAccess_MSExchangeRpcClientAccess struct {
@@ -158,7 +170,7 @@ func desc(metricName string, labels []string, desc string) *prometheus.Desc {
 
 // newExchangeCollector returns a new Collector
 func newExchangeCollector() (Collector, error) {
-	return &exchangeCollector{
+	c := &exchangeCollector{
 		LDAPReadTime:                               desc("ldap_read_time", []string{"name"}, "LDAP Read Time"),
 		LDAPSearchTime:                             desc("ldap_search_time", []string{"name"}, "LDAP Search Time"),
 		LDAPTimeoutErrorsPerSec:                    desc("ldap_timeout_errors_per_sec", []string{"name"}, "LDAP timeout errors per second"),
@@ -201,15 +213,39 @@ func newExchangeCollector() (Collector, error) {
 		UserCount:                                  desc("user_count", []string{"name"}, "RPC Client Access user count"),
 
 		invalidProcName: regexp.MustCompile(`#[0-9]{0,2}`),
-	}, nil
+	}
+
+	c.classCollectors = map[string]subCollectorFunc{
+		"adaccess":           c.collectADAccessProcesses,
+		"transportqueues":    c.collectTransportQueues,
+		"database":           c.collectDatabaseInstances,
+		"httpproxy":          c.collectHTTPProxy,
+		"activesync":         c.collectActiveSync,
+		"availservice":       c.collectAvailabilityService,
+		"owa":                c.collectOWA,
+		"autodiscover":       c.collectAutoDiscover,
+		"workloadmanagement": c.collectWorkloadManagementWorkloads,
+		"rpcclientaccess":    c.collectRPCClientAccess,
+	}
+
+	var err error
+	c.subCollectors, err = newSubCollectors(subsystem, exchangeAvailableClassCollectors(), *exchangeEnabledCollectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
 // Collect collects Exchange-metrics and provides them to prometheus through the ch channel
 func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
+	return c.subCollectors.collect(ctx, ch, c.classCollectors)
+}
+
+func (c *exchangeCollector) collectADAccessProcesses(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var procData []win32_PerfRawData_MSExchangeADAccess_MSExchangeADAccessProcesses
-	if err := wmi.Query(queryAll(procData), &procData); err != nil {
-		log.Errorf("WMI query error while collecting %s-metrics: %s", subsystem, err)
-		return err
+	if err := ctx.wmiQuery(queryAll(procData), &procData); err != nil {
+		return nil, err
 	}
 
 	for _, proc := range procData {
@@ -252,10 +288,13 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		)
 	}
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectTransportQueues(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var transportQueues []win32_PerfRawData_MSExchangeTransportQueues_MSExchangeTransportQueues
-	if err := wmi.Query(queryAll(transportQueues), &transportQueues); err != nil {
-		log.Errorf("WMI query error while collecting %s-metrics: %s", subsystem, err)
-		return err
+	if err := ctx.wmiQuery(queryAll(transportQueues), &transportQueues); err != nil {
+		return nil, err
 	}
 	for _, queue := range transportQueues {
 		ch <- prometheus.MustNewConstMetric(
@@ -308,10 +347,13 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		)
 	}
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectDatabaseInstances(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var databaseInstances []win32_PerfRawData_ESE_MSExchangeDatabaseInstances
-	if err := wmi.Query(queryAll(databaseInstances), &databaseInstances); err != nil {
-		log.Errorf("WMI query error while collecting %s-metrics: %s", subsystem, err)
-		return err
+	if err := ctx.wmiQuery(queryAll(databaseInstances), &databaseInstances); err != nil {
+		return nil, err
 	}
 	for _, instance := range databaseInstances {
 		ch <- prometheus.MustNewConstMetric(
@@ -346,9 +388,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		)
 	}
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectHTTPProxy(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var httpproxy []win32_PerfRawData_MSExchangeHttpProxy_MSExchangeHttpProxy
-	if err := wmi.Query(queryAll(&httpproxy), &httpproxy); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&httpproxy), &httpproxy); err != nil {
+		return nil, err
+	}
+	if len(httpproxy) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.MailboxServerLocatorAverageLatency,
@@ -381,9 +430,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(httpproxy[0].ProxyRequestsPerSec),
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectActiveSync(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var activesync []win32_PerfRawData_MSExchangeActiveSync_MSExchangeActiveSync
-	if err := wmi.Query(queryAll(&activesync), &activesync); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&activesync), &activesync); err != nil {
+		return nil, err
+	}
+	if len(activesync) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.ActiveSyncRequestsPerSec,
@@ -401,9 +457,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(activesync[0].SyncCommandsPerSec),
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectAvailabilityService(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var availservice []win32_PerfRawData_MSExchangeAvailabilityService_MSExchangeAvailabilityService
-	if err := wmi.Query(queryAll(&availservice), &availservice); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&availservice), &availservice); err != nil {
+		return nil, err
+	}
+	if len(availservice) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.AvailabilityRequestsSec,
@@ -411,9 +474,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(availservice[0].RequestsSec), // AvailabilityRequestsSec TODO: Correct?
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectOWA(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var owa []win32_PerfRawData_MSExchangeOWA_MSExchangeOWA
-	if err := wmi.Query(queryAll(&owa), &owa); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&owa), &owa); err != nil {
+		return nil, err
+	}
+	if len(owa) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.CurrentUniqueUsers,
@@ -426,9 +496,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(owa[0].RequestsPerSec), // OWARequestsPerSec
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectAutoDiscover(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var autodisc []win32_PerfRawData_MSExchangeAutodiscover_MSExchangeAutodiscover
-	if err := wmi.Query(queryAll(&autodisc), &autodisc); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&autodisc), &autodisc); err != nil {
+		return nil, err
+	}
+	if len(autodisc) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.AutodiscoverRequestsPerSec,
@@ -436,9 +513,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(autodisc[0].RequestsPerSec), // AutodiscoveRequestsPerSec
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectWorkloadManagementWorkloads(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var mgmtworkload []win32_PerfRawData_MSExchangeWorkloadManagementWorkloads_MSExchangeWorkloadManagementWorkloads
-	if err := wmi.Query(queryAll(&mgmtworkload), &mgmtworkload); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&mgmtworkload), &mgmtworkload); err != nil {
+		return nil, err
+	}
+	if len(mgmtworkload) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.ActiveTasks,
@@ -456,9 +540,16 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(mgmtworkload[0].QueuedTasks),
 	)
 
+	return nil, nil
+}
+
+func (c *exchangeCollector) collectRPCClientAccess(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var rpcCliAccess []win32_PerfRawData_MSExchangeRpcClientAccess_MSExchangeRpcClientAccess
-	if err := wmi.Query(queryAll(&rpcCliAccess), &rpcCliAccess); err != nil {
-		return err
+	if err := ctx.wmiQuery(queryAll(&rpcCliAccess), &rpcCliAccess); err != nil {
+		return nil, err
+	}
+	if len(rpcCliAccess) == 0 {
+		return nil, errors.New("WMI query returned empty result set")
 	}
 
 	ch <- prometheus.MustNewConstMetric(
@@ -497,5 +588,5 @@ func (c *exchangeCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Met
 		float64(rpcCliAccess[0].UserCount),
 	)
 
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	filteredInstancesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "filtered_instances"),
+		"wmi_exporter: Number of instances dropped by include/exclude filters during the scrape.",
+		[]string{"collector", "filter"},
+		nil,
+	)
+
+	// instanceFilters holds every filter created by an enabled collector, so
+	// that a dropped count of zero is reported as well.
+	instanceFilters   []*instanceFilter
+	instanceFiltersMu sync.Mutex
+
+	labelPatternRegexp = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)=~(.*)$`)
+)
+
+// instanceFilterFlags holds the include and exclude patterns of an
+// instanceFilter as given on the command line.
+type instanceFilterFlags struct {
+	include *[]string
+	exclude *[]string
+}
+
+// newInstanceFilterFlags registers the repeatable
+// --collector.<collector>.<target>-include and -exclude flags.
+func newInstanceFilterFlags(collector, target, description string) instanceFilterFlags {
+	const syntax = "Matches the instance name, or a label when given as label=~regexp. May be repeated."
+	return instanceFilterFlags{
+		include: kingpin.Flag(
+			fmt.Sprintf("collector.%s.%s-include", collector, target),
+			fmt.Sprintf("Regexp of %s to include. If given, an instance must match at least one include pattern. %s", description, syntax),
+		).Strings(),
+		exclude: kingpin.Flag(
+			fmt.Sprintf("collector.%s.%s-exclude", collector, target),
+			fmt.Sprintf("Regexp of %s to exclude. An instance matching any exclude pattern is dropped. %s", description, syntax),
+		).Strings(),
+	}
+}
+
+// newFilter builds the filter for collector. Extra patterns, such as those of
+// the deprecated whitelist and blacklist flags, are added to the ones given on
+// the command line.
+func (f instanceFilterFlags) newFilter(collector, target string, extraInclude, extraExclude []string) (*instanceFilter, error) {
+	include := append(append([]string{}, *f.include...), extraInclude...)
+	exclude := append(append([]string{}, *f.exclude...), extraExclude...)
+	return newInstanceFilter(collector, target, include, exclude)
+}
+
+type instancePattern struct {
+	label   string
+	pattern *regexp.Regexp
+}
+
+func (p instancePattern) matches(name string, labels map[string]string) bool {
+	if p.label == "" {
+		return p.pattern.MatchString(name)
+	}
+	value, ok := labels[p.label]
+	return ok && p.pattern.MatchString(value)
+}
+
+// instanceFilter decides which instances (volumes, NICs, processes, ...) of a
+// collector are reported. An instance is kept if it matches at least one
+// include pattern (or no include patterns are configured) and no exclude
+// pattern. Patterns are anchored; a pattern of the form label=~regexp is
+// matched against the given label instead of the instance name.
+type instanceFilter struct {
+	collector string
+	target    string
+	include   []instancePattern
+	exclude   []instancePattern
+}
+
+func newInstanceFilter(collector, target string, include, exclude []string) (*instanceFilter, error) {
+	f := &instanceFilter{
+		collector: collector,
+		target:    target,
+	}
+
+	var err error
+	if f.include, err = compileInstancePatterns(include); err != nil {
+		return nil, fmt.Errorf("invalid %s %s include pattern: %v", collector, target, err)
+	}
+	if f.exclude, err = compileInstancePatterns(exclude); err != nil {
+		return nil, fmt.Errorf("invalid %s %s exclude pattern: %v", collector, target, err)
+	}
+
+	instanceFiltersMu.Lock()
+	instanceFilters = append(instanceFilters, f)
+	instanceFiltersMu.Unlock()
+
+	return f, nil
+}
+
+func compileInstancePatterns(patterns []string) ([]instancePattern, error) {
+	compiled := make([]instancePattern, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+
+		var label string
+		if m := labelPatternRegexp.FindStringSubmatch(p); m != nil {
+			label, p = m[1], m[2]
+		}
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", p))
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, instancePattern{label: label, pattern: re})
+	}
+	return compiled, nil
+}
+
+// matches reports whether the instance passes the filter.
+func (f *instanceFilter) matches(name string, labels map[string]string) bool {
+	if len(f.include) > 0 {
+		included := false
+		for _, p := range f.include {
+			if p.matches(name, labels) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, p := range f.exclude {
+		if p.matches(name, labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// keep reports whether the instance passes the filter, counting dropped
+// instances in the scrape context. An instance checked several times during
+// a scrape, for example once per WMI class or per core, is counted once.
+func (f *instanceFilter) keep(ctx *ScrapeContext, name string, labels map[string]string) bool {
+	if f.matches(name, labels) {
+		return true
+	}
+	if ctx != nil && ctx.filtered != nil {
+		ctx.filtered.add(f, name)
+	}
+	return false
+}
+
+// active reports whether any include or exclude pattern is configured.
+func (f *instanceFilter) active() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// filteredInstances records the names of the instances dropped by each
+// filter during a single scrape.
+type filteredInstances struct {
+	mu      sync.Mutex
+	dropped map[*instanceFilter]map[string]struct{}
+}
+
+func (fi *filteredInstances) add(f *instanceFilter, name string) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.dropped == nil {
+		fi.dropped = make(map[*instanceFilter]map[string]struct{})
+	}
+	names, ok := fi.dropped[f]
+	if !ok {
+		names = make(map[string]struct{})
+		fi.dropped[f] = names
+	}
+	names[name] = struct{}{}
+}
+
+func (fi *filteredInstances) get(f *instanceFilter) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return len(fi.dropped[f])
+}
+
+// CollectFilteredInstances sends the number of instances dropped by each
+// instance filter during the scrape to the provided prometheus Metric channel.
+func (ctx *ScrapeContext) CollectFilteredInstances(ch chan<- prometheus.Metric) {
+	if ctx.filtered == nil {
+		return
+	}
+
+	instanceFiltersMu.Lock()
+	defer instanceFiltersMu.Unlock()
+
+	for _, f := range instanceFilters {
+		ch <- prometheus.MustNewConstMetric(
+			filteredInstancesDesc,
+			prometheus.GaugeValue,
+			float64(ctx.filtered.get(f)),
+			f.collector,
+			f.target,
+		)
+	}
+}
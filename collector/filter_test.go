@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInstanceFilterMatches(t *testing.T) {
+	cases := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		instance string
+		labels   map[string]string
+		expected bool
+	}{
+		{"no patterns", nil, nil, "C:", nil, true},
+		{"empty patterns are ignored", []string{""}, []string{""}, "C:", nil, true},
+		{"include match", []string{"C:|D:"}, nil, "D:", nil, true},
+		{"include no match", []string{"C:"}, nil, "D:", nil, false},
+		{"include is anchored", []string{"C"}, nil, "C:", nil, false},
+		{"any include matches", []string{"C:", "D:"}, nil, "D:", nil, true},
+		{"exclude match", nil, []string{"HarddiskVolume.*"}, "HarddiskVolume1", nil, false},
+		{"exclude wins over include", []string{".+"}, []string{"_Total"}, "_Total", nil, false},
+		{"label include", []string{"state=~running"}, nil, "wuauserv", map[string]string{"state": "running"}, true},
+		{"label include no match", []string{"state=~running"}, nil, "wuauserv", map[string]string{"state": "stopped"}, false},
+		{"missing label does not match", []string{"state=~.*"}, nil, "wuauserv", nil, false},
+		{"label exclude", nil, []string{"process_id=~4"}, "System", map[string]string{"process_id": "4"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := newInstanceFilter("test", "instance", c.include, c.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.matches(c.instance, c.labels); got != c.expected {
+				t.Errorf("Expected %v for %q, got %v", c.expected, c.instance, got)
+			}
+		})
+	}
+}
+
+func TestInstanceFilterInvalidPattern(t *testing.T) {
+	if _, err := newInstanceFilter("test", "instance", []string{"("}, nil); err == nil {
+		t.Error("Expected an error for invalid include pattern, but got ok")
+	}
+	if _, err := newInstanceFilter("test", "instance", nil, []string{"name=~["}); err == nil {
+		t.Error("Expected an error for invalid exclude pattern, but got ok")
+	}
+}
+
+func TestCollectFilteredInstances(t *testing.T) {
+	instanceFiltersMu.Lock()
+	saved := instanceFilters
+	instanceFilters = nil
+	instanceFiltersMu.Unlock()
+	defer func() {
+		instanceFiltersMu.Lock()
+		instanceFilters = saved
+		instanceFiltersMu.Unlock()
+	}()
+
+	volumes, err := newInstanceFilter("logical_disk", "volume", nil, []string{"HarddiskVolume.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newInstanceFilter("net", "nic", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newScrapeContext(nil, nil)
+	// An instance checked repeatedly during the scrape is counted once.
+	for _, name := range []string{"C:", "HarddiskVolume1", "HarddiskVolume2", "HarddiskVolume1", "HarddiskVolume2"} {
+		volumes.keep(ctx, name, nil)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	ctx.CollectFilteredInstances(ch)
+	close(ch)
+
+	counts := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		counts[labels["collector"]+"/"+labels["filter"]] = pb.GetGauge().GetValue()
+	}
+
+	expected := map[string]float64{"logical_disk/volume": 2, "net/nic": 0}
+	for k, v := range expected {
+		if got, ok := counts[k]; !ok || got != v {
+			t.Errorf("Expected %s to have dropped %v instances, got %v", k, v, got)
+		}
+	}
+	if len(counts) != len(expected) {
+		t.Errorf("Expected %d filters to be reported, got %v", len(expected), counts)
+	}
+}
@@ -5,11 +5,22 @@ package collector
 import (
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+var hypervEnabledCollectors = kingpin.Flag(
+	"collectors.hyperv.classes-enabled",
+	"Comma-separated list of hyperv WMI classes to use.").
+	Default(hypervAvailableClassCollectors()).String()
+
+var vmFilterFlags = newInstanceFilterFlags("hyperv", "vm", "virtual machines")
+
+func hypervAvailableClassCollectors() string {
+	return "health,vid,hv,processor,host_cpu,vm_cpu,switch,ethernet,storage,network"
+}
+
 func init() {
 	Factories["hyperv"] = NewHyperVCollector
 }
@@ -110,12 +121,16 @@ type HyperVCollector struct {
 	VMNetworkDroppedPacketsOutgoing *prometheus.Desc
 	VMNetworkPacketsReceived        *prometheus.Desc
 	VMNetworkPacketsSent            *prometheus.Desc
+
+	classCollectors map[string]subCollectorFunc
+	subCollectors   *subCollectors
+	vmFilter        *instanceFilter
 }
 
 // NewHyperVCollector ...
 func NewHyperVCollector() (Collector, error) {
 	buildSubsystemName := func(component string) string { return "hyperv_" + component }
-	return &HyperVCollector{
+	c := &HyperVCollector{
 		HealthCritical: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, buildSubsystemName("health"), "critical"),
 			"This counter represents the number of virtual machines with critical health",
@@ -592,63 +607,39 @@ func NewHyperVCollector() (Collector, error) {
 			[]string{"vm_interface"},
 			nil,
 		),
-	}, nil
-}
-
-// Collect sends the metric values for each metric
-// to the provided prometheus Metric channel.
-func (c *HyperVCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collectVmHealth(ch); err != nil {
-		log.Error("failed collecting hyperV health status metrics:", desc, err)
-		return err
-	}
-
-	if desc, err := c.collectVmVid(ch); err != nil {
-		log.Error("failed collecting hyperV pages metrics:", desc, err)
-		return err
 	}
 
-	if desc, err := c.collectVmHv(ch); err != nil {
-		log.Error("failed collecting hyperV hv status metrics:", desc, err)
-		return err
+	c.classCollectors = map[string]subCollectorFunc{
+		"health":    c.collectVmHealth,
+		"vid":       c.collectVmVid,
+		"hv":        c.collectVmHv,
+		"processor": c.collectVmProcessor,
+		"host_cpu":  c.collectHostCpuUsage,
+		"vm_cpu":    c.collectVmCpuUsage,
+		"switch":    c.collectVmSwitch,
+		"ethernet":  c.collectVmEthernet,
+		"storage":   c.collectVmStorage,
+		"network":   c.collectVmNetwork,
 	}
 
-	if desc, err := c.collectVmProcessor(ch); err != nil {
-		log.Error("failed collecting hyperV processor metrics:", desc, err)
-		return err
-	}
-
-	if desc, err := c.collectHostCpuUsage(ch); err != nil {
-		log.Error("failed collecting hyperV host CPU metrics:", desc, err)
-		return err
-	}
-
-	if desc, err := c.collectVmCpuUsage(ch); err != nil {
-		log.Error("failed collecting hyperV VM CPU metrics:", desc, err)
-		return err
-	}
-
-	if desc, err := c.collectVmSwitch(ch); err != nil {
-		log.Error("failed collecting hyperV switch metrics:", desc, err)
-		return err
-	}
-
-	if desc, err := c.collectVmEthernet(ch); err != nil {
-		log.Error("failed collecting hyperV ethernet metrics:", desc, err)
-		return err
+	var err error
+	c.vmFilter, err = vmFilterFlags.newFilter("hyperv", "vm", nil, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if desc, err := c.collectVmStorage(ch); err != nil {
-		log.Error("failed collecting hyperV virtual storage metrics:", desc, err)
-		return err
+	c.subCollectors, err = newSubCollectors("hyperv", hypervAvailableClassCollectors(), *hypervEnabledCollectors)
+	if err != nil {
+		return nil, err
 	}
 
-	if desc, err := c.collectVmNetwork(ch); err != nil {
-		log.Error("failed collecting hyperV virtual network metrics:", desc, err)
-		return err
-	}
+	return c, nil
+}
 
-	return nil
+// Collect sends the metric values for each metric
+// to the provided prometheus Metric channel.
+func (c *HyperVCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
+	return c.subCollectors.collect(ctx, ch, c.classCollectors)
 }
 
 // Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary vm health status
@@ -657,10 +648,10 @@ type Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
 	HealthOk       uint32
 }
 
-func (c *HyperVCollector) collectVmHealth(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmHealth(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_VmmsVirtualMachineStats_HyperVVirtualMachineHealthSummary
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -690,10 +681,10 @@ type Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition struct {
 	RemotePhysicalPages    uint64
 }
 
-func (c *HyperVCollector) collectVmVid(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmVid(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_VidPerfProvider_HyperVVMVidPartition
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -701,6 +692,9 @@ func (c *HyperVCollector) collectVmVid(ch chan<- prometheus.Metric) (*prometheus
 		if strings.Contains(page.Name, "_Total") {
 			continue
 		}
+		if !c.vmFilter.keep(ctx, page.Name, map[string]string{"vm": page.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.PhysicalPagesAllocated,
@@ -754,10 +748,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition struct {
 	VirtualTLBPages               uint64
 }
 
-func (c *HyperVCollector) collectVmHv(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmHv(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootPartition
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -892,10 +886,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisor struct {
 	VirtualProcessors uint64
 }
 
-func (c *HyperVCollector) collectVmProcessor(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmProcessor(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -927,10 +921,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor struct {
 	PercentTotalRunTime      uint64
 }
 
-func (c *HyperVCollector) collectHostCpuUsage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectHostCpuUsage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorRootVirtualProcessor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -988,10 +982,10 @@ type Win32_PerfRawData_HvStats_HyperVHypervisorVirtualProcessor struct {
 	PercentTotalRunTime      uint64
 }
 
-func (c *HyperVCollector) collectVmCpuUsage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmCpuUsage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_HvStats_HyperVHypervisorVirtualProcessor
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1003,6 +997,9 @@ func (c *HyperVCollector) collectVmCpuUsage(ch chan<- prometheus.Metric) (*prome
 		parts := strings.Split(obj.Name, ":")
 		vmName := parts[0]
 		coreId := strings.Split(parts[1], " ")[2]
+		if !c.vmFilter.keep(ctx, vmName, map[string]string{"vm": vmName, "core": coreId}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.VMGuestRunTime,
@@ -1066,10 +1063,10 @@ type Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch struct {
 	PurgedMacAddressesPersec               uint64
 }
 
-func (c *HyperVCollector) collectVmSwitch(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmSwitch(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NvspSwitchStats_HyperVVirtualSwitch
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1225,10 +1222,10 @@ type Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter struct {
 	FramesSentPersec     uint64
 }
 
-func (c *HyperVCollector) collectVmEthernet(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmEthernet(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_EthernetPerfProvider_HyperVLegacyNetworkAdapter
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1236,6 +1233,10 @@ func (c *HyperVCollector) collectVmEthernet(ch chan<- prometheus.Metric) (*prome
 		if strings.Contains(obj.Name, "_Total") {
 			continue
 		}
+		vmName := hypervAdapterVMName(obj.Name)
+		if !c.vmFilter.keep(ctx, vmName, map[string]string{"vm": vmName, "adapter": obj.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.AdapterBytesDropped,
@@ -1284,6 +1285,75 @@ func (c *HyperVCollector) collectVmEthernet(ch chan<- prometheus.Metric) (*prome
 	return nil, nil
 }
 
+// hypervAdapterVMName returns the name of the virtual machine of a network
+// adapter instance. The instances are named <VM name>_<adapter name>_<id>,
+// names that do not follow this format are returned unchanged.
+func hypervAdapterVMName(name string) string {
+	i := strings.LastIndex(name, "_")
+	if i <= 0 {
+		return name
+	}
+	j := strings.LastIndex(name[:i], "_")
+	if j <= 0 {
+		return name
+	}
+	return name[:j]
+}
+
+// Msvm_ComputerSystem is a virtual machine (or the host) in the
+// root\virtualization\v2 namespace.
+type Msvm_ComputerSystem struct {
+	Name        string
+	ElementName string
+}
+
+// Msvm_StorageAllocationSettingData is a virtual hard disk attached to a
+// virtual machine.
+type Msvm_StorageAllocationSettingData struct {
+	InstanceID   string
+	HostResource []string
+}
+
+// queryStorageDeviceVMs maps the lower-cased names of the virtual storage
+// device instances to the names of the virtual machines they are attached
+// to. The instances are named after the path of the disk, with path
+// separators replaced by "-". Devices that cannot be mapped are left out.
+func (c *HyperVCollector) queryStorageDeviceVMs(ctx *ScrapeContext) map[string]string {
+	const namespace = "root\\virtualization\\v2"
+
+	var systems []Msvm_ComputerSystem
+	if err := ctx.wmiQueryNamespace(queryAll(&systems), &systems, namespace); err != nil {
+		log.Warnf("Could not query the virtual machines of storage devices: %v", err)
+		return nil
+	}
+	vmNames := make(map[string]string, len(systems))
+	for _, system := range systems {
+		vmNames[strings.ToLower(system.Name)] = system.ElementName
+	}
+
+	var disks []Msvm_StorageAllocationSettingData
+	if err := ctx.wmiQueryNamespace(queryAll(&disks), &disks, namespace); err != nil {
+		log.Warnf("Could not query the virtual machines of storage devices: %v", err)
+		return nil
+	}
+	deviceVMs := make(map[string]string)
+	for _, disk := range disks {
+		// The InstanceID is of the form Microsoft:<VM ID>\<device>...
+		id := strings.TrimPrefix(disk.InstanceID, "Microsoft:")
+		if i := strings.Index(id, "\\"); i >= 0 {
+			id = id[:i]
+		}
+		vmName, ok := vmNames[strings.ToLower(id)]
+		if !ok {
+			continue
+		}
+		for _, path := range disk.HostResource {
+			deviceVMs[strings.ToLower(strings.Replace(path, "\\", "-", -1))] = vmName
+		}
+	}
+	return deviceVMs
+}
+
 // Win32_PerfRawData_Counters_HyperVVirtualStorageDevice ...
 type Win32_PerfRawData_Counters_HyperVVirtualStorageDevice struct {
 	Name                  string
@@ -1295,17 +1365,26 @@ type Win32_PerfRawData_Counters_HyperVVirtualStorageDevice struct {
 	WriteOperationsPerSec uint64
 }
 
-func (c *HyperVCollector) collectVmStorage(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmStorage(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_Counters_HyperVVirtualStorageDevice
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
+	var deviceVMs map[string]string
+	if c.vmFilter.active() {
+		deviceVMs = c.queryStorageDeviceVMs(ctx)
+	}
+
 	for _, obj := range dst {
 		if strings.Contains(obj.Name, "_Total") {
 			continue
 		}
+		vmName := deviceVMs[strings.ToLower(obj.Name)]
+		if !c.vmFilter.keep(ctx, vmName, map[string]string{"vm": vmName, "vm_device": obj.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.VMStorageErrorCount,
@@ -1364,10 +1443,10 @@ type Win32_PerfRawData_NvspNicStats_HyperVVirtualNetworkAdapter struct {
 	PacketsSentPersec            uint64
 }
 
-func (c *HyperVCollector) collectVmNetwork(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *HyperVCollector) collectVmNetwork(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NvspNicStats_HyperVVirtualNetworkAdapter
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -1375,6 +1454,10 @@ func (c *HyperVCollector) collectVmNetwork(ch chan<- prometheus.Metric) (*promet
 		if strings.Contains(obj.Name, "_Total") {
 			continue
 		}
+		vmName := hypervAdapterVMName(obj.Name)
+		if !c.vmFilter.keep(ctx, vmName, map[string]string{"vm": vmName, "vm_interface": obj.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.VMNetworkBytesReceived,
@@ -0,0 +1,106 @@
+// +build windows
+
+package collector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHypervAdapterVMName(t *testing.T) {
+	data := map[string]string{
+		"web_01_Network Adapter_5C2E0B7A-1D6C-4F7A-9E5B-2A6D2F0F1A11--8D6B0E4E-3B7A-4E0B-A1F2-6C1D3B5E7F90": "web_01",
+		"db_Legacy Network Adapter_2F4E6A8C-0B1D-4E3F-8A5C-7E9B1D3F5A70":                                    "db",
+		"unexpected": "unexpected",
+	}
+	for in, out := range data {
+		if got := hypervAdapterVMName(in); got != out {
+			t.Error("expected", out, "got", got)
+		}
+	}
+}
+
+// hypervStorageWMI answers the WMI queries for two VHDs of the virtual
+// machines web and db, and a third disk not attached to any.
+func hypervStorageWMI(query string, dst interface{}, namespace string) error {
+	switch dst := dst.(type) {
+	case *[]Win32_PerfRawData_Counters_HyperVVirtualStorageDevice:
+		*dst = append(*dst,
+			Win32_PerfRawData_Counters_HyperVVirtualStorageDevice{Name: `D:-VMs-web-web.vhdx`, ReadOperationsPerSec: 10},
+			Win32_PerfRawData_Counters_HyperVVirtualStorageDevice{Name: `D:-VMs-db-db.vhdx`, ReadOperationsPerSec: 20},
+			Win32_PerfRawData_Counters_HyperVVirtualStorageDevice{Name: `D:-VMs-orphan.vhdx`, ReadOperationsPerSec: 30},
+			Win32_PerfRawData_Counters_HyperVVirtualStorageDevice{Name: "_Total"},
+		)
+	case *[]Msvm_ComputerSystem:
+		if namespace != `root\virtualization\v2` {
+			return errors.New("unexpected namespace " + namespace)
+		}
+		*dst = append(*dst,
+			Msvm_ComputerSystem{Name: "HOST01", ElementName: "HOST01"},
+			Msvm_ComputerSystem{Name: "5C2E0B7A-1D6C-4F7A-9E5B-2A6D2F0F1A11", ElementName: "web"},
+			Msvm_ComputerSystem{Name: "8D6B0E4E-3B7A-4E0B-A1F2-6C1D3B5E7F90", ElementName: "db"},
+		)
+	case *[]Msvm_StorageAllocationSettingData:
+		*dst = append(*dst,
+			Msvm_StorageAllocationSettingData{
+				InstanceID:   `Microsoft:5c2e0b7a-1d6c-4f7a-9e5b-2a6d2f0f1a11\83F8638B-8DCA-4152-9EDA-2CA8B33039B4\0\0\L`,
+				HostResource: []string{`D:\VMs\web\web.vhdx`},
+			},
+			Msvm_StorageAllocationSettingData{
+				InstanceID:   `Microsoft:8D6B0E4E-3B7A-4E0B-A1F2-6C1D3B5E7F90\83F8638B-8DCA-4152-9EDA-2CA8B33039B4\0\0\L`,
+				HostResource: []string{`D:\VMs\db\db.vhdx`},
+			},
+		)
+	default:
+		return errors.New("unexpected query " + query)
+	}
+	return nil
+}
+
+func TestHypervStorageFilter(t *testing.T) {
+	for _, tc := range []struct {
+		include, exclude []string
+		expected         []string
+	}{
+		{nil, nil, []string{`D:-VMs-web-web.vhdx`, `D:-VMs-db-db.vhdx`, `D:-VMs-orphan.vhdx`}},
+		{[]string{"web"}, nil, []string{`D:-VMs-web-web.vhdx`}},
+		{nil, []string{"db"}, []string{`D:-VMs-web-web.vhdx`, `D:-VMs-orphan.vhdx`}},
+	} {
+		filter, err := newInstanceFilter("hyperv", "vm", tc.include, tc.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewHyperVCollector()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hv := c.(*HyperVCollector)
+		hv.vmFilter = filter
+
+		ch := make(chan prometheus.Metric, 100)
+		if _, err := hv.collectVmStorage(newScrapeContext(nil, hypervStorageWMI), ch); err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+
+		devices := map[string]bool{}
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			devices[pb.GetLabel()[0].GetValue()] = true
+		}
+		if len(devices) != len(tc.expected) {
+			t.Errorf("include %v exclude %v: expected devices %v, got %v", tc.include, tc.exclude, tc.expected, devices)
+		}
+		for _, device := range tc.expected {
+			if !devices[device] {
+				t.Errorf("include %v exclude %v: expected device %s, got %v", tc.include, tc.exclude, device, devices)
+			}
+		}
+	}
+}
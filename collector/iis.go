@@ -4,12 +4,10 @@ package collector
 
 import (
 	"errors"
-	"fmt"
 	"regexp"
 
 	"golang.org/x/sys/windows/registry"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -57,12 +55,23 @@ func getIISVersion() simple_version {
 }
 
 var (
-	siteWhitelist = kingpin.Flag("collector.iis.site-whitelist", "Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included.").Default(".+").String()
-	siteBlacklist = kingpin.Flag("collector.iis.site-blacklist", "Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included.").String()
-	appWhitelist  = kingpin.Flag("collector.iis.app-whitelist", "Regexp of apps to whitelist. App name must both match whitelist and not match blacklist to be included.").Default(".+").String()
-	appBlacklist  = kingpin.Flag("collector.iis.app-blacklist", "Regexp of apps to blacklist. App name must both match whitelist and not match blacklist to be included.").String()
+	siteWhitelist   = kingpin.Flag("collector.iis.site-whitelist", "DEPRECATED: Use --collector.iis.site-include. Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included.").String()
+	siteBlacklist   = kingpin.Flag("collector.iis.site-blacklist", "DEPRECATED: Use --collector.iis.site-exclude. Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included.").String()
+	appWhitelist    = kingpin.Flag("collector.iis.app-whitelist", "DEPRECATED: Use --collector.iis.app-include. Regexp of apps to whitelist. App name must both match whitelist and not match blacklist to be included.").String()
+	appBlacklist    = kingpin.Flag("collector.iis.app-blacklist", "DEPRECATED: Use --collector.iis.app-exclude. Regexp of apps to blacklist. App name must both match whitelist and not match blacklist to be included.").String()
+	siteFilterFlags = newInstanceFilterFlags("iis", "site", "sites")
+	appFilterFlags  = newInstanceFilterFlags("iis", "app", "application pools")
+
+	iisEnabledCollectors = kingpin.Flag(
+		"collectors.iis.classes-enabled",
+		"Comma-separated list of iis WMI classes to use.").
+		Default(iisAvailableClassCollectors()).String()
 )
 
+func iisAvailableClassCollectors() string {
+	return "webservice,apppool,w3wp,webservicecache"
+}
+
 type IISCollector struct {
 	CurrentAnonymousUsers         *prometheus.Desc
 	CurrentBlockedAsyncIORequests *prometheus.Desc
@@ -87,8 +96,7 @@ type IISCollector struct {
 	TotalNotFoundErrors                 *prometheus.Desc
 	TotalRejectedAsyncIORequests        *prometheus.Desc
 
-	siteWhitelistPattern *regexp.Regexp
-	siteBlacklistPattern *regexp.Regexp
+	siteFilter *instanceFilter
 
 	CurrentApplicationPoolState        *prometheus.Desc
 	CurrentApplicationPoolUptime       *prometheus.Desc
@@ -185,10 +193,12 @@ type IISCollector struct {
 	ServiceCache_OutputCacheFlushedItemsTotal  *prometheus.Desc
 	ServiceCache_OutputCacheFlushesTotal       *prometheus.Desc
 
-	appWhitelistPattern *regexp.Regexp
-	appBlacklistPattern *regexp.Regexp
+	appFilter *instanceFilter
 
 	iis_version simple_version
+
+	classCollectors map[string]subCollectorFunc
+	subCollectors   *subCollectors
 }
 
 // NewIISCollector ...
@@ -327,8 +337,6 @@ func NewIISCollector() (Collector, error) {
 			nil,
 		),
 
-		siteWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteWhitelist)),
-		siteBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteBlacklist)),
 
 		// App Pools
 		// Guages
@@ -806,24 +814,39 @@ func NewIISCollector() (Collector, error) {
 			nil,
 			nil,
 		),
+	}
 
-		appWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *appWhitelist)),
-		appBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *appBlacklist)),
+	var err error
+	buildIIS.siteFilter, err = siteFilterFlags.newFilter(subsystem, "site", []string{*siteWhitelist}, []string{*siteBlacklist})
+	if err != nil {
+		return nil, err
+	}
+	buildIIS.appFilter, err = appFilterFlags.newFilter(subsystem, "app", []string{*appWhitelist}, []string{*appBlacklist})
+	if err != nil {
+		return nil, err
 	}
 
 	buildIIS.iis_version = getIISVersion()
 
+	buildIIS.classCollectors = map[string]subCollectorFunc{
+		"webservice":      buildIIS.collectWebService,
+		"apppool":         buildIIS.collectAPP_POOL_WAS,
+		"w3wp":            buildIIS.collectW3SVC_W3WP,
+		"webservicecache": buildIIS.collectWebServiceCache,
+	}
+
+	buildIIS.subCollectors, err = newSubCollectors(subsystem, iisAvailableClassCollectors(), *iisEnabledCollectors)
+	if err != nil {
+		return nil, err
+	}
+
 	return buildIIS, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *IISCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
-		log.Error("failed collecting iis metrics:", desc, err)
-		return err
-	}
-	return nil
+	return c.subCollectors.collect(ctx, ch, c.classCollectors)
 }
 
 type Win32_PerfRawData_W3SVC_WebService struct {
@@ -998,17 +1021,16 @@ var applicationStates = map[uint32]string{
 // W3SVCW3WPCounterProvider_W3SVCW3WP returns names prefixed with pid
 var workerProcessNameExtractor = regexp.MustCompile(`^(\d+)_(.+)$`)
 
-func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *IISCollector) collectWebService(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_W3SVC_WebService
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
 	for _, site := range dst {
 		if site.Name == "_Total" ||
-			c.siteBlacklistPattern.MatchString(site.Name) ||
-			!c.siteWhitelistPattern.MatchString(site.Name) {
+			!c.siteFilter.keep(ctx, site.Name, map[string]string{"site": site.Name}) {
 			continue
 		}
 
@@ -1252,16 +1274,19 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	}
 
+	return nil, nil
+}
+
+func (c *IISCollector) collectAPP_POOL_WAS(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst2 []Win32_PerfRawData_APPPOOLCountersProvider_APPPOOLWAS
 	q2 := queryAll(&dst2)
-	if err := wmi.Query(q2, &dst2); err != nil {
+	if err := ctx.wmiQuery(q2, &dst2); err != nil {
 		return nil, err
 	}
 
 	for _, app := range dst2 {
 		if app.Name == "_Total" ||
-			c.appBlacklistPattern.MatchString(app.Name) ||
-			!c.appWhitelistPattern.MatchString(app.Name) {
+			!c.appFilter.keep(ctx, app.Name, map[string]string{"app": app.Name}) {
 			continue
 		}
 
@@ -1369,22 +1394,25 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	}
 
+	return nil, nil
+}
+
+func (c *IISCollector) collectW3SVC_W3WP(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst_worker []Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP
-	q = queryAll(&dst_worker)
-	if err := wmi.Query(q, &dst_worker); err != nil {
+	q := queryAll(&dst_worker)
+	if err := ctx.wmiQuery(q, &dst_worker); err != nil {
 		return nil, err
 	}
 	for _, app := range dst_worker {
 		// Extract the apppool name from the format <PID>_<NAME>
 		name := workerProcessNameExtractor.ReplaceAllString(app.Name, "$2")
+		pid := workerProcessNameExtractor.ReplaceAllString(app.Name, "$1")
+		// Dropped application pools are already counted by collectAPP_POOL_WAS
 		if name == "_Total" ||
-			c.appBlacklistPattern.MatchString(name) ||
-			!c.appWhitelistPattern.MatchString(name) {
+			!c.appFilter.matches(name, map[string]string{"app": name, "pid": pid}) {
 			continue
 		}
 
-		pid := workerProcessNameExtractor.ReplaceAllString(app.Name, "$1")
-
 		ch <- prometheus.MustNewConstMetric(
 			c.ActiveFlushedEntries,
 			prometheus.GaugeValue,
@@ -1653,20 +1681,18 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 	if c.iis_version.major >= 8 {
 		var dst_worker_iis8 []Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP_IIS8
 		q = queryAllForClass(&dst_worker_iis8, "Win32_PerfRawData_W3SVCW3WPCounterProvider_W3SVCW3WP")
-		if err := wmi.Query(q, &dst_worker_iis8); err != nil {
+		if err := ctx.wmiQuery(q, &dst_worker_iis8); err != nil {
 			return nil, err
 		}
 		for _, app := range dst_worker_iis8 {
 			// Extract the apppool name from the format <PID>_<NAME>
 			name := workerProcessNameExtractor.ReplaceAllString(app.Name, "$2")
+			pid := workerProcessNameExtractor.ReplaceAllString(app.Name, "$1")
 			if name == "_Total" ||
-				c.appBlacklistPattern.MatchString(name) ||
-				!c.appWhitelistPattern.MatchString(name) {
+				!c.appFilter.matches(name, map[string]string{"app": name, "pid": pid}) {
 				continue
 			}
 
-			pid := workerProcessNameExtractor.ReplaceAllString(app.Name, "$1")
-
 			ch <- prometheus.MustNewConstMetric(
 				c.RequestErrorsTotal,
 				prometheus.CounterValue,
@@ -1734,9 +1760,13 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 		}
 	}
 
+	return nil, nil
+}
+
+func (c *IISCollector) collectWebServiceCache(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst_cache []Win32_PerfRawData_W3SVC_WebServiceCache
-	q = queryAll(&dst_cache)
-	if err := wmi.Query(q, &dst_cache); err != nil {
+	q := queryAll(&dst_cache)
+	if err := ctx.wmiQuery(q, &dst_cache); err != nil {
 		return nil, err
 	}
 
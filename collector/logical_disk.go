@@ -3,9 +3,6 @@
 package collector
 
 import (
-	"fmt"
-	"regexp"
-
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -18,12 +15,13 @@ func init() {
 var (
 	volumeWhitelist = kingpin.Flag(
 		"collector.logical_disk.volume-whitelist",
-		"Regexp of volumes to whitelist. Volume name must both match whitelist and not match blacklist to be included.",
-	).Default(".+").String()
+		"DEPRECATED: Use --collector.logical_disk.volume-include. Regexp of volumes to whitelist. Volume name must both match whitelist and not match blacklist to be included.",
+	).Default("").String()
 	volumeBlacklist = kingpin.Flag(
 		"collector.logical_disk.volume-blacklist",
-		"Regexp of volumes to blacklist. Volume name must both match whitelist and not match blacklist to be included.",
+		"DEPRECATED: Use --collector.logical_disk.volume-exclude. Regexp of volumes to blacklist. Volume name must both match whitelist and not match blacklist to be included.",
 	).Default("").String()
+	volumeFilterFlags = newInstanceFilterFlags("logical_disk", "volume", "volumes")
 )
 
 // A LogicalDiskCollector is a Prometheus collector for perflib logicalDisk metrics
@@ -43,14 +41,18 @@ type LogicalDiskCollector struct {
 	WriteLatency     *prometheus.Desc
 	ReadWriteLatency *prometheus.Desc
 
-	volumeWhitelistPattern *regexp.Regexp
-	volumeBlacklistPattern *regexp.Regexp
+	volumeFilter *instanceFilter
 }
 
 // NewLogicalDiskCollector ...
 func NewLogicalDiskCollector() (Collector, error) {
 	const subsystem = "logical_disk"
 
+	volumeFilter, err := volumeFilterFlags.newFilter(subsystem, "volume", []string{*volumeWhitelist}, []string{*volumeBlacklist})
+	if err != nil {
+		return nil, err
+	}
+
 	return &LogicalDiskCollector{
 		RequestsQueued: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "requests_queued"),
@@ -150,8 +152,7 @@ func NewLogicalDiskCollector() (Collector, error) {
 			nil,
 		),
 
-		volumeWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *volumeWhitelist)),
-		volumeBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *volumeBlacklist)),
+		volumeFilter: volumeFilter,
 	}, nil
 }
 
@@ -194,8 +195,7 @@ func (c *LogicalDiskCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.
 
 	for _, volume := range dst {
 		if volume.Name == "_Total" ||
-			c.volumeBlacklistPattern.MatchString(volume.Name) ||
-			!c.volumeWhitelistPattern.MatchString(volume.Name) {
+			!c.volumeFilter.keep(ctx, volume.Name, map[string]string{"volume": volume.Name}) {
 			continue
 		}
 
@@ -279,21 +279,21 @@ func (c *LogicalDiskCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.
 		ch <- prometheus.MustNewConstMetric(
 			c.ReadLatency,
 			prometheus.CounterValue,
-			volume.AvgDiskSecPerRead*ticksToSecondsScaleFactor,
+			volume.AvgDiskSecPerRead,
 			volume.Name,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.WriteLatency,
 			prometheus.CounterValue,
-			volume.AvgDiskSecPerWrite*ticksToSecondsScaleFactor,
+			volume.AvgDiskSecPerWrite,
 			volume.Name,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.ReadWriteLatency,
 			prometheus.CounterValue,
-			volume.AvgDiskSecPerTransfer*ticksToSecondsScaleFactor,
+			volume.AvgDiskSecPerTransfer,
 			volume.Name,
 		)
 	}
@@ -6,6 +6,11 @@
 package collector
 
 import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -20,13 +25,20 @@ type MemoryCollector struct {
 	CacheBytes                      *prometheus.Desc
 	CacheBytesPeak                  *prometheus.Desc
 	CacheFaultsTotal                *prometheus.Desc
+	CommitAvailableBytes            *prometheus.Desc
 	CommitLimit                     *prometheus.Desc
+	CommitPagingFileBackedBytes     *prometheus.Desc
+	CommitPressureRatio             *prometheus.Desc
 	CommittedBytes                  *prometheus.Desc
+	CompressedBytes                 *prometheus.Desc
 	DemandZeroFaultsTotal           *prometheus.Desc
 	FreeAndZeroPageListBytes        *prometheus.Desc
 	FreeSystemPageTableEntries      *prometheus.Desc
 	ModifiedPageListBytes           *prometheus.Desc
 	PageFaultsTotal                 *prometheus.Desc
+	PagingFileSizeBytes             *prometheus.Desc
+	PagingFileUsageBytes            *prometheus.Desc
+	PagingFileUsagePeakBytes        *prometheus.Desc
 	SwapPageReadsTotal              *prometheus.Desc
 	SwapPagesReadTotal              *prometheus.Desc
 	SwapPagesWrittenTotal           *prometheus.Desc
@@ -37,7 +49,9 @@ type MemoryCollector struct {
 	PoolPagedAllocsTotal            *prometheus.Desc
 	PoolPagedBytes                  *prometheus.Desc
 	PoolPagedResidentBytes          *prometheus.Desc
+	StandbyCacheBytes               *prometheus.Desc
 	StandbyCacheCoreBytes           *prometheus.Desc
+	StandbyCacheLifetimeSeconds     *prometheus.Desc
 	StandbyCacheNormalPriorityBytes *prometheus.Desc
 	StandbyCacheReserveBytes        *prometheus.Desc
 	SystemCacheResidentBytes        *prometheus.Desc
@@ -48,13 +62,19 @@ type MemoryCollector struct {
 	TransitionFaultsTotal           *prometheus.Desc
 	TransitionPagesRepurposedTotal  *prometheus.Desc
 	WriteCopiesTotal                *prometheus.Desc
+
+	// pageSize is the size of a page of the paging files and memory lists.
+	pageSize float64
+	// memoryLists reads the standby cache by priority, nil if the memory
+	// lists cannot be queried.
+	memoryLists func() (*memoryLists, error)
 }
 
 // NewMemoryCollector ...
 func NewMemoryCollector() (Collector, error) {
 	const subsystem = "memory"
 
-	return &MemoryCollector{
+	c := &MemoryCollector{
 		AvailableBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "available_bytes"),
 			"The amount of physical memory immediately available for allocation to a process or for system use. It is equal to the sum of memory assigned to"+
@@ -86,12 +106,36 @@ func NewMemoryCollector() (Collector, error) {
 			nil,
 			nil,
 		),
+		CommitAvailableBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "commit_available_bytes"),
+			"Amount of virtual memory, in bytes, that can still be committed without having to extend the paging file(s) (CommitLimit - CommittedBytes)",
+			nil,
+			nil,
+		),
+		CommitPagingFileBackedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "commit_paging_file_backed_bytes"),
+			"Amount of committed virtual memory, in bytes, beyond the part of the commit limit backed by physical memory, which must be backed by the paging file(s)",
+			nil,
+			nil,
+		),
+		CommitPressureRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "commit_pressure_ratio"),
+			"Ratio of committed virtual memory to the commit limit (CommittedBytes / CommitLimit)",
+			nil,
+			nil,
+		),
 		CommittedBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "committed_bytes"),
 			"(CommittedBytes)",
 			nil,
 			nil,
 		),
+		CompressedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "compressed_bytes"),
+			"Amount of memory, in bytes, held compressed in the memory compression store (CompressedBytes)",
+			nil,
+			nil,
+		),
 		DemandZeroFaultsTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "demand_zero_faults_total"),
 			"The number of zeroed pages required to satisfy faults. Zeroed pages, pages emptied of previously stored data and filled with zeros, are a security"+
@@ -153,6 +197,24 @@ func NewMemoryCollector() (Collector, error) {
 			nil,
 			nil,
 		),
+		PagingFileSizeBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "paging_file_size_bytes"),
+			"Size of the paging file in bytes",
+			[]string{"file"},
+			nil,
+		),
+		PagingFileUsageBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "paging_file_usage_bytes"),
+			"Amount of the paging file in use, in bytes",
+			[]string{"file"},
+			nil,
+		),
+		PagingFileUsagePeakBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "paging_file_usage_peak_bytes"),
+			"Peak amount of the paging file in use, in bytes",
+			[]string{"file"},
+			nil,
+		),
 		PoolNonpagedAllocsTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "pool_nonpaged_allocs_total"),
 			"The number of calls to allocate space in the nonpaged pool. The nonpaged pool is an area of system memory area for objects that cannot be written"+
@@ -184,12 +246,24 @@ func NewMemoryCollector() (Collector, error) {
 			nil,
 			nil,
 		),
+		StandbyCacheBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "standby_cache_bytes"),
+			"Amount of physical memory, in bytes, assigned to the standby cache by page priority",
+			[]string{"priority"},
+			nil,
+		),
 		StandbyCacheCoreBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "standby_cache_core_bytes"),
 			"(StandbyCacheCoreBytes)",
 			nil,
 			nil,
 		),
+		StandbyCacheLifetimeSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "standby_cache_lifetime_seconds"),
+			"Long-term average lifetime, in seconds, of pages in the standby cache (LongTermAverageStandbyCacheLifetimes)",
+			nil,
+			nil,
+		),
 		StandbyCacheNormalPriorityBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "standby_cache_normal_priority_bytes"),
 			"(StandbyCacheNormalPriorityBytes)",
@@ -250,7 +324,15 @@ func NewMemoryCollector() (Collector, error) {
 			nil,
 			nil,
 		),
-	}, nil
+		pageSize: float64(os.Getpagesize()),
+	}
+
+	if _, err := getMemoryLists(); err != nil {
+		log.Warnf("Could not read the memory lists, the standby cache by priority is not available: %v", err)
+	} else {
+		c.memoryLists = getMemoryLists
+	}
+	return c, nil
 }
 
 // Collect sends the metric values for each metric
@@ -272,9 +354,11 @@ type memory struct {
 	CacheFaultsPersec               float64 `perflib:"Cache Faults/sec"`
 	CommitLimit                     float64 `perflib:"Commit Limit"`
 	CommittedBytes                  float64 `perflib:"Committed Bytes"`
+	CompressedBytes                 float64 `perflib:"Compressed Bytes"`
 	DemandZeroFaultsPersec          float64 `perflib:"Demand Zero Faults/sec"`
 	FreeAndZeroPageListBytes        float64 `perflib:"Free & Zero Page List Bytes"`
 	FreeSystemPageTableEntries      float64 `perflib:"Free System Page Table Entries"`
+	LongTermStandbyCacheLifetime    float64 `perflib:"Long-Term Average Standby Cache Lifetime (s)"`
 	ModifiedPageListBytes           float64 `perflib:"Modified Page List Bytes"`
 	PageFaultsPersec                float64 `perflib:"Page Faults/sec"`
 	PageReadsPersec                 float64 `perflib:"Page Reads/sec"`
@@ -342,6 +426,12 @@ func (c *MemoryCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metri
 		dst[0].CommittedBytes,
 	)
 
+	ch <- prometheus.MustNewConstMetric(
+		c.CompressedBytes,
+		prometheus.GaugeValue,
+		dst[0].CompressedBytes,
+	)
+
 	ch <- prometheus.MustNewConstMetric(
 		c.DemandZeroFaultsTotal,
 		prometheus.GaugeValue,
@@ -438,6 +528,12 @@ func (c *MemoryCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metri
 		dst[0].StandbyCacheCoreBytes,
 	)
 
+	ch <- prometheus.MustNewConstMetric(
+		c.StandbyCacheLifetimeSeconds,
+		prometheus.GaugeValue,
+		dst[0].LongTermStandbyCacheLifetime,
+	)
+
 	ch <- prometheus.MustNewConstMetric(
 		c.StandbyCacheNormalPriorityBytes,
 		prometheus.GaugeValue,
@@ -498,5 +594,101 @@ func (c *MemoryCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metri
 		dst[0].WriteCopiesPersec,
 	)
 
+	pagingFileBytes := c.collectPagingFiles(ctx, ch)
+	c.collectCommit(ch, dst[0], pagingFileBytes)
+
+	if c.memoryLists != nil {
+		lists, err := c.memoryLists()
+		if err != nil {
+			return c.StandbyCacheBytes, err
+		}
+		for priority, pages := range lists.standbyPagesByPriority {
+			ch <- prometheus.MustNewConstMetric(
+				c.StandbyCacheBytes,
+				prometheus.GaugeValue,
+				float64(pages)*c.pageSize,
+				strconv.Itoa(priority),
+			)
+		}
+	}
+
 	return nil, nil
 }
+
+// collectPagingFiles reports the usage of each paging file and returns the
+// total size of the paging files in bytes.
+func (c *MemoryCollector) collectPagingFiles(ctx *ScrapeContext, ch chan<- prometheus.Metric) float64 {
+	// The "Paging File" object is absent if no paging file is configured.
+	var files []pagingFile
+	if err := unmarshalObject(ctx.perfObjects["Paging File"], &files); err != nil {
+		return 0
+	}
+
+	var size float64
+	for _, file := range files {
+		if file.Name == "_Total" {
+			continue
+		}
+		// Instances are named by the NT path of the file, such as
+		// \??\C:\pagefile.sys.
+		name := strings.TrimPrefix(file.Name, `\??\`)
+		size += file.UsageBase * c.pageSize
+
+		ch <- prometheus.MustNewConstMetric(
+			c.PagingFileSizeBytes,
+			prometheus.GaugeValue,
+			file.UsageBase*c.pageSize,
+			name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.PagingFileUsageBytes,
+			prometheus.GaugeValue,
+			file.Usage*c.pageSize,
+			name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.PagingFileUsagePeakBytes,
+			prometheus.GaugeValue,
+			file.UsagePeak*c.pageSize,
+			name,
+		)
+	}
+	return size
+}
+
+// collectCommit reports how close the committed memory is to the commit
+// limit. The commit limit is the sum of the physical memory and the size of
+// the paging files, commit charge beyond the physical part of the limit can
+// only be backed by the paging files.
+func (c *MemoryCollector) collectCommit(ch chan<- prometheus.Metric, mem memory, pagingFileBytes float64) {
+	ch <- prometheus.MustNewConstMetric(
+		c.CommitAvailableBytes,
+		prometheus.GaugeValue,
+		mem.CommitLimit-mem.CommittedBytes,
+	)
+
+	if mem.CommitLimit > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.CommitPressureRatio,
+			prometheus.GaugeValue,
+			mem.CommittedBytes/mem.CommitLimit,
+		)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.CommitPagingFileBackedBytes,
+		prometheus.GaugeValue,
+		math.Max(0, mem.CommittedBytes-(mem.CommitLimit-pagingFileBytes)),
+	)
+}
+
+type pagingFile struct {
+	Name string
+
+	Usage         float64 `perflib:"% Usage"`
+	UsageBase     float64 `perflib:"% Usage_Base"`
+	UsagePeak     float64 `perflib:"% Usage Peak"`
+	UsagePeakBase float64 `perflib:"% Usage Peak_Base"`
+}
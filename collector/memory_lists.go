@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// standbyPriorities is the number of priorities of the standby page list.
+const standbyPriorities = 8
+
+// memoryLists holds the page counts of the physical memory lists, as
+// returned for SystemMemoryListInformation.
+type memoryLists struct {
+	standbyPagesByPriority [standbyPriorities]uint64
+}
+
+// parseMemoryListInformation parses a SYSTEM_MEMORY_LIST_INFORMATION
+// structure. ptrSize is the size of ULONG_PTR on the platform that returned
+// the structure.
+func parseMemoryListInformation(buf []byte, ptrSize int) (*memoryLists, error) {
+	// ZeroPageCount, FreePageCount, ModifiedPageCount,
+	// ModifiedNoWritePageCount and BadPageCount precede PageCountByPriority.
+	const priorityIndex = 5
+
+	if ptrSize != 4 && ptrSize != 8 {
+		return nil, fmt.Errorf("unsupported pointer size %d", ptrSize)
+	}
+	if want := (priorityIndex + standbyPriorities) * ptrSize; len(buf) < want {
+		return nil, fmt.Errorf("memory list information is %d bytes, want at least %d", len(buf), want)
+	}
+
+	l := &memoryLists{}
+	for i := range l.standbyPagesByPriority {
+		b := buf[(priorityIndex+i)*ptrSize:]
+		if ptrSize == 8 {
+			l.standbyPagesByPriority[i] = binary.LittleEndian.Uint64(b)
+		} else {
+			l.standbyPagesByPriority[i] = uint64(binary.LittleEndian.Uint32(b))
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseMemoryListInformation(t *testing.T) {
+	priorities := [standbyPriorities]uint64{10, 0, 20, 30, 40, 1 << 33, 60, 70}
+
+	for _, ptrSize := range []int{4, 8} {
+		// SYSTEM_MEMORY_LIST_INFORMATION is 22 ULONG_PTR fields.
+		buf := make([]byte, 22*ptrSize)
+		for i := range buf[:5*ptrSize] {
+			buf[i] = 0xff
+		}
+		for i, pages := range priorities {
+			if ptrSize == 8 {
+				binary.LittleEndian.PutUint64(buf[(5+i)*ptrSize:], pages)
+			} else {
+				binary.LittleEndian.PutUint32(buf[(5+i)*ptrSize:], uint32(pages))
+			}
+		}
+
+		lists, err := parseMemoryListInformation(buf, ptrSize)
+		if err != nil {
+			t.Fatalf("%d byte pointers: %v", ptrSize, err)
+		}
+		for i, pages := range lists.standbyPagesByPriority {
+			expected := priorities[i]
+			if ptrSize == 4 {
+				expected = uint64(uint32(expected))
+			}
+			if pages != expected {
+				t.Errorf("%d byte pointers: expected %d pages of priority %d, got %d", ptrSize, expected, i, pages)
+			}
+		}
+	}
+}
+
+func TestParseMemoryListInformationTruncated(t *testing.T) {
+	if _, err := parseMemoryListInformation(make([]byte, 12*8), 8); err == nil {
+		t.Error("Expected an error for truncated memory list information")
+	}
+	if _, err := parseMemoryListInformation(make([]byte, 22*2), 2); err == nil {
+		t.Error("Expected an error for an unsupported pointer size")
+	}
+}
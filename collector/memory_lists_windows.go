@@ -0,0 +1,33 @@
+// +build windows
+
+package collector
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procNtQuerySystemInformation = windows.NewLazySystemDLL("ntdll.dll").NewProc("NtQuerySystemInformation")
+
+// getMemoryLists reads the page counts of the physical memory lists.
+func getMemoryLists() (*memoryLists, error) {
+	const (
+		systemMemoryListInformation = 80
+		// SYSTEM_MEMORY_LIST_INFORMATION consists of 22 ULONG_PTR fields.
+		memoryListInformationSize = 22 * unsafe.Sizeof(uintptr(0))
+	)
+
+	if err := procNtQuerySystemInformation.Find(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, memoryListInformationSize)
+	var size uint32
+	status, _, _ := procNtQuerySystemInformation.Call(systemMemoryListInformation, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), uintptr(unsafe.Pointer(&size)))
+	if status != 0 {
+		return nil, fmt.Errorf("NtQuerySystemInformation failed with status 0x%08x", status)
+	}
+	return parseMemoryListInformation(buf[:size], int(unsafe.Sizeof(uintptr(0))))
+}
@@ -0,0 +1,143 @@
+// +build windows
+
+package collector
+
+import (
+	"errors"
+
+	"strings"
+	"testing"
+
+	perflibCollector "github.com/leoluk/perflib_exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorded on Windows Server 2019 with 8 GiB of memory and paging files on
+// C: and D:.
+var recordedMemory = recordedInstances(
+	[]string{""},
+	[]recordedCounter{
+		{"Available Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{3221225472}},
+		{"Commit Limit", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{10200547328}},
+		{"Committed Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{9663676416}},
+		{"Compressed Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{201326592}},
+		{"Long-Term Average Standby Cache Lifetime (s)", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{14400}},
+		{"Standby Cache Core Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{134217728}},
+		{"Standby Cache Normal Priority Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{1073741824}},
+		{"Standby Cache Reserve Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{536870912}},
+	},
+)
+
+var recordedPagingFile = recordedInstances(
+	[]string{`\??\C:\pagefile.sys`, `\??\D:\pagefile.sys`, "_Total"},
+	[]recordedCounter{
+		{"% Usage", perflibCollector.PERF_RAW_FRACTION, []int64{104857, 65536, 170393}},
+		{"% Usage", perflibCollector.PERF_RAW_BASE, []int64{262144, 131072, 393216}},
+		{"% Usage Peak", perflibCollector.PERF_RAW_FRACTION, []int64{157286, 98304, 255590}},
+		{"% Usage Peak", perflibCollector.PERF_RAW_BASE, []int64{262144, 131072, 393216}},
+	},
+)
+
+// Recorded standby page counts for priorities 0 to 7. The reserve, normal
+// priority and core standby cache are priorities 0-3, 4-5 and 6-7.
+var recordedStandbyPages = [standbyPriorities]uint64{2048, 0, 96256, 32768, 65536, 196608, 16384, 16384}
+
+func newMemoryFixtureCollector(t *testing.T, lists func() (*memoryLists, error)) *MemoryCollector {
+	c, err := NewMemoryCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := c.(*MemoryCollector)
+	mc.pageSize = 4096
+	mc.memoryLists = lists
+	return mc
+}
+
+func TestMemoryCollector(t *testing.T) {
+	c := newMemoryFixtureCollector(t, func() (*memoryLists, error) {
+		return &memoryLists{standbyPagesByPriority: recordedStandbyPages}, nil
+	})
+	ctx := newScrapeContext(perflibObjects{
+		"Memory":      newPerflibFixture("Memory", 10000000, recordedMemory...),
+		"Paging File": newPerflibFixture("Paging File", 10000000, recordedPagingFile...),
+	}, nil)
+
+	samples := parseSamples(t, gatherCollector(t, c, ctx))
+	checkSamples(t, samples, map[string]float64{
+		`wmi_memory_available_bytes`:                                       3221225472,
+		`wmi_memory_commit_limit`:                                          10200547328,
+		`wmi_memory_committed_bytes`:                                       9663676416,
+		`wmi_memory_compressed_bytes`:                                      201326592,
+		`wmi_memory_standby_cache_lifetime_seconds`:                        14400,
+		`wmi_memory_standby_cache_core_bytes`:                              134217728,
+		`wmi_memory_standby_cache_normal_priority_bytes`:                   1073741824,
+		`wmi_memory_standby_cache_reserve_bytes`:                           536870912,
+		`wmi_memory_paging_file_size_bytes{file="C:\\pagefile.sys"}`:       262144 * 4096,
+		`wmi_memory_paging_file_usage_bytes{file="C:\\pagefile.sys"}`:      104857 * 4096,
+		`wmi_memory_paging_file_usage_peak_bytes{file="C:\\pagefile.sys"}`: 157286 * 4096,
+		`wmi_memory_paging_file_size_bytes{file="D:\\pagefile.sys"}`:       131072 * 4096,
+		`wmi_memory_paging_file_usage_bytes{file="D:\\pagefile.sys"}`:      65536 * 4096,
+		`wmi_memory_paging_file_usage_peak_bytes{file="D:\\pagefile.sys"}`: 98304 * 4096,
+		`wmi_memory_commit_available_bytes`:                                10200547328 - 9663676416,
+		`wmi_memory_commit_pressure_ratio`:                                 9663676416.0 / 10200547328.0,
+		`wmi_memory_commit_paging_file_backed_bytes`:                       9663676416 - (10200547328 - 393216*4096),
+		`wmi_memory_standby_cache_bytes{priority="0"}`:                     2048 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="1"}`:                     0,
+		`wmi_memory_standby_cache_bytes{priority="2"}`:                     96256 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="3"}`:                     32768 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="4"}`:                     65536 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="5"}`:                     196608 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="6"}`:                     16384 * 4096,
+		`wmi_memory_standby_cache_bytes{priority="7"}`:                     16384 * 4096,
+	})
+
+	// The tiers of the standby cache add up to the perflib standby counters.
+	var priorities float64
+	for _, k := range []string{"0", "1", "2", "3", "4", "5", "6", "7"} {
+		priorities += samples[`wmi_memory_standby_cache_bytes{priority="`+k+`"}`]
+	}
+	standby := samples["wmi_memory_standby_cache_core_bytes"] + samples["wmi_memory_standby_cache_normal_priority_bytes"] + samples["wmi_memory_standby_cache_reserve_bytes"]
+	if priorities != standby {
+		t.Errorf("Expected the standby cache priorities to add up to %v, got %v", standby, priorities)
+	}
+}
+
+func TestMemoryCollectorWithoutPagingFile(t *testing.T) {
+	c := newMemoryFixtureCollector(t, nil)
+	ctx := newScrapeContext(perflibObjects{
+		"Memory": newPerflibFixture("Memory", 10000000, recordedInstances(
+			[]string{""},
+			[]recordedCounter{
+				{"Commit Limit", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{8388608000}},
+				{"Committed Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, []int64{4194304000}},
+			},
+		)...),
+	}, nil)
+
+	samples := parseSamples(t, gatherCollector(t, c, ctx))
+	checkSamples(t, samples, map[string]float64{
+		`wmi_memory_commit_available_bytes`:          4194304000,
+		`wmi_memory_commit_pressure_ratio`:           0.5,
+		`wmi_memory_commit_paging_file_backed_bytes`: 0,
+	})
+	for k := range samples {
+		for _, prefix := range []string{"wmi_memory_paging_file_", "wmi_memory_standby_cache_bytes{"} {
+			if strings.HasPrefix(k, prefix) {
+				t.Errorf("Unexpected sample %s", k)
+			}
+		}
+	}
+}
+
+func TestMemoryCollectorMemoryListsError(t *testing.T) {
+	c := newMemoryFixtureCollector(t, func() (*memoryLists, error) {
+		return nil, errors.New("access denied")
+	})
+	ctx := newScrapeContext(perflibObjects{
+		"Memory": newPerflibFixture("Memory", 10000000, recordedMemory...),
+	}, nil)
+
+	if err := c.Collect(ctx, make(chan prometheus.Metric, 200)); err == nil {
+		t.Error("Expected an error if the memory lists cannot be read")
+	}
+}
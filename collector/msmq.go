@@ -3,9 +3,9 @@
 package collector
 
 import (
+	"fmt"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -16,7 +16,8 @@ func init() {
 }
 
 var (
-	msmqWhereClause = kingpin.Flag("collector.msmq.msmq-where", "WQL 'where' clause to use in WMI metrics query. Limits the response to the msmqs you specify and reduces the size of the response.").String()
+	msmqWhereClause  = kingpin.Flag("collector.msmq.msmq-where", "WQL 'where' clause to use in WMI metrics query. Limits the response to the msmqs you specify and reduces the size of the response.").String()
+	queueFilterFlags = newInstanceFilterFlags("msmq", "queue", "queues")
 )
 
 // A Win32_PerfRawData_MSMQ_MSMQQueueCollector is a Prometheus collector for WMI Win32_PerfRawData_MSMQ_MSMQQueue metrics
@@ -27,14 +28,24 @@ type Win32_PerfRawData_MSMQ_MSMQQueueCollector struct {
 	MessagesinQueue        *prometheus.Desc
 
 	queryWhereClause string
+	queueFilter      *instanceFilter
 }
 
 // NewWin32_PerfRawData_MSMQ_MSMQQueueCollector ...
 func NewMSMQCollector() (Collector, error) {
 	const subsystem = "msmq"
 
-	if *msmqWhereClause == "" {
-		log.Warn("No where-clause specified for msmq collector. This will generate a very large number of metrics!")
+	if err := validateWhereClause(&[]Win32_PerfRawData_MSMQ_MSMQQueue{}, *msmqWhereClause); err != nil {
+		return nil, fmt.Errorf("--collector.msmq.msmq-where: %v", err)
+	}
+
+	queueFilter, err := queueFilterFlags.newFilter(subsystem, "queue", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if *msmqWhereClause == "" && len(queueFilter.include) == 0 {
+		log.Warn("No where-clause or include filter specified for msmq collector. This will generate a very large number of metrics!")
 	}
 
 	return &Win32_PerfRawData_MSMQ_MSMQQueueCollector{
@@ -63,13 +74,14 @@ func NewMSMQCollector() (Collector, error) {
 			nil,
 		),
 		queryWhereClause: *msmqWhereClause,
+		queueFilter:      queueFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *Win32_PerfRawData_MSMQ_MSMQQueueCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting msmq metrics:", desc, err)
 		return err
 	}
@@ -85,10 +97,10 @@ type Win32_PerfRawData_MSMQ_MSMQQueue struct {
 	MessagesinQueue        uint64
 }
 
-func (c *Win32_PerfRawData_MSMQ_MSMQQueueCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *Win32_PerfRawData_MSMQ_MSMQQueueCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_MSMQ_MSMQQueue
 	q := queryAllWhere(&dst, c.queryWhereClause)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -98,6 +110,11 @@ func (c *Win32_PerfRawData_MSMQ_MSMQQueueCollector) collect(ch chan<- prometheus
 			continue
 		}
 
+		name := strings.ToLower(msmq.Name)
+		if !c.queueFilter.keep(ctx, name, map[string]string{"name": name}) {
+			continue
+		}
+
 		ch <- prometheus.MustNewConstMetric(
 			c.BytesinJournalQueue,
 			prometheus.GaugeValue,
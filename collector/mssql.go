@@ -8,9 +8,7 @@ import (
 	"os"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"golang.org/x/sys/windows/registry"
@@ -27,6 +25,8 @@ var (
 		"collectors.mssql.class-print",
 		"If true, print available mssql WMI classes and exit.  Only displays if the mssql collector is enabled.",
 	).Bool()
+
+	databaseFilterFlags = newInstanceFilterFlags("mssql", "database", "databases")
 )
 
 type mssqlInstancesType map[string]string
@@ -88,42 +88,31 @@ func mssqlBuildWMIInstanceClass(suffix string, instance string) string {
 	return fmt.Sprintf("Win32_PerfRawData_%s%s", instancePart, suffix)
 }
 
-type mssqlCollectorsMap map[string]mssqlCollectorFunc
-
 func mssqlAvailableClassCollectors() string {
 	return "accessmethods,availreplica,bufman,databases,dbreplica,genstats,locks,memmgr,sqlstats,sqlerrors,transactions"
 }
 
-func (c *MSSQLCollector) getMSSQLCollectors() mssqlCollectorsMap {
-	mssqlCollectors := make(mssqlCollectorsMap)
-	mssqlCollectors["accessmethods"] = c.collectAccessMethods
-	mssqlCollectors["availreplica"] = c.collectAvailabilityReplica
-	mssqlCollectors["bufman"] = c.collectBufferManager
-	mssqlCollectors["databases"] = c.collectDatabases
-	mssqlCollectors["dbreplica"] = c.collectDatabaseReplica
-	mssqlCollectors["genstats"] = c.collectGeneralStatistics
-	mssqlCollectors["locks"] = c.collectLocks
-	mssqlCollectors["memmgr"] = c.collectMemoryManager
-	mssqlCollectors["sqlstats"] = c.collectSQLStats
-	mssqlCollectors["sqlerrors"] = c.collectSQLErrors
-	mssqlCollectors["transactions"] = c.collectTransactions
-
-	return mssqlCollectors
-}
-
-func mssqlExpandEnabledCollectors(enabled string) []string {
-	separated := strings.Split(enabled, ",")
-	unique := map[string]bool{}
-	for _, s := range separated {
-		if s != "" {
-			unique[s] = true
+func (c *MSSQLCollector) getMSSQLCollectors(sqlInstance string) map[string]subCollectorFunc {
+	bind := func(fn mssqlCollectorFunc) subCollectorFunc {
+		return func(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+			return fn(ctx, ch, sqlInstance)
 		}
 	}
-	result := make([]string, 0, len(unique))
-	for s := range unique {
-		result = append(result, s)
-	}
-	return result
+
+	mssqlCollectors := make(map[string]subCollectorFunc)
+	mssqlCollectors["accessmethods"] = bind(c.collectAccessMethods)
+	mssqlCollectors["availreplica"] = bind(c.collectAvailabilityReplica)
+	mssqlCollectors["bufman"] = bind(c.collectBufferManager)
+	mssqlCollectors["databases"] = bind(c.collectDatabases)
+	mssqlCollectors["dbreplica"] = bind(c.collectDatabaseReplica)
+	mssqlCollectors["genstats"] = bind(c.collectGeneralStatistics)
+	mssqlCollectors["locks"] = bind(c.collectLocks)
+	mssqlCollectors["memmgr"] = bind(c.collectMemoryManager)
+	mssqlCollectors["sqlstats"] = bind(c.collectSQLStats)
+	mssqlCollectors["sqlerrors"] = bind(c.collectSQLErrors)
+	mssqlCollectors["transactions"] = bind(c.collectTransactions)
+
+	return mssqlCollectors
 }
 
 func init() {
@@ -132,10 +121,6 @@ func init() {
 
 // A MSSQLCollector is a Prometheus collector for various WMI Win32_PerfRawData_MSSQLSERVER_* metrics
 type MSSQLCollector struct {
-	// meta
-	mssqlScrapeDurationDesc *prometheus.Desc
-	mssqlScrapeSuccessDesc  *prometheus.Desc
-
 	// Win32_PerfRawData_{instance}_SQLServerAccessMethods
 	AccessMethodsAUcleanupbatches             *prometheus.Desc
 	AccessMethodsAUcleanups                   *prometheus.Desc
@@ -378,9 +363,9 @@ type MSSQLCollector struct {
 	TransactionsVersionStoreCreationUnits        *prometheus.Desc
 	TransactionsVersionStoreTruncationUnits      *prometheus.Desc
 
-	mssqlInstances             mssqlInstancesType
-	mssqlCollectors            mssqlCollectorsMap
-	mssqlChildCollectorFailure int
+	mssqlInstances  mssqlInstancesType
+	mssqlCollectors *subCollectors
+	databaseFilter  *instanceFilter
 }
 
 // NewMSSQLCollector ...
@@ -389,20 +374,6 @@ func NewMSSQLCollector() (Collector, error) {
 	const subsystem = "mssql"
 
 	mssqlCollector := MSSQLCollector{
-		// meta
-		mssqlScrapeDurationDesc: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "collector_duration_seconds"),
-			"wmi_exporter: Duration of an mssql child collection.",
-			[]string{"collector", "instance"},
-			nil,
-		),
-		mssqlScrapeSuccessDesc: prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subsystem, "collector_success"),
-			"wmi_exporter: Whether a mssql child collector was successful.",
-			[]string{"collector", "instance"},
-			nil,
-		),
-
 		// Win32_PerfRawData_{instance}_SQLServerAccessMethods
 		AccessMethodsAUcleanupbatches: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "accessmethods_au_batch_cleanups"),
@@ -1748,72 +1719,56 @@ func NewMSSQLCollector() (Collector, error) {
 		mssqlInstances: getMSSQLInstances(),
 	}
 
-	mssqlCollector.mssqlCollectors = mssqlCollector.getMSSQLCollectors()
-
 	if *mssqlPrintCollectors {
 		fmt.Printf("Available SQLServer Classes:\n")
-		for name := range mssqlCollector.mssqlCollectors {
+		for _, name := range expandEnabledClasses(mssqlAvailableClassCollectors()) {
 			fmt.Printf(" - %s\n", name)
 		}
 		os.Exit(0)
 	}
 
-	return &mssqlCollector, nil
-}
-
-type mssqlCollectorFunc func(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error)
-
-func (c *MSSQLCollector) execute(name string, fn mssqlCollectorFunc, ch chan<- prometheus.Metric, sqlInstance string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	begin := time.Now()
-	_, err := fn(ch, sqlInstance)
-	duration := time.Since(begin)
-	var success float64
+	var err error
+	mssqlCollector.mssqlCollectors, err = newSubCollectors(subsystem, mssqlAvailableClassCollectors(), *mssqlEnabledCollectors, "instance")
+	if err != nil {
+		return nil, err
+	}
 
+	mssqlCollector.databaseFilter, err = databaseFilterFlags.newFilter(subsystem, "database", nil, nil)
 	if err != nil {
-		log.Errorf("mssql class collector %s failed after %fs: %s", name, duration.Seconds(), err)
-		success = 0
-		c.mssqlChildCollectorFailure++
-	} else {
-		log.Debugf("mssql class collector %s succeeded after %fs.", name, duration.Seconds())
-		success = 1
+		return nil, err
 	}
-	ch <- prometheus.MustNewConstMetric(
-		c.mssqlScrapeDurationDesc,
-		prometheus.GaugeValue,
-		duration.Seconds(),
-		name, sqlInstance,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.mssqlScrapeSuccessDesc,
-		prometheus.GaugeValue,
-		success,
-		name, sqlInstance,
-	)
+
+	return &mssqlCollector, nil
 }
 
+type mssqlCollectorFunc func(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error)
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *MSSQLCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	wg := sync.WaitGroup{}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
 
-	enabled := mssqlExpandEnabledCollectors(*mssqlEnabledCollectors)
 	for sqlInstance := range c.mssqlInstances {
-		for _, name := range enabled {
-			function := c.mssqlCollectors[name]
-
-			wg.Add(1)
-			go c.execute(name, function, ch, sqlInstance, &wg)
-		}
+		wg.Add(1)
+		go func(sqlInstance string) {
+			defer wg.Done()
+			err := c.mssqlCollectors.collect(ctx, ch, c.getMSSQLCollectors(sqlInstance), sqlInstance)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("instance %s: %v", sqlInstance, err)
+				}
+				mu.Unlock()
+			}
+		}(sqlInstance)
 	}
 	wg.Wait()
 
-	// this shoud return an error if any? some? children errord.
-	if c.mssqlChildCollectorFailure > 0 {
-		return errors.New("at least one child collector failed")
-	}
-	return nil
+	return firstErr
 }
 
 // win32PerfRawDataSQLServerAccessMethods docs:
@@ -1865,13 +1820,13 @@ type win32PerfRawDataSQLServerAccessMethods struct {
 	WorktablesFromCacheRatio      uint64
 }
 
-func (c *MSSQLCollector) collectAccessMethods(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectAccessMethods(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerAccessMethods
 	log.Debugf("mssql_accessmethods collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("AccessMethods", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -2198,18 +2153,21 @@ type win32PerfRawDataSQLServerAvailabilityReplica struct {
 	SendstoTransportPersec         uint64
 }
 
-func (c *MSSQLCollector) collectAvailabilityReplica(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectAvailabilityReplica(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerAvailabilityReplica
 	log.Debugf("mssql_availreplica collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("AvailabilityReplica", sqlInstance)
-	q := queryAllForClassWhere(&dst, class, `Name <> '_Total'`)
-	if err := wmi.Query(q, &dst); err != nil {
+	q := queryAllForClassWhere(&dst, class, wqlCompare("Name", "<>", "_Total"))
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
 	for _, v := range dst {
 		replicaName := v.Name
+		if !c.databaseFilter.keep(ctx, replicaName, map[string]string{"instance": sqlInstance, "replica": replicaName}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.AvailReplicaBytesReceivedfromReplica,
@@ -2304,13 +2262,13 @@ type win32PerfRawDataSQLServerBufferManager struct {
 	Targetpages                   uint64
 }
 
-func (c *MSSQLCollector) collectBufferManager(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectBufferManager(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerBufferManager
 	log.Debugf("mssql_bufman collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("BufferManager", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -2506,18 +2464,21 @@ type win32PerfRawDataSQLServerDatabaseReplica struct {
 	TransactionDelay                uint64
 }
 
-func (c *MSSQLCollector) collectDatabaseReplica(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectDatabaseReplica(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerDatabaseReplica
 	log.Debugf("mssql_dbreplica collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("DatabaseReplica", sqlInstance)
-	q := queryAllForClassWhere(&dst, class, `Name <> '_Total'`)
-	if err := wmi.Query(q, &dst); err != nil {
+	q := queryAllForClassWhere(&dst, class, wqlCompare("Name", "<>", "_Total"))
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
 	for _, v := range dst {
 		replicaName := v.Name
+		if !c.databaseFilter.keep(ctx, replicaName, map[string]string{"instance": sqlInstance, "replica": replicaName}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.DBReplicaDatabaseFlowControlDelay,
@@ -2742,18 +2703,21 @@ type win32PerfRawDataSQLServerDatabases struct {
 	XTPMemoryUsedKB                  uint64
 }
 
-func (c *MSSQLCollector) collectDatabases(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectDatabases(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerDatabases
 	log.Debugf("mssql_databases collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("Databases", sqlInstance)
-	q := queryAllForClassWhere(&dst, class, `Name <> '_Total'`)
-	if err := wmi.Query(q, &dst); err != nil {
+	q := queryAllForClassWhere(&dst, class, wqlCompare("Name", "<>", "_Total"))
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
 	for _, v := range dst {
 		dbName := v.Name
+		if !c.databaseFilter.keep(ctx, dbName, map[string]string{"instance": sqlInstance, "database": dbName}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.DatabasesActiveTransactions,
@@ -3109,13 +3073,13 @@ type win32PerfRawDataSQLServerGeneralStatistics struct {
 	UserConnections               uint64
 }
 
-func (c *MSSQLCollector) collectGeneralStatistics(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectGeneralStatistics(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerGeneralStatistics
 	log.Debugf("mssql_genstats collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("GeneralStatistics", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -3307,13 +3271,13 @@ type win32PerfRawDataSQLServerLocks struct {
 	NumberofDeadlocksPersec    uint64
 }
 
-func (c *MSSQLCollector) collectLocks(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectLocks(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerLocks
 	log.Debugf("mssql_locks collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("Locks", sqlInstance)
-	q := queryAllForClassWhere(&dst, class, `Name <> '_Total'`)
-	if err := wmi.Query(q, &dst); err != nil {
+	q := queryAllForClassWhere(&dst, class, wqlCompare("Name", "<>", "_Total"))
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -3397,13 +3361,13 @@ type win32PerfRawDataSQLServerMemoryManager struct {
 	TotalServerMemoryKB      uint64
 }
 
-func (c *MSSQLCollector) collectMemoryManager(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectMemoryManager(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerMemoryManager
 	log.Debugf("mssql_memmgr collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("MemoryManager", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 	if len(dst) == 0 {
@@ -3571,13 +3535,13 @@ type win32PerfRawDataSQLServerSQLStatistics struct {
 	UnsafeAutoParamsPersec        uint64
 }
 
-func (c *MSSQLCollector) collectSQLStats(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectSQLStats(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerSQLStatistics
 	log.Debugf("mssql_sqlstats collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("SQLStatistics", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -3674,13 +3638,13 @@ type win32PerfRawDataSQLServerSQLErrors struct {
 
 // Win32_PerfRawData_MSSQLSERVER_SQLServerErrors docs:
 // - https://docs.microsoft.com/en-us/sql/relational-databases/performance-monitor/sql-server-sql-errors-object
-func (c *MSSQLCollector) collectSQLErrors(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectSQLErrors(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSQLServerSQLErrors
 	log.Debugf("mssql_sqlerrors collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("SQLErrors", sqlInstance)
-	q := queryAllForClassWhere(&dst, class, `Name <> '_Total'`)
-	if err := wmi.Query(q, &dst); err != nil {
+	q := queryAllForClassWhere(&dst, class, wqlCompare("Name", "<>", "_Total"))
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -3716,13 +3680,13 @@ type win32PerfRawDataSqlServerTransactions struct {
 
 // Win32_PerfRawData_MSSQLSERVER_Transactions docs:
 // - https://docs.microsoft.com/en-us/sql/relational-databases/performance-monitor/sql-server-transactions-object
-func (c *MSSQLCollector) collectTransactions(ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
+func (c *MSSQLCollector) collectTransactions(ctx *ScrapeContext, ch chan<- prometheus.Metric, sqlInstance string) (*prometheus.Desc, error) {
 	var dst []win32PerfRawDataSqlServerTransactions
 	log.Debugf("mssql_transactions collector iterating sql instance %s.", sqlInstance)
 
 	class := mssqlBuildWMIInstanceClass("Transactions", sqlInstance)
 	q := queryAllForClass(&dst, class)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -3,8 +3,9 @@
 package collector
 
 import (
-	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
@@ -18,13 +19,18 @@ func init() {
 var (
 	nicWhitelist = kingpin.Flag(
 		"collector.net.nic-whitelist",
-		"Regexp of NIC:s to whitelist. NIC name must both match whitelist and not match blacklist to be included.",
-	).Default(".+").String()
+		"DEPRECATED: Use --collector.net.nic-include. Regexp of NIC:s to whitelist. NIC name must both match whitelist and not match blacklist to be included.",
+	).Default("").String()
 	nicBlacklist = kingpin.Flag(
 		"collector.net.nic-blacklist",
-		"Regexp of NIC:s to blacklist. NIC name must both match whitelist and not match blacklist to be included.",
+		"DEPRECATED: Use --collector.net.nic-exclude. Regexp of NIC:s to blacklist. NIC name must both match whitelist and not match blacklist to be included.",
 	).Default("").String()
+	nicFilterFlags      = newInstanceFilterFlags("net", "nic", "NIC:s")
 	nicNameToUnderscore = regexp.MustCompile("[^a-zA-Z0-9]")
+	nicReportInfo       = kingpin.Flag(
+		"collector.net.nic-info",
+		"Report the names, MAC address, driver, link state and IP addresses of every NIC in wmi_net_nic_info and related series. Runs additional WMI queries on every scrape.",
+	).Bool()
 )
 
 // A NetworkCollector is a Prometheus collector for Perflib Network Interface metrics
@@ -41,15 +47,24 @@ type NetworkCollector struct {
 	PacketsReceivedUnknown   *prometheus.Desc
 	PacketsSentTotal         *prometheus.Desc
 	CurrentBandwidth         *prometheus.Desc
+	NICInfo                  *prometheus.Desc
+	NICUp                    *prometheus.Desc
+	NICFullDuplex            *prometheus.Desc
+	NICAddressInfo           *prometheus.Desc
 
-	nicWhitelistPattern *regexp.Regexp
-	nicBlacklistPattern *regexp.Regexp
+	nicFilter  *instanceFilter
+	reportInfo bool
 }
 
 // NewNetworkCollector ...
 func NewNetworkCollector() (Collector, error) {
 	const subsystem = "net"
 
+	nicFilter, err := nicFilterFlags.newFilter(subsystem, "nic", []string{*nicWhitelist}, []string{*nicBlacklist})
+	if err != nil {
+		return nil, err
+	}
+
 	return &NetworkCollector{
 		BytesReceivedTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "bytes_received_total"),
@@ -123,9 +138,33 @@ func NewNetworkCollector() (Collector, error) {
 			[]string{"nic"},
 			nil,
 		),
+		NICInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "nic_info"),
+			"A metric with a constant '1' value labeled with the names, MAC address, interface index, driver and type of the NIC",
+			[]string{"nic", "name", "description", "mac", "interface_index", "driver", "driver_version", "adapter_type"},
+			nil,
+		),
+		NICUp: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "nic_up"),
+			"Whether the media of the NIC is connected (1) or not (0)",
+			[]string{"nic"},
+			nil,
+		),
+		NICFullDuplex: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "nic_full_duplex"),
+			"Whether the NIC operates in full duplex (1) or half duplex (0) mode",
+			[]string{"nic"},
+			nil,
+		),
+		NICAddressInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "nic_address_info"),
+			"A metric with a constant '1' value labeled with an IP address configured on the NIC",
+			[]string{"nic", "address", "prefix_length", "family"},
+			nil,
+		),
 
-		nicWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicWhitelist)),
-		nicBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicBlacklist)),
+		nicFilter:  nicFilter,
+		reportInfo: *nicReportInfo,
 	}, nil
 }
 
@@ -136,6 +175,12 @@ func (c *NetworkCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 		log.Error("failed collecting net metrics:", desc, err)
 		return err
 	}
+	if c.reportInfo {
+		if desc, err := c.collectInfo(ctx, ch); err != nil {
+			log.Error("failed collecting net adapter information:", desc, err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -145,6 +190,11 @@ func mangleNetworkName(name string) string {
 	return nicNameToUnderscore.ReplaceAllString(name, "_")
 }
 
+// perflibNetworkName returns the name of the "Network Interface" instance of
+// an adapter, which is its description with characters reserved by perflib
+// replaced.
+var perflibNetworkName = strings.NewReplacer("(", "[", ")", "]", "#", "_", "/", "_", `\`, "_").Replace
+
 // Win32_PerfRawData_Tcpip_NetworkInterface docs:
 // - https://technet.microsoft.com/en-us/security/aa394340(v=vs.80)
 type networkInterface struct {
@@ -171,13 +221,12 @@ func (c *NetworkCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 	}
 
 	for _, nic := range dst {
-		if c.nicBlacklistPattern.MatchString(nic.Name) ||
-			!c.nicWhitelistPattern.MatchString(nic.Name) {
+		name := mangleNetworkName(nic.Name)
+		if name == "" {
 			continue
 		}
 
-		name := mangleNetworkName(nic.Name)
-		if name == "" {
+		if !c.nicFilter.keep(ctx, nic.Name, map[string]string{"nic": name}) {
 			continue
 		}
 
@@ -257,3 +306,142 @@ func (c *NetworkCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 	}
 	return nil, nil
 }
+
+// MSFT_NetAdapter is a network adapter of the root\StandardCimv2 namespace.
+type MSFT_NetAdapter struct {
+	Name                 string
+	InterfaceDescription string
+	InterfaceIndex       uint32
+	InterfaceType        uint32
+	MacAddress           *string
+	DriverFileName       *string
+	DriverVersionString  *string
+	MediaConnectState    uint32
+	FullDuplex           bool
+}
+
+// MSFT_NetIPAddress is an IP address of the root\StandardCimv2 namespace.
+type MSFT_NetIPAddress struct {
+	InterfaceIndex uint32
+	IPAddress      string
+	PrefixLength   uint8
+	AddressFamily  uint16
+}
+
+// Values of MSFT_NetAdapter.MediaConnectState and
+// MSFT_NetIPAddress.AddressFamily.
+const (
+	mediaConnectStateConnected = 1
+
+	addressFamilyIPv4 = 2
+	addressFamilyIPv6 = 23
+)
+
+// adapterTypes names the IANA interface types of common adapters.
+var adapterTypes = map[uint32]string{
+	6:   "ethernet",
+	23:  "ppp",
+	24:  "loopback",
+	71:  "wireless",
+	131: "tunnel",
+	243: "wwan",
+	244: "wwan",
+}
+
+func adapterType(interfaceType uint32) string {
+	if t, ok := adapterTypes[interfaceType]; ok {
+		return t
+	}
+	return strconv.FormatUint(uint64(interfaceType), 10)
+}
+
+func addressFamily(family uint16) string {
+	switch family {
+	case addressFamilyIPv4:
+		return "ipv4"
+	case addressFamilyIPv6:
+		return "ipv6"
+	default:
+		return strconv.FormatUint(uint64(family), 10)
+	}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// collectInfo reports the adapters known to the network stack. They are
+// matched to the "Network Interface" instances by their description, the nic
+// label has the same value as in the traffic metrics.
+func (c *NetworkCollector) collectInfo(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	var adapters []MSFT_NetAdapter
+	if err := ctx.wmiQueryNamespace(queryAll(&adapters), &adapters, "root\\StandardCimv2"); err != nil {
+		return c.NICInfo, err
+	}
+
+	var addresses []MSFT_NetIPAddress
+	if err := ctx.wmiQueryNamespace(queryAll(&addresses), &addresses, "root\\StandardCimv2"); err != nil {
+		log.Warnf("Could not query MSFT_NetIPAddress: %v", err)
+	}
+	addressesByIndex := make(map[uint32][]MSFT_NetIPAddress)
+	for _, address := range addresses {
+		addressesByIndex[address.InterfaceIndex] = append(addressesByIndex[address.InterfaceIndex], address)
+	}
+
+	for _, adapter := range adapters {
+		instance := perflibNetworkName(adapter.InterfaceDescription)
+		name := mangleNetworkName(instance)
+		if name == "" {
+			continue
+		}
+
+		// Dropped NICs are already counted by the traffic metrics.
+		if !c.nicFilter.matches(instance, map[string]string{"nic": name}) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.NICInfo,
+			prometheus.GaugeValue,
+			1.0,
+			name,
+			adapter.Name,
+			adapter.InterfaceDescription,
+			derefString(adapter.MacAddress),
+			strconv.FormatUint(uint64(adapter.InterfaceIndex), 10),
+			derefString(adapter.DriverFileName),
+			derefString(adapter.DriverVersionString),
+			adapterType(adapter.InterfaceType),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.NICUp,
+			prometheus.GaugeValue,
+			boolToFloat(adapter.MediaConnectState == mediaConnectStateConnected),
+			name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.NICFullDuplex,
+			prometheus.GaugeValue,
+			boolToFloat(adapter.FullDuplex),
+			name,
+		)
+
+		for _, address := range addressesByIndex[adapter.InterfaceIndex] {
+			ch <- prometheus.MustNewConstMetric(
+				c.NICAddressInfo,
+				prometheus.GaugeValue,
+				1.0,
+				name,
+				address.IPAddress,
+				strconv.FormatUint(uint64(address.PrefixLength), 10),
+				addressFamily(address.AddressFamily),
+			)
+		}
+	}
+	return nil, nil
+}
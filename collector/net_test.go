@@ -2,7 +2,13 @@
 
 package collector
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	perflibCollector "github.com/leoluk/perflib_exporter/collector"
+)
 
 func TestNetworkToInstanceName(t *testing.T) {
 	data := map[string]string{
@@ -15,3 +21,144 @@ func TestNetworkToInstanceName(t *testing.T) {
 		}
 	}
 }
+
+func TestPerflibNetworkName(t *testing.T) {
+	data := map[string]string{
+		"Intel(R) Ethernet Connection (7) I219-LM":  "Intel[R] Ethernet Connection [7] I219-LM",
+		"Microsoft Hyper-V Network Adapter #2":      "Microsoft Hyper-V Network Adapter _2",
+		"Realtek PCIe GbE Family Controller":        "Realtek PCIe GbE Family Controller",
+		`Teredo Tunneling Pseudo-Interface/IP\HTTP`: "Teredo Tunneling Pseudo-Interface_IP_HTTP",
+	}
+	for in, out := range data {
+		if got := perflibNetworkName(in); got != out {
+			t.Error("expected", out, "got", got)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// netAdapterWMI answers the WMI queries for the adapter information.
+func netAdapterWMI(query string, dst interface{}, namespace string) error {
+	if namespace != `root\StandardCimv2` {
+		return errors.New("unexpected namespace " + namespace)
+	}
+	switch dst := dst.(type) {
+	case *[]MSFT_NetAdapter:
+		*dst = append(*dst,
+			MSFT_NetAdapter{
+				Name:                 "Ethernet 2",
+				InterfaceDescription: "Microsoft Hyper-V Network Adapter #2",
+				InterfaceIndex:       12,
+				InterfaceType:        6,
+				MacAddress:           stringPtr("00-15-5D-01-02-03"),
+				DriverFileName:       stringPtr("netvsc.sys"),
+				DriverVersionString:  stringPtr("10.0.17763.1"),
+				MediaConnectState:    1,
+				FullDuplex:           true,
+			},
+			MSFT_NetAdapter{
+				Name:                 "Wi-Fi",
+				InterfaceDescription: "Intel(R) Dual Band Wireless-AC 8260",
+				InterfaceIndex:       7,
+				InterfaceType:        71,
+				MediaConnectState:    2,
+			},
+		)
+	case *[]MSFT_NetIPAddress:
+		*dst = append(*dst,
+			MSFT_NetIPAddress{InterfaceIndex: 12, IPAddress: "192.168.1.10", PrefixLength: 24, AddressFamily: 2},
+			MSFT_NetIPAddress{InterfaceIndex: 12, IPAddress: "fe80::215:5dff:fe01:203", PrefixLength: 64, AddressFamily: 23},
+			MSFT_NetIPAddress{InterfaceIndex: 1, IPAddress: "127.0.0.1", PrefixLength: 8, AddressFamily: 2},
+		)
+	default:
+		return errors.New("unexpected query " + query)
+	}
+	return nil
+}
+
+func newNetFixtureContext(wmi wmiQueryFunc) *ScrapeContext {
+	return newScrapeContext(perflibObjects{
+		"Network Interface": newPerflibFixture("Network Interface", 10000000,
+			perflibInstanceFixture{"Microsoft Hyper-V Network Adapter _2", []perflibCounterFixture{
+				{"Bytes Received/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 1024},
+			}},
+			perflibInstanceFixture{"Intel[R] Dual Band Wireless-AC 8260", []perflibCounterFixture{
+				{"Bytes Received/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 2048},
+			}},
+		),
+	}, wmi)
+}
+
+func TestNetworkCollectorInfo(t *testing.T) {
+	*nicReportInfo = true
+	defer func() { *nicReportInfo = false }()
+
+	c, err := NewNetworkCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := gatherCollector(t, c, newNetFixtureContext(netAdapterWMI))
+
+	for _, line := range []string{
+		`wmi_net_bytes_received_total{nic="Microsoft_Hyper_V_Network_Adapter__2"} 1024`,
+		`wmi_net_nic_info{adapter_type="ethernet",description="Microsoft Hyper-V Network Adapter #2",driver="netvsc.sys",driver_version="10.0.17763.1",interface_index="12",mac="00-15-5D-01-02-03",name="Ethernet 2",nic="Microsoft_Hyper_V_Network_Adapter__2"} 1`,
+		`wmi_net_nic_info{adapter_type="wireless",description="Intel(R) Dual Band Wireless-AC 8260",driver="",driver_version="",interface_index="7",mac="",name="Wi-Fi",nic="Intel_R__Dual_Band_Wireless_AC_8260"} 1`,
+		`wmi_net_nic_up{nic="Microsoft_Hyper_V_Network_Adapter__2"} 1`,
+		`wmi_net_nic_up{nic="Intel_R__Dual_Band_Wireless_AC_8260"} 0`,
+		`wmi_net_nic_full_duplex{nic="Microsoft_Hyper_V_Network_Adapter__2"} 1`,
+		`wmi_net_nic_full_duplex{nic="Intel_R__Dual_Band_Wireless_AC_8260"} 0`,
+		`wmi_net_nic_address_info{address="192.168.1.10",family="ipv4",nic="Microsoft_Hyper_V_Network_Adapter__2",prefix_length="24"} 1`,
+		`wmi_net_nic_address_info{address="fe80::215:5dff:fe01:203",family="ipv6",nic="Microsoft_Hyper_V_Network_Adapter__2",prefix_length="64"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "127.0.0.1") {
+		t.Errorf("Unexpected address of an unknown interface in output:\n%s", out)
+	}
+}
+
+func TestNetworkCollectorInfoFilter(t *testing.T) {
+	*nicReportInfo = true
+	defer func() { *nicReportInfo = false }()
+
+	c, err := NewNetworkCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newInstanceFilter("net", "nic", []string{"Microsoft.*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(*NetworkCollector).nicFilter = filter
+	ctx := newNetFixtureContext(netAdapterWMI)
+	out := gatherCollector(t, c, ctx)
+
+	if !strings.Contains(out, `wmi_net_nic_up{nic="Microsoft_Hyper_V_Network_Adapter__2"} 1`) {
+		t.Errorf("Expected the included NIC in output:\n%s", out)
+	}
+	if strings.Contains(out, "Intel") {
+		t.Errorf("Unexpected excluded NIC in output:\n%s", out)
+	}
+	if n := ctx.filtered.get(filter); n != 1 {
+		t.Errorf("Expected the excluded NIC to be counted once, got %d", n)
+	}
+}
+
+func TestNetworkCollectorWithoutInfo(t *testing.T) {
+	c, err := NewNetworkCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := gatherCollector(t, c, newNetFixtureContext(func(query string, dst interface{}, namespace string) error {
+		return errors.New("unexpected query " + query)
+	}))
+
+	if strings.Contains(out, "wmi_net_nic_") {
+		t.Errorf("Unexpected adapter information in output:\n%s", out)
+	}
+}
@@ -0,0 +1,7 @@
+// +build windows
+
+package collector
+
+// netframeworkProcessFilterFlags are shared by all netframework_* collectors,
+// each of which builds its own filter from them.
+var netframeworkProcessFilterFlags = newInstanceFilterFlags("netframework", "process", "CLR processes")
@@ -3,7 +3,6 @@
 package collector
 
 import (
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -18,11 +17,17 @@ type NETFramework_NETCLRExceptionsCollector struct {
 	NumberofFilters      *prometheus.Desc
 	NumberofFinallys     *prometheus.Desc
 	ThrowToCatchDepth    *prometheus.Desc
+	processFilter        *instanceFilter
 }
 
 // NewNETFramework_NETCLRExceptionsCollector ...
 func NewNETFramework_NETCLRExceptionsCollector() (Collector, error) {
 	const subsystem = "netframework_clrexceptions"
+	processFilter, err := netframeworkProcessFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NETFramework_NETCLRExceptionsCollector{
 		NumberofExcepsThrown: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "exceptions_thrown_total"),
@@ -48,13 +53,14 @@ func NewNETFramework_NETCLRExceptionsCollector() (Collector, error) {
 			[]string{"process"},
 			nil,
 		),
+		processFilter: processFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NETFramework_NETCLRExceptionsCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting win32_perfrawdata_netframework_netclrexceptions metrics:", desc, err)
 		return err
 	}
@@ -71,10 +77,10 @@ type Win32_PerfRawData_NETFramework_NETCLRExceptions struct {
 	ThrowToCatchDepthPersec    uint32
 }
 
-func (c *NETFramework_NETCLRExceptionsCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *NETFramework_NETCLRExceptionsCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NETFramework_NETCLRExceptions
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -83,6 +89,9 @@ func (c *NETFramework_NETCLRExceptionsCollector) collect(ch chan<- prometheus.Me
 		if process.Name == "_Global_" {
 			continue
 		}
+		if !c.processFilter.keep(ctx, process.Name, map[string]string{"process": process.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.NumberofExcepsThrown,
@@ -3,7 +3,6 @@
 package collector
 
 import (
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -17,11 +16,17 @@ type NETFramework_NETCLRInteropCollector struct {
 	NumberofCCWs        *prometheus.Desc
 	Numberofmarshalling *prometheus.Desc
 	NumberofStubs       *prometheus.Desc
+	processFilter       *instanceFilter
 }
 
 // NewNETFramework_NETCLRInteropCollector ...
 func NewNETFramework_NETCLRInteropCollector() (Collector, error) {
 	const subsystem = "netframework_clrinterop"
+	processFilter, err := netframeworkProcessFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NETFramework_NETCLRInteropCollector{
 		NumberofCCWs: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "com_callable_wrappers_total"),
@@ -41,13 +46,14 @@ func NewNETFramework_NETCLRInteropCollector() (Collector, error) {
 			[]string{"process"},
 			nil,
 		),
+		processFilter: processFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NETFramework_NETCLRInteropCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting win32_perfrawdata_netframework_netclrinterop metrics:", desc, err)
 		return err
 	}
@@ -64,10 +70,10 @@ type Win32_PerfRawData_NETFramework_NETCLRInterop struct {
 	NumberofTLBimportsPersec uint32
 }
 
-func (c *NETFramework_NETCLRInteropCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *NETFramework_NETCLRInteropCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NETFramework_NETCLRInterop
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -76,6 +82,9 @@ func (c *NETFramework_NETCLRInteropCollector) collect(ch chan<- prometheus.Metri
 		if process.Name == "_Global_" {
 			continue
 		}
+		if !c.processFilter.keep(ctx, process.Name, map[string]string{"process": process.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.NumberofCCWs,
@@ -3,7 +3,6 @@
 package collector
 
 import (
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -18,11 +17,17 @@ type NETFramework_NETCLRJitCollector struct {
 	TimeinJit                  *prometheus.Desc
 	StandardJitFailures        *prometheus.Desc
 	TotalNumberofILBytesJitted *prometheus.Desc
+	processFilter              *instanceFilter
 }
 
 // NewNETFramework_NETCLRJitCollector ...
 func NewNETFramework_NETCLRJitCollector() (Collector, error) {
 	const subsystem = "netframework_clrjit"
+	processFilter, err := netframeworkProcessFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NETFramework_NETCLRJitCollector{
 		NumberofMethodsJitted: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "jit_methods_total"),
@@ -48,13 +53,14 @@ func NewNETFramework_NETCLRJitCollector() (Collector, error) {
 			[]string{"process"},
 			nil,
 		),
+		processFilter: processFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NETFramework_NETCLRJitCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting win32_perfrawdata_netframework_netclrjit metrics:", desc, err)
 		return err
 	}
@@ -73,10 +79,10 @@ type Win32_PerfRawData_NETFramework_NETCLRJit struct {
 	TotalNumberofILBytesJitted uint32
 }
 
-func (c *NETFramework_NETCLRJitCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *NETFramework_NETCLRJitCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NETFramework_NETCLRJit
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -85,6 +91,9 @@ func (c *NETFramework_NETCLRJitCollector) collect(ch chan<- prometheus.Metric) (
 		if process.Name == "_Global_" {
 			continue
 		}
+		if !c.processFilter.keep(ctx, process.Name, map[string]string{"process": process.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.NumberofMethodsJitted,
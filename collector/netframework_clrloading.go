@@ -3,7 +3,6 @@
 package collector
 
 import (
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -23,11 +22,17 @@ type NETFramework_NETCLRLoadingCollector struct {
 	TotalAssemblies           *prometheus.Desc
 	TotalClassesLoaded        *prometheus.Desc
 	TotalNumberofLoadFailures *prometheus.Desc
+	processFilter             *instanceFilter
 }
 
 // NewNETFramework_NETCLRLoadingCollector ...
 func NewNETFramework_NETCLRLoadingCollector() (Collector, error) {
 	const subsystem = "netframework_clrloading"
+	processFilter, err := netframeworkProcessFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NETFramework_NETCLRLoadingCollector{
 		BytesinLoaderHeap: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "loader_heap_size_bytes"),
@@ -83,13 +88,14 @@ func NewNETFramework_NETCLRLoadingCollector() (Collector, error) {
 			[]string{"process"},
 			nil,
 		),
+		processFilter: processFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NETFramework_NETCLRLoadingCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting win32_perfrawdata_netframework_netclrloading metrics:", desc, err)
 		return err
 	}
@@ -117,10 +123,10 @@ type Win32_PerfRawData_NETFramework_NETCLRLoading struct {
 	TotalNumberofLoadFailures uint32
 }
 
-func (c *NETFramework_NETCLRLoadingCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *NETFramework_NETCLRLoadingCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NETFramework_NETCLRLoading
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -129,6 +135,9 @@ func (c *NETFramework_NETCLRLoadingCollector) collect(ch chan<- prometheus.Metri
 		if process.Name == "_Global_" {
 			continue
 		}
+		if !c.processFilter.keep(ctx, process.Name, map[string]string{"process": process.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.BytesinLoaderHeap,
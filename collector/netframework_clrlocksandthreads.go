@@ -3,7 +3,6 @@
 package collector
 
 import (
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -21,11 +20,17 @@ type NETFramework_NETCLRLocksAndThreadsCollector struct {
 	Numberoftotalrecognizedthreads   *prometheus.Desc
 	QueueLengthPeak                  *prometheus.Desc
 	TotalNumberofContentions         *prometheus.Desc
+	processFilter                    *instanceFilter
 }
 
 // NewNETFramework_NETCLRLocksAndThreadsCollector ...
 func NewNETFramework_NETCLRLocksAndThreadsCollector() (Collector, error) {
 	const subsystem = "netframework_clrlocksandthreads"
+	processFilter, err := netframeworkProcessFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NETFramework_NETCLRLocksAndThreadsCollector{
 		CurrentQueueLength: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_queue_length"),
@@ -69,13 +74,14 @@ func NewNETFramework_NETCLRLocksAndThreadsCollector() (Collector, error) {
 			[]string{"process"},
 			nil,
 		),
+		processFilter: processFilter,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NETFramework_NETCLRLocksAndThreadsCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting win32_perfrawdata_netframework_netclrlocksandthreads metrics:", desc, err)
 		return err
 	}
@@ -97,10 +103,10 @@ type Win32_PerfRawData_NETFramework_NETCLRLocksAndThreads struct {
 	TotalNumberofContentions         uint32
 }
 
-func (c *NETFramework_NETCLRLocksAndThreadsCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+func (c *NETFramework_NETCLRLocksAndThreadsCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
 	var dst []Win32_PerfRawData_NETFramework_NETCLRLocksAndThreads
 	q := queryAll(&dst)
-	if err := wmi.Query(q, &dst); err != nil {
+	if err := ctx.wmiQuery(q, &dst); err != nil {
 		return nil, err
 	}
 
@@ -109,6 +115,9 @@ func (c *NETFramework_NETCLRLocksAndThreadsCollector) collect(ch chan<- promethe
 		if process.Name == "_Global_" {
 			continue
 		}
+		if !c.processFilter.keep(ctx, process.Name, map[string]string{"process": process.Name}) {
+			continue
+		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.CurrentQueueLength,
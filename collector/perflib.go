@@ -1,25 +1,96 @@
+// +build windows
+
 package collector
 
 import (
 	"fmt"
 	"reflect"
+	"sync"
 
 	perflibCollector "github.com/leoluk/perflib_exporter/collector"
 	"github.com/leoluk/perflib_exporter/perflib"
 	"github.com/prometheus/common/log"
 )
 
-func getPerflibSnapshot() (map[string]*perflib.PerfObject, error) {
+// nameTable resolves a perflib title index to a name.
+type nameTable interface {
+	LookupString(index uint32) string
+}
+
+var (
+	// englishNameTable is the English (009) counter name table. Objects and
+	// counters are resolved through it by their stable title index, so that
+	// lookups such as "Processor Information" or "% Idle Time" work regardless
+	// of the display language of the installation.
+	englishNameTable nameTable
+	// localizedNameTable is the counter name table in the display language.
+	// It is only used to name objects and counters in log messages.
+	localizedNameTable nameTable
+	nameTablesOnce     sync.Once
+)
+
+func loadNameTables() {
+	nameTablesOnce.Do(func() {
+		englishNameTable = queryNameTable("Counter 009")
+		localizedNameTable = queryNameTable("Counter CurrentLanguage")
+	})
+}
+
+// queryNameTable reads a name table from the registry, returning nil if it
+// cannot be read.
+func queryNameTable(name string) (table nameTable) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("Could not read the perflib name table %q: %v", name, r)
+			table = nil
+		}
+	}()
+	return perflib.QueryNameTable(name)
+}
+
+// englishName returns the English name registered for index, falling back to
+// name if the index is unknown.
+func englishName(index uint, name string) string {
+	return lookupName(englishNameTable, index, name)
+}
+
+// localizedName returns the name registered for index in the display
+// language, falling back to name if the index is unknown.
+func localizedName(index uint, name string) string {
+	return lookupName(localizedNameTable, index, name)
+}
+
+func lookupName(table nameTable, index uint, name string) string {
+	if table == nil {
+		return name
+	}
+	if n := table.LookupString(uint32(index)); n != "" {
+		return n
+	}
+	return name
+}
+
+// perflibObjects maps the English name of a perflib object to the object.
+type perflibObjects map[string]*perflib.PerfObject
+
+func getPerflibSnapshot() (perflibObjects, error) {
+	loadNameTables()
+
 	objects, err := perflib.QueryPerformanceData("Global")
 	if err != nil {
 		return nil, err
 	}
 
-	indexed := make(map[string]*perflib.PerfObject)
+	return indexPerflibObjects(objects), nil
+}
+
+// indexPerflibObjects keys objects by their English name.
+func indexPerflibObjects(objects []*perflib.PerfObject) perflibObjects {
+	indexed := make(perflibObjects)
 	for _, obj := range objects {
-		indexed[obj.Name] = obj
+		indexed[englishName(obj.NameIndex, obj.Name)] = obj
 	}
-	return indexed, nil
+	return indexed
 }
 
 func unmarshalObject(obj *perflib.PerfObject, vs interface{}) error {
@@ -47,10 +118,11 @@ func unmarshalObject(obj *perflib.PerfObject, vs interface{}) error {
 
 		counters := make(map[string]*perflib.PerfCounter, len(instance.Counters))
 		for _, ctr := range instance.Counters {
+			name := englishName(ctr.Def.NameIndex, ctr.Def.Name)
 			if ctr.Def.IsBaseValue && !ctr.Def.IsNanosecondCounter {
-				counters[ctr.Def.Name+"_Base"] = ctr
+				counters[name+"_Base"] = ctr
 			} else {
-				counters[ctr.Def.Name] = ctr
+				counters[name] = ctr
 			}
 		}
 
@@ -63,7 +135,7 @@ func unmarshalObject(obj *perflib.PerfObject, vs interface{}) error {
 
 			ctr, found := counters[tag]
 			if !found {
-				log.Debugf("missing counter %q, have %v", tag, counterMapKeys(counters))
+				log.Debugf("missing counter %q of %q, have %v", tag, localizedName(obj.NameIndex, obj.Name), counterMapKeys(counters))
 				continue
 			}
 			if !target.Field(i).CanSet() {
@@ -76,8 +148,16 @@ func unmarshalObject(obj *perflib.PerfObject, vs interface{}) error {
 			switch ctr.Def.CounterType {
 			case perflibCollector.PERF_ELAPSED_TIME:
 				target.Field(i).SetFloat(float64(ctr.Value-windowsEpoch) / float64(obj.Frequency))
-			case perflibCollector.PERF_100NSEC_TIMER, perflibCollector.PERF_PRECISION_100NS_TIMER:
+			case perflibCollector.PERF_100NSEC_TIMER, perflibCollector.PERF_PRECISION_100NS_TIMER, perflibCollector.PERF_COUNTER_100NS_QUEUELEN_TYPE:
 				target.Field(i).SetFloat(float64(ctr.Value) * ticksToSecondsScaleFactor)
+			case perflibCollector.PERF_AVERAGE_TIMER:
+				// Ticks of the performance counter, summed over all operations.
+				// Without a frequency, assume 100ns ticks.
+				if obj.Frequency == 0 {
+					target.Field(i).SetFloat(float64(ctr.Value) * ticksToSecondsScaleFactor)
+				} else {
+					target.Field(i).SetFloat(float64(ctr.Value) / float64(obj.Frequency))
+				}
 			default:
 				target.Field(i).SetFloat(float64(ctr.Value))
 			}
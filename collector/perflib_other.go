@@ -0,0 +1,7 @@
+// +build !windows
+
+package collector
+
+// perflibObjects is empty on platforms without perflib, where only the
+// platform independent parts of the package are built and tested.
+type perflibObjects map[string]struct{}
@@ -1,13 +1,130 @@
+// +build windows
+
 package collector
 
 import (
+	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	perflibCollector "github.com/leoluk/perflib_exporter/collector"
 	"github.com/leoluk/perflib_exporter/perflib"
 )
 
+// perflibCounterFixture is a counter of an instance of a perflib object
+// fixture. Base counters are named after the counter they are the base of.
+type perflibCounterFixture struct {
+	name        string
+	counterType uint32
+	value       int64
+}
+
+// perflibInstanceFixture is an instance of a perflib object fixture.
+type perflibInstanceFixture struct {
+	name     string
+	counters []perflibCounterFixture
+}
+
+// newPerflibFixture builds a perflib object with the given instances, as
+// returned by QueryPerformanceData. Counter definitions are shared between
+// instances.
+func newPerflibFixture(name string, frequency int64, instances ...perflibInstanceFixture) *perflib.PerfObject {
+	obj := &perflib.PerfObject{Name: name, Frequency: frequency}
+	type defKey struct {
+		name        string
+		counterType uint32
+	}
+	defs := make(map[defKey]*perflib.PerfCounterDef)
+	for _, fixture := range instances {
+		instance := &perflib.PerfInstance{Name: fixture.name}
+		for _, c := range fixture.counters {
+			key := defKey{c.name, c.counterType}
+			def, ok := defs[key]
+			if !ok {
+				// The flags are derived from the counter type like perflib does.
+				def = &perflib.PerfCounterDef{
+					Name:                c.name,
+					CounterType:         c.counterType,
+					IsCounter:           c.counterType&0x400 == 0x400,
+					IsBaseValue:         c.counterType&0x00030000 == 0x00030000,
+					IsNanosecondCounter: c.counterType&0x00100000 == 0x00100000,
+				}
+				defs[key] = def
+				obj.CounterDefs = append(obj.CounterDefs, def)
+			}
+			instance.Counters = append(instance.Counters, &perflib.PerfCounter{Def: def, Value: c.value})
+		}
+		obj.Instances = append(obj.Instances, instance)
+	}
+	return obj
+}
+
+// recordedCounter is a counter of a recorded perflib object, with its value
+// for every instance.
+type recordedCounter struct {
+	name        string
+	counterType uint32
+	values      []int64
+}
+
+// recordedInstances turns counters recorded for the named instances into
+// instance fixtures.
+func recordedInstances(names []string, counters []recordedCounter) []perflibInstanceFixture {
+	instances := make([]perflibInstanceFixture, len(names))
+	for i, name := range names {
+		instances[i].name = name
+		for _, c := range counters {
+			instances[i].counters = append(instances[i].counters, perflibCounterFixture{c.name, c.counterType, c.values[i]})
+		}
+	}
+	return instances
+}
+
+// parseSamples maps the samples in the text exposition format to their value.
+func parseSamples(t *testing.T, out string) map[string]float64 {
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("Invalid sample %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+// checkSamples checks the expected samples, and that no sample of a total
+// instance is reported.
+func checkSamples(t *testing.T, samples map[string]float64, expected map[string]float64) {
+	keys := make([]string, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := samples[k]
+		if !ok {
+			t.Errorf("Missing sample %s", k)
+			continue
+		}
+		if math.Abs(v-expected[k]) > 1e-9*math.Abs(expected[k]) {
+			t.Errorf("Expected %s to be %v, got %v", k, expected[k], v)
+		}
+	}
+	for k := range samples {
+		if strings.Contains(k, "_Total") {
+			t.Errorf("Unexpected sample of a total %s", k)
+		}
+	}
+}
+
 type simple struct {
 	ValA float64 `perflib:"Something"`
 	ValB float64 `perflib:"Something Else"`
@@ -105,6 +222,24 @@ func TestUnmarshalPerflib(t *testing.T) {
 			expectedOutput: []simple{{ValA: 321}, {ValA: 231}},
 			expectError:    false,
 		},
+		{
+			name: "Time conversions",
+			obj: newPerflibFixture("Timers", 2500000, perflibInstanceFixture{"", []perflibCounterFixture{
+				{"Something", perflibCollector.PERF_AVERAGE_TIMER, 5000000},
+				{"Something Else", perflibCollector.PERF_COUNTER_100NS_QUEUELEN_TYPE, 30000000},
+			}}),
+			expectedOutput: []simple{{ValA: 2, ValB: 3}},
+			expectError:    false,
+		},
+		{
+			name: "Average timer without frequency",
+			obj: newPerflibFixture("Timers", 0, perflibInstanceFixture{"", []perflibCounterFixture{
+				{"Something", perflibCollector.PERF_AVERAGE_TIMER, 20000000},
+				{"Something Else", perflibCollector.PERF_COUNTER_100NS_QUEUELEN_TYPE, 30000000},
+			}}),
+			expectedOutput: []simple{{ValA: 2, ValB: 3}},
+			expectError:    false,
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -123,3 +258,79 @@ func TestUnmarshalPerflib(t *testing.T) {
 		})
 	}
 }
+
+type fakeNameTable map[uint32]string
+
+func (t fakeNameTable) LookupString(index uint32) string {
+	return t[index]
+}
+
+func TestUnmarshalPerflibLocalized(t *testing.T) {
+	defer func(english, localized nameTable) {
+		englishNameTable, localizedNameTable = english, localized
+	}(englishNameTable, localizedNameTable)
+	englishNameTable = fakeNameTable{
+		1:  "Processor Information",
+		10: "Something",
+		12: "Something Else",
+	}
+	localizedNameTable = fakeNameTable{
+		1:  "Prozessorinformationen",
+		10: "Etwas",
+		12: "Etwas anderes",
+	}
+
+	objects := []*perflib.PerfObject{
+		{
+			Name:      "Prozessorinformationen",
+			NameIndex: 1,
+			Instances: []*perflib.PerfInstance{
+				{
+					Counters: []*perflib.PerfCounter{
+						{
+							Def: &perflib.PerfCounterDef{
+								Name:        "Etwas",
+								NameIndex:   10,
+								CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+							},
+							Value: 123,
+						},
+						{
+							Def: &perflib.PerfCounterDef{
+								Name:        "Etwas anderes",
+								NameIndex:   12,
+								CounterType: perflibCollector.PERF_COUNTER_COUNTER,
+							},
+							Value: 256,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "Unbekannt",
+			NameIndex: 99,
+		},
+	}
+
+	indexed := indexPerflibObjects(objects)
+	if _, ok := indexed["Unbekannt"]; !ok {
+		t.Errorf("Expected unknown index to fall back to the display name, got %v", indexed)
+	}
+	obj, ok := indexed["Processor Information"]
+	if !ok {
+		t.Fatalf("Expected object to be indexed by English name, got %v", indexed)
+	}
+	if name := localizedName(obj.NameIndex, obj.Name); name != "Prozessorinformationen" {
+		t.Errorf("Expected localized display name %q, got %q", "Prozessorinformationen", name)
+	}
+
+	output := make([]simple, 0)
+	if err := unmarshalObject(obj, &output); err != nil {
+		t.Fatalf("Did not expect error, got %q", err)
+	}
+	expected := []simple{{ValA: 123, ValB: 256}}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Output mismatch, expected %+v, got %+v", expected, output)
+	}
+}
@@ -0,0 +1,326 @@
+// +build windows
+
+package collector
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	Factories["physical_disk"] = NewPhysicalDiskCollector
+}
+
+var diskFilterFlags = newInstanceFilterFlags("physical_disk", "disk", "disks")
+
+// A PhysicalDiskCollector is a Prometheus collector for perflib PhysicalDisk metrics
+type PhysicalDiskCollector struct {
+	RequestsQueued          *prometheus.Desc
+	ReadBytesTotal          *prometheus.Desc
+	ReadsTotal              *prometheus.Desc
+	WriteBytesTotal         *prometheus.Desc
+	WritesTotal             *prometheus.Desc
+	ReadTime                *prometheus.Desc
+	WriteTime               *prometheus.Desc
+	IdleTime                *prometheus.Desc
+	SplitIOs                *prometheus.Desc
+	ReadLatency             *prometheus.Desc
+	WriteLatency            *prometheus.Desc
+	ReadWriteLatency        *prometheus.Desc
+	ReadQueueLengthSeconds  *prometheus.Desc
+	WriteQueueLengthSeconds *prometheus.Desc
+	VolumeInfo              *prometheus.Desc
+
+	diskFilter *instanceFilter
+}
+
+// NewPhysicalDiskCollector ...
+func NewPhysicalDiskCollector() (Collector, error) {
+	const subsystem = "physical_disk"
+
+	diskFilter, err := diskFilterFlags.newFilter(subsystem, "disk", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PhysicalDiskCollector{
+		RequestsQueued: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "requests_queued"),
+			"The number of requests queued to the disk (PhysicalDisk.CurrentDiskQueueLength)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_bytes_total"),
+			"The number of bytes transferred from the disk during read operations (PhysicalDisk.DiskReadBytesPerSec)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "reads_total"),
+			"The number of read operations on the disk (PhysicalDisk.DiskReadsPerSec)",
+			[]string{"disk"},
+			nil,
+		),
+
+		WriteBytesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "write_bytes_total"),
+			"The number of bytes transferred to the disk during write operations (PhysicalDisk.DiskWriteBytesPerSec)",
+			[]string{"disk"},
+			nil,
+		),
+
+		WritesTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "writes_total"),
+			"The number of write operations on the disk (PhysicalDisk.DiskWritesPerSec)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_seconds_total"),
+			"Seconds that the disk was busy servicing read requests (PhysicalDisk.PercentDiskReadTime)",
+			[]string{"disk"},
+			nil,
+		),
+
+		WriteTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "write_seconds_total"),
+			"Seconds that the disk was busy servicing write requests (PhysicalDisk.PercentDiskWriteTime)",
+			[]string{"disk"},
+			nil,
+		),
+
+		IdleTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "idle_seconds_total"),
+			"Seconds that the disk was idle (PhysicalDisk.PercentIdleTime)",
+			[]string{"disk"},
+			nil,
+		),
+
+		SplitIOs: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "split_ios_total"),
+			"The number of I/Os to the disk were split into multiple I/Os (PhysicalDisk.SplitIOPerSec)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_latency_seconds_total"),
+			"The time, in seconds, spent in read operations from the disk. Divide its rate by the rate of reads for the average latency (PhysicalDisk.AvgDiskSecPerRead)",
+			[]string{"disk"},
+			nil,
+		),
+
+		WriteLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "write_latency_seconds_total"),
+			"The time, in seconds, spent in write operations to the disk. Divide its rate by the rate of writes for the average latency (PhysicalDisk.AvgDiskSecPerWrite)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadWriteLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_write_latency_seconds_total"),
+			"The time, in seconds, spent in transfers from and to the disk. Divide its rate by the rate of transfers for the average latency (PhysicalDisk.AvgDiskSecPerTransfer)",
+			[]string{"disk"},
+			nil,
+		),
+
+		ReadQueueLengthSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "read_queue_length_seconds_total"),
+			"The number of queued read requests integrated over time, its rate is the average read queue length (PhysicalDisk.AvgDiskReadQueueLength)",
+			[]string{"disk"},
+			nil,
+		),
+
+		WriteQueueLengthSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "write_queue_length_seconds_total"),
+			"The number of queued write requests integrated over time, its rate is the average write queue length (PhysicalDisk.AvgDiskWriteQueueLength)",
+			[]string{"disk"},
+			nil,
+		),
+
+		VolumeInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "volume_info"),
+			"A metric with a constant '1' value labeled with a volume stored on the disk",
+			[]string{"disk", "volume"},
+			nil,
+		),
+
+		diskFilter: diskFilter,
+	}, nil
+}
+
+// Collect sends the metric values for each metric
+// to the provided prometheus Metric channel.
+func (c *PhysicalDiskCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
+	if desc, err := c.collect(ctx, ch); err != nil {
+		log.Error("failed collecting physical_disk metrics:", desc, err)
+		return err
+	}
+	return nil
+}
+
+// parsePhysicalDiskName splits the name of a "PhysicalDisk" instance, such
+// as "0 C: D:", into the number of the disk and the volumes stored on it.
+// The total is not a disk.
+func parsePhysicalDiskName(name string) (disk string, volumes []string, ok bool) {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	if _, err := strconv.ParseUint(fields[0], 10, 32); err != nil {
+		return "", nil, false
+	}
+	return fields[0], fields[1:], true
+}
+
+// Win32_PerfRawData_PerfDisk_PhysicalDisk docs:
+// - https://msdn.microsoft.com/en-us/library/ms803973.aspx - PhysicalDisk object reference
+type physicalDisk struct {
+	Name                    string
+	CurrentDiskQueueLength  float64 `perflib:"Current Disk Queue Length"`
+	DiskReadBytesPerSec     float64 `perflib:"Disk Read Bytes/sec"`
+	DiskReadsPerSec         float64 `perflib:"Disk Reads/sec"`
+	DiskWriteBytesPerSec    float64 `perflib:"Disk Write Bytes/sec"`
+	DiskWritesPerSec        float64 `perflib:"Disk Writes/sec"`
+	PercentDiskReadTime     float64 `perflib:"% Disk Read Time"`
+	PercentDiskWriteTime    float64 `perflib:"% Disk Write Time"`
+	PercentIdleTime         float64 `perflib:"% Idle Time"`
+	SplitIOPerSec           float64 `perflib:"Split IO/Sec"`
+	AvgDiskSecPerRead       float64 `perflib:"Avg. Disk sec/Read"`
+	AvgDiskSecPerWrite      float64 `perflib:"Avg. Disk sec/Write"`
+	AvgDiskSecPerTransfer   float64 `perflib:"Avg. Disk sec/Transfer"`
+	AvgDiskReadQueueLength  float64 `perflib:"Avg. Disk Read Queue Length"`
+	AvgDiskWriteQueueLength float64 `perflib:"Avg. Disk Write Queue Length"`
+}
+
+func (c *PhysicalDiskCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	var dst []physicalDisk
+	if err := unmarshalObject(ctx.perfObjects["PhysicalDisk"], &dst); err != nil {
+		return nil, err
+	}
+
+	for _, disk := range dst {
+		number, volumes, ok := parsePhysicalDiskName(disk.Name)
+		if !ok ||
+			!c.diskFilter.keep(ctx, number, map[string]string{"disk": number}) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.RequestsQueued,
+			prometheus.GaugeValue,
+			disk.CurrentDiskQueueLength,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadBytesTotal,
+			prometheus.CounterValue,
+			disk.DiskReadBytesPerSec,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadsTotal,
+			prometheus.CounterValue,
+			disk.DiskReadsPerSec,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.WriteBytesTotal,
+			prometheus.CounterValue,
+			disk.DiskWriteBytesPerSec,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.WritesTotal,
+			prometheus.CounterValue,
+			disk.DiskWritesPerSec,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadTime,
+			prometheus.CounterValue,
+			disk.PercentDiskReadTime,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.WriteTime,
+			prometheus.CounterValue,
+			disk.PercentDiskWriteTime,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.IdleTime,
+			prometheus.CounterValue,
+			disk.PercentIdleTime,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.SplitIOs,
+			prometheus.CounterValue,
+			disk.SplitIOPerSec,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadLatency,
+			prometheus.CounterValue,
+			disk.AvgDiskSecPerRead,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.WriteLatency,
+			prometheus.CounterValue,
+			disk.AvgDiskSecPerWrite,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadWriteLatency,
+			prometheus.CounterValue,
+			disk.AvgDiskSecPerTransfer,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.ReadQueueLengthSeconds,
+			prometheus.CounterValue,
+			disk.AvgDiskReadQueueLength,
+			number,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			c.WriteQueueLengthSeconds,
+			prometheus.CounterValue,
+			disk.AvgDiskWriteQueueLength,
+			number,
+		)
+
+		for _, volume := range volumes {
+			ch <- prometheus.MustNewConstMetric(
+				c.VolumeInfo,
+				prometheus.GaugeValue,
+				1.0,
+				number,
+				volume,
+			)
+		}
+	}
+
+	return nil, nil
+}
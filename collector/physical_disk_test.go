@@ -0,0 +1,120 @@
+// +build windows
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	perflibCollector "github.com/leoluk/perflib_exporter/collector"
+)
+
+// Recorded on Windows Server 2016 with a performance counter frequency of
+// 2.53125 MHz, the OS on disk 0, two volumes on disk 1 and an empty disk 2.
+var recordedPhysicalDisk = recordedInstances(
+	[]string{"0 C:", "1 D: E:", "2", "_Total"},
+	[]recordedCounter{
+		{"Current Disk Queue Length", perflibCollector.PERF_COUNTER_RAWCOUNT, []int64{2, 0, 0, 2}},
+		{"% Disk Read Time", perflibCollector.PERF_PRECISION_100NS_TIMER, []int64{125000000, 40000000, 0, 165000000}},
+		{"% Disk Write Time", perflibCollector.PERF_PRECISION_100NS_TIMER, []int64{350000000, 90000000, 0, 440000000}},
+		{"% Idle Time", perflibCollector.PERF_PRECISION_100NS_TIMER, []int64{86000000000, 91000000000, 92000000000, 269000000000}},
+		{"Avg. Disk sec/Read", perflibCollector.PERF_AVERAGE_TIMER, []int64{30375000, 10125000, 0, 40500000}},
+		{"Avg. Disk sec/Read", perflibCollector.PERF_AVERAGE_BASE, []int64{150000, 20000, 0, 170000}},
+		{"Avg. Disk sec/Write", perflibCollector.PERF_AVERAGE_TIMER, []int64{88593750, 22781250, 0, 111375000}},
+		{"Avg. Disk sec/Write", perflibCollector.PERF_AVERAGE_BASE, []int64{400000, 45000, 0, 445000}},
+		{"Avg. Disk sec/Transfer", perflibCollector.PERF_AVERAGE_TIMER, []int64{118968750, 32906250, 0, 151875000}},
+		{"Avg. Disk sec/Transfer", perflibCollector.PERF_AVERAGE_BASE, []int64{550000, 65000, 0, 615000}},
+		{"Avg. Disk Read Queue Length", perflibCollector.PERF_COUNTER_100NS_QUEUELEN_TYPE, []int64{120000000, 40000000, 0, 160000000}},
+		{"Avg. Disk Write Queue Length", perflibCollector.PERF_COUNTER_100NS_QUEUELEN_TYPE, []int64{350000000, 90000000, 0, 440000000}},
+		{"Disk Reads/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{150000, 20000, 0, 170000}},
+		{"Disk Writes/sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{400000, 45000, 0, 445000}},
+		{"Disk Read Bytes/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{4915200000, 1310720000, 0, 6225920000}},
+		{"Disk Write Bytes/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, []int64{6553600000, 2949120000, 0, 9502720000}},
+		{"Split IO/Sec", perflibCollector.PERF_COUNTER_COUNTER, []int64{1200, 30, 0, 1230}},
+	},
+)
+
+func newPhysicalDiskFixtureContext() *ScrapeContext {
+	return newScrapeContext(perflibObjects{
+		"PhysicalDisk": newPerflibFixture("PhysicalDisk", 2531250, recordedPhysicalDisk...),
+	}, nil)
+}
+
+func TestParsePhysicalDiskName(t *testing.T) {
+	cases := []struct {
+		name    string
+		disk    string
+		volumes []string
+		ok      bool
+	}{
+		{"0 C:", "0", []string{"C:"}, true},
+		{"1 D: E:", "1", []string{"D:", "E:"}, true},
+		{"12", "12", nil, true},
+		{"_Total", "", nil, false},
+		{"", "", nil, false},
+	}
+	for _, tc := range cases {
+		disk, volumes, ok := parsePhysicalDiskName(tc.name)
+		if disk != tc.disk || strings.Join(volumes, " ") != strings.Join(tc.volumes, " ") || ok != tc.ok {
+			t.Errorf("parsePhysicalDiskName(%q) = %q, %q, %v, expected %q, %q, %v", tc.name, disk, volumes, ok, tc.disk, tc.volumes, tc.ok)
+		}
+	}
+}
+
+func TestPhysicalDiskCollector(t *testing.T) {
+	c, err := NewPhysicalDiskCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := parseSamples(t, gatherCollector(t, c, newPhysicalDiskFixtureContext()))
+
+	checkSamples(t, samples, map[string]float64{
+		`wmi_physical_disk_requests_queued{disk="0"}`:                  2,
+		`wmi_physical_disk_read_bytes_total{disk="0"}`:                 4915200000,
+		`wmi_physical_disk_reads_total{disk="0"}`:                      150000,
+		`wmi_physical_disk_write_bytes_total{disk="1"}`:                2949120000,
+		`wmi_physical_disk_writes_total{disk="1"}`:                     45000,
+		`wmi_physical_disk_read_seconds_total{disk="0"}`:               12.5,
+		`wmi_physical_disk_write_seconds_total{disk="0"}`:              35,
+		`wmi_physical_disk_idle_seconds_total{disk="2"}`:               9200,
+		`wmi_physical_disk_split_ios_total{disk="0"}`:                  1200,
+		`wmi_physical_disk_read_latency_seconds_total{disk="0"}`:       12,
+		`wmi_physical_disk_write_latency_seconds_total{disk="0"}`:      35,
+		`wmi_physical_disk_read_write_latency_seconds_total{disk="0"}`: 47,
+		`wmi_physical_disk_read_latency_seconds_total{disk="1"}`:       4,
+		`wmi_physical_disk_read_queue_length_seconds_total{disk="0"}`:  12,
+		`wmi_physical_disk_write_queue_length_seconds_total{disk="1"}`: 9,
+		`wmi_physical_disk_volume_info{disk="0",volume="C:"}`:          1,
+		`wmi_physical_disk_volume_info{disk="1",volume="D:"}`:          1,
+		`wmi_physical_disk_volume_info{disk="1",volume="E:"}`:          1,
+		`wmi_physical_disk_requests_queued{disk="2"}`:                  0,
+	})
+
+	for k := range samples {
+		if strings.HasPrefix(k, "wmi_physical_disk_volume_info{disk=\"2\"") {
+			t.Errorf("Unexpected volume of the empty disk %s", k)
+		}
+	}
+}
+
+func TestPhysicalDiskCollectorFilter(t *testing.T) {
+	filter, err := newInstanceFilter("physical_disk", "disk", []string{"0|2"}, []string{"2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPhysicalDiskCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(*PhysicalDiskCollector).diskFilter = filter
+	out := gatherCollector(t, c, newPhysicalDiskFixtureContext())
+
+	if !strings.Contains(out, `wmi_physical_disk_reads_total{disk="0"} 150000`+"\n") {
+		t.Errorf("Expected the included disk in output:\n%s", out)
+	}
+	for _, disk := range []string{`disk="1"`, `disk="2"`} {
+		if strings.Contains(out, disk) {
+			t.Errorf("Unexpected %s in output:\n%s", disk, out)
+		}
+	}
+}
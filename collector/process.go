@@ -3,10 +3,14 @@
 package collector
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -19,11 +23,28 @@ func init() {
 var (
 	processWhereClause = kingpin.Flag(
 		"collector.process.processes-where",
-		"WQL 'where' clause to use in WMI metrics query. Limits the response to the processes you specify and reduces the size of the response.",
+		"Deprecated, use --collector.process.process-include instead. WQL 'where' clause selecting the processes to report. Runs an additional WMI query on every scrape.",
+	).Default("").String()
+	processFilterFlags = newInstanceFilterFlags("process", "process", "processes")
+	processGroupByName = kingpin.Flag(
+		"collector.process.group-by-name",
+		"Sum the metrics of all processes with the same name instead of reporting every process separately.",
+	).Bool()
+	processGroupExpiry = kingpin.Flag(
+		"collector.process.group-expiry",
+		"Time after which a group whose processes have all exited is no longer reported when processes are grouped by name. Its counters restart from zero if processes with the name start again. 0 keeps the groups forever.",
+	).Default("1h").Duration()
+	processReportInfo = kingpin.Flag(
+		"collector.process.info",
+		"Report the owner, executable path and hosted services of every process in wmi_process_info.",
+	).Bool()
+	processCommandLineRegex = kingpin.Flag(
+		"collector.process.command-line-regex",
+		"Regexp matched against the command line of every process. The first capture group, or the whole match, is reported in the command_line label of wmi_process_info.",
 	).Default("").String()
 )
 
-// A ProcessCollector is a Prometheus collector for WMI Win32_PerfRawData_PerfProc_Process metrics
+// A ProcessCollector is a Prometheus collector for perflib Process metrics
 type ProcessCollector struct {
 	StartTime         *prometheus.Desc
 	CPUTimeTotal      *prometheus.Desc
@@ -38,105 +59,168 @@ type ProcessCollector struct {
 	ThreadCount       *prometheus.Desc
 	VirtualBytes      *prometheus.Desc
 	WorkingSet        *prometheus.Desc
+	GroupCount        *prometheus.Desc
+	Info              *prometheus.Desc
 
 	queryWhereClause string
+	processFilter    *instanceFilter
+	groupByName      bool
+	groupExpiry      time.Duration
+	reportInfo       bool
+	commandLineRegex *regexp.Regexp
+	lookupOwner      func(pid uint32) (string, error)
+
+	ownersMu sync.Mutex
+	owners   map[processInstance]string
+
+	groupsMu sync.Mutex
+	groups   map[string]*processGroup
+	now      func() time.Time
 }
 
 // NewProcessCollector ...
 func NewProcessCollector() (Collector, error) {
 	const subsystem = "process"
 
-	if *processWhereClause == "" {
-		log.Warn("No where-clause specified for process collector. This will generate a very large number of metrics!")
+	if err := validateWhereClause(&[]Win32_PerfRawData_PerfProc_Process{}, *processWhereClause); err != nil {
+		return nil, fmt.Errorf("--collector.process.processes-where: %v", err)
+	}
+
+	processFilter, err := processFilterFlags.newFilter(subsystem, "process", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var commandLineRegex *regexp.Regexp
+	if *processCommandLineRegex != "" {
+		if commandLineRegex, err = regexp.Compile(*processCommandLineRegex); err != nil {
+			return nil, fmt.Errorf("--collector.process.command-line-regex: %v", err)
+		}
+	}
+	if *processReportInfo && *processGroupByName {
+		log.Warn("wmi_process_info is not reported when processes are grouped by name")
+	}
+
+	if *processWhereClause == "" && len(processFilter.include) == 0 {
+		log.Warn("No where-clause or include filter specified for process collector. This will generate a very large number of metrics!")
+	}
+
+	instanceLabels := []string{"process", "process_id", "creating_process_id"}
+	if *processGroupByName {
+		instanceLabels = []string{"process"}
+	}
+	labels := func(extra ...string) []string {
+		return append(append([]string{}, instanceLabels...), extra...)
 	}
 
 	return &ProcessCollector{
 		StartTime: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "start_time"),
 			"Time of process start.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		CPUTimeTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "cpu_time_total"),
 			"Returns elapsed time that all of the threads of this process used the processor to execute instructions by mode (privileged, user). An instruction is the basic unit of execution in a computer, a thread is the object that executes instructions, and a process is the object created when a program is run. Code executed to handle some hardware interrupts and trap conditions is included in this count.",
-			[]string{"process", "process_id", "creating_process_id", "mode"},
+			labels("mode"),
 			nil,
 		),
 		HandleCount: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "handle_count"),
 			"Total number of handles the process has open. This number is the sum of the handles currently open by each thread in the process.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		IOBytesTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "io_bytes_total"),
 			"Bytes issued to I/O operations in different modes (read, write, other). This property counts all I/O activity generated by the process to include file, network, and device I/Os. Read and write mode includes data operations; other mode includes those that do not involve data, such as control operations. ",
-			[]string{"process", "process_id", "creating_process_id", "mode"},
+			labels("mode"),
 			nil,
 		),
 		IOOperationsTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "io_operations_total"),
 			"I/O operations issued in different modes (read, write, other). This property counts all I/O activity generated by the process to include file, network, and device I/Os. Read and write mode includes data operations; other mode includes those that do not involve data, such as control operations. ",
-			[]string{"process", "process_id", "creating_process_id", "mode"},
+			labels("mode"),
 			nil,
 		),
 		PageFaultsTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "page_faults_total"),
 			"Page faults by the threads executing in this process. A page fault occurs when a thread refers to a virtual memory page that is not in its working set in main memory. This can cause the page not to be fetched from disk if it is on the standby list and hence already in main memory, or if it is in use by another process with which the page is shared.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		PageFileBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "page_file_bytes"),
 			"Current number of bytes this process has used in the paging file(s). Paging files are used to store pages of memory used by the process that are not contained in other files. Paging files are shared by all processes, and lack of space in paging files can prevent other processes from allocating memory.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		PoolBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "pool_bytes"),
 			"Pool Bytes is the last observed number of bytes in the paged or nonpaged pool. The nonpaged pool is an area of system memory (physical memory used by the operating system) for objects that cannot be written to disk, but must remain in physical memory as long as they are allocated. The paged pool is an area of system memory (physical memory used by the operating system) for objects that can be written to disk when they are not being used. Nonpaged pool bytes is calculated differently than paged pool bytes, so it might not equal the total of paged pool bytes.",
-			[]string{"process", "process_id", "creating_process_id", "pool"},
+			labels("pool"),
 			nil,
 		),
 		PriorityBase: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "priority_base"),
 			"Current base priority of this process. Threads within a process can raise and lower their own base priority relative to the process base priority of the process.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		PrivateBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "private_bytes"),
 			"Current number of bytes this process has allocated that cannot be shared with other processes.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		ThreadCount: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "thread_count"),
 			"Number of threads currently active in this process. An instruction is the basic unit of execution in a processor, and a thread is the object that executes instructions. Every running process has at least one thread.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		VirtualBytes: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "virtual_bytes"),
 			"Current size, in bytes, of the virtual address space that the process is using. Use of virtual address space does not necessarily imply corresponding use of either disk or main memory pages. Virtual space is finite and, by using too much, the process can limit its ability to load libraries.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
 			nil,
 		),
 		WorkingSet: prometheus.NewDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "working_set"),
 			"Maximum number of bytes in the working set of this process at any point in time. The working set is the set of memory pages touched recently by the threads in the process. If free memory in the computer is above a threshold, pages are left in the working set of a process even if they are not in use. When free memory falls below a threshold, pages are trimmed from working sets. If they are needed, they are then soft-faulted back into the working set before they leave main memory.",
-			[]string{"process", "process_id", "creating_process_id"},
+			labels(),
+			nil,
+		),
+		GroupCount: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "group_count"),
+			"Number of running processes with this name. Only reported if the processes are grouped by name.",
+			[]string{"process"},
+			nil,
+		),
+		Info: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "info"),
+			"Owner, executable path, command line and hosted Windows services of the process.",
+			[]string{"process", "process_id", "creating_process_id", "owner", "executable_path", "command_line", "services"},
 			nil,
 		),
 		queryWhereClause: *processWhereClause,
+		processFilter:    processFilter,
+		groupByName:      *processGroupByName,
+		groupExpiry:      *processGroupExpiry,
+		reportInfo:       *processReportInfo && !*processGroupByName,
+		commandLineRegex: commandLineRegex,
+		lookupOwner:      processOwner,
+		owners:           make(map[processInstance]string),
+		groups:           make(map[string]*processGroup),
+		now:              time.Now,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *ProcessCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting process metrics:", desc, err)
 		return err
 	}
@@ -145,6 +229,9 @@ func (c *ProcessCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 
 // Win32_PerfRawData_PerfProc_Process docs:
 // - https://msdn.microsoft.com/en-us/library/aa394323(v=vs.85).aspx
+//
+// The process metrics are read from perflib; the class is only queried to
+// apply the deprecated processes-where clause.
 type Win32_PerfRawData_PerfProc_Process struct {
 	Name                    string
 	CreatingProcessID       uint32
@@ -179,223 +266,529 @@ type Win32_PerfRawData_PerfProc_Process struct {
 	WorkingSetPrivate       uint64
 }
 
+// processID selects the processes matching the processes-where clause.
+type processID struct {
+	IDProcess uint32
+}
+
+type perflibProcess struct {
+	Name                    string
+	PercentProcessorTime    float64 `perflib:"% Processor Time"`
+	PercentPrivilegedTime   float64 `perflib:"% Privileged Time"`
+	PercentUserTime         float64 `perflib:"% User Time"`
+	CreatingProcessID       float64 `perflib:"Creating Process ID"`
+	ElapsedTime             float64 `perflib:"Elapsed Time"`
+	HandleCount             float64 `perflib:"Handle Count"`
+	IDProcess               float64 `perflib:"ID Process"`
+	IODataBytesPerSec       float64 `perflib:"IO Data Bytes/sec"`
+	IODataOperationsPerSec  float64 `perflib:"IO Data Operations/sec"`
+	IOOtherBytesPerSec      float64 `perflib:"IO Other Bytes/sec"`
+	IOOtherOperationsPerSec float64 `perflib:"IO Other Operations/sec"`
+	IOReadBytesPerSec       float64 `perflib:"IO Read Bytes/sec"`
+	IOReadOperationsPerSec  float64 `perflib:"IO Read Operations/sec"`
+	IOWriteBytesPerSec      float64 `perflib:"IO Write Bytes/sec"`
+	IOWriteOperationsPerSec float64 `perflib:"IO Write Operations/sec"`
+	PageFaultsPerSec        float64 `perflib:"Page Faults/sec"`
+	PageFileBytesPeak       float64 `perflib:"Page File Bytes Peak"`
+	PageFileBytes           float64 `perflib:"Page File Bytes"`
+	PoolNonpagedBytes       float64 `perflib:"Pool Nonpaged Bytes"`
+	PoolPagedBytes          float64 `perflib:"Pool Paged Bytes"`
+	PriorityBase            float64 `perflib:"Priority Base"`
+	PrivateBytes            float64 `perflib:"Private Bytes"`
+	ThreadCount             float64 `perflib:"Thread Count"`
+	VirtualBytesPeak        float64 `perflib:"Virtual Bytes Peak"`
+	VirtualBytes            float64 `perflib:"Virtual Bytes"`
+	WorkingSetPrivate       float64 `perflib:"Working Set - Private"`
+	WorkingSetPeak          float64 `perflib:"Working Set Peak"`
+	WorkingSet              float64 `perflib:"Working Set"`
+}
+
 type WorkerProcess struct {
 	AppPoolName string
 	ProcessId   uint32
 }
 
-func (c *ProcessCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_PerfRawData_PerfProc_Process
-	q := queryAllWhere(&dst, c.queryWhereClause)
-	if err := wmi.Query(q, &dst); err != nil {
-		return nil, err
+type Win32_Process struct {
+	ProcessId      uint32
+	ExecutablePath *string
+	CommandLine    *string
+}
+
+// win32ServiceProcess maps Windows services to their hosting process.
+type win32ServiceProcess struct {
+	Name      string
+	ProcessId uint32
+}
+
+// processDetails holds what is known about a process beyond its perflib
+// counters.
+type processDetails struct {
+	appPool        string
+	executablePath string
+	commandLine    string
+	services       []string
+}
+
+// queryDetails joins the processes with IIS application pools and, if
+// wmi_process_info is reported, with Win32_Process and Win32_Service. A
+// failing query only leaves the corresponding details empty.
+func (c *ProcessCollector) queryDetails(ctx *ScrapeContext) map[uint32]*processDetails {
+	details := make(map[uint32]*processDetails)
+	get := func(pid uint32) *processDetails {
+		d, ok := details[pid]
+		if !ok {
+			d = &processDetails{}
+			details[pid] = d
+		}
+		return d
 	}
 
 	var dst_wp []WorkerProcess
 	q_wp := queryAll(&dst_wp)
-	if err := wmi.QueryNamespace(q_wp, &dst_wp, "root\\WebAdministration"); err != nil {
+	if err := ctx.wmiQueryNamespace(q_wp, &dst_wp, "root\\WebAdministration"); err != nil {
 		log.Debugf("Could not query WebAdministration namespace for IIS worker processes: %v. Skipping", err)
 	}
+	for _, wp := range dst_wp {
+		get(wp.ProcessId).appPool = wp.AppPoolName
+	}
+
+	if !c.reportInfo {
+		return details
+	}
+
+	var dst_p []Win32_Process
+	if err := ctx.wmiQuery(queryAll(&dst_p), &dst_p); err != nil {
+		log.Warnf("Could not query Win32_Process: %v", err)
+	}
+	for _, p := range dst_p {
+		d := get(p.ProcessId)
+		if p.ExecutablePath != nil {
+			d.executablePath = *p.ExecutablePath
+		}
+		if p.CommandLine != nil && c.commandLineRegex != nil {
+			d.commandLine = matchCommandLine(c.commandLineRegex, *p.CommandLine)
+		}
+	}
+
+	var dst_s []win32ServiceProcess
+	q_s := newWQLQuery(&dst_s).from("Win32_Service").and(wqlCompare("ProcessId", "<>", 0)).String()
+	if err := ctx.wmiQuery(q_s, &dst_s); err != nil {
+		log.Warnf("Could not query Win32_Service: %v", err)
+	}
+	for _, s := range dst_s {
+		d := get(s.ProcessId)
+		d.services = append(d.services, s.Name)
+	}
+	for _, d := range details {
+		sort.Strings(d.services)
+	}
+
+	return details
+}
+
+// matchCommandLine returns the first capture group of re in the command
+// line, or the whole match if re has no groups.
+func matchCommandLine(re *regexp.Regexp, commandLine string) string {
+	m := re.FindStringSubmatch(commandLine)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
+
+// owner returns the owner of the process, looking it up only once per
+// process. Processes that cannot be opened have an empty owner.
+func (c *ProcessCollector) owner(instance processInstance) string {
+	c.ownersMu.Lock()
+	defer c.ownersMu.Unlock()
+
+	owner, ok := c.owners[instance]
+	if !ok {
+		var err error
+		if owner, err = c.lookupOwner(uint32(instance.pid)); err != nil {
+			log.Debugf("Could not look up the owner of process %v: %v", instance.pid, err)
+		}
+		c.owners[instance] = owner
+	}
+	return owner
+}
+
+// forgetOwners drops the owners of processes that have exited.
+func (c *ProcessCollector) forgetOwners(live map[processInstance]bool) {
+	c.ownersMu.Lock()
+	defer c.ownersMu.Unlock()
+
+	for instance := range c.owners {
+		if !live[instance] {
+			delete(c.owners, instance)
+		}
+	}
+}
+
+func (c *ProcessCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	var dst []perflibProcess
+	if err := unmarshalObject(ctx.perfObjects["Process"], &dst); err != nil {
+		return nil, err
+	}
+
+	var wherePIDs map[uint32]bool
+	if c.queryWhereClause != "" {
+		var matched []processID
+		q := newWQLQuery(&matched).from("Win32_PerfRawData_PerfProc_Process").and(c.queryWhereClause).String()
+		if err := ctx.wmiQuery(q, &matched); err != nil {
+			return nil, err
+		}
+		wherePIDs = make(map[uint32]bool, len(matched))
+		for _, p := range matched {
+			wherePIDs[p.IDProcess] = true
+		}
+	}
+
+	details := c.queryDetails(ctx)
+	live := make(map[processInstance]bool)
+
+	var groups map[string]map[processInstance]processValues
+	if c.groupByName {
+		groups = make(map[string]map[processInstance]processValues)
+	}
 
 	for _, process := range dst {
 
 		if process.Name == "_Total" {
 			continue
 		}
+		if wherePIDs != nil && !wherePIDs[uint32(process.IDProcess)] {
+			continue
+		}
 		// Duplicate processes are suffixed # and an index number. Remove those.
 		processName := strings.Split(process.Name, "#")[0]
 		pid := strconv.FormatUint(uint64(process.IDProcess), 10)
 		cpid := strconv.FormatUint(uint64(process.CreatingProcessID), 10)
 
-		for _, wp := range dst_wp {
-			if wp.ProcessId == process.IDProcess {
-				processName = strings.Join([]string{processName, wp.AppPoolName}, "_")
-				break
+		d := details[uint32(process.IDProcess)]
+		if d == nil {
+			d = &processDetails{}
+		}
+		if d.appPool != "" {
+			processName = strings.Join([]string{processName, d.appPool}, "_")
+		}
+
+		labels := map[string]string{"process": processName, "process_id": pid, "creating_process_id": cpid}
+		if !c.processFilter.keep(ctx, processName, labels) {
+			continue
+		}
+
+		if c.groupByName {
+			instances := groups[processName]
+			if instances == nil {
+				instances = make(map[processInstance]processValues)
+				groups[processName] = instances
 			}
+			instances[processInstance{pid: process.IDProcess, startTime: process.ElapsedTime}] = newProcessValues(process)
+			continue
 		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.StartTime,
 			prometheus.GaugeValue,
-			// convert from Windows timestamp (1 jan 1601) to unix timestamp (1 jan 1970)
-			float64(process.ElapsedTime-116444736000000000)/float64(process.Frequency_Object),
+			process.ElapsedTime,
 			processName,
 			pid,
 			cpid,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
-			c.HandleCount,
+			c.PriorityBase,
 			prometheus.GaugeValue,
-			float64(process.HandleCount),
-			processName,
-			pid,
-			cpid,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.CPUTimeTotal,
-			prometheus.CounterValue,
-			float64(process.PercentPrivilegedTime)*ticksToSecondsScaleFactor,
-			processName,
-			pid,
-			cpid,
-			"privileged",
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.CPUTimeTotal,
-			prometheus.CounterValue,
-			float64(process.PercentUserTime)*ticksToSecondsScaleFactor,
+			process.PriorityBase,
 			processName,
 			pid,
 			cpid,
-			"user",
 		)
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOBytesTotal,
-			prometheus.CounterValue,
-			float64(process.IOOtherBytesPersec),
-			processName,
-			pid,
-			cpid,
-			"other",
-		)
+		c.collectValues(ch, newProcessValues(process), processName, pid, cpid)
+
+		if c.reportInfo {
+			instance := processInstance{pid: process.IDProcess, startTime: process.ElapsedTime}
+			live[instance] = true
+
+			ch <- prometheus.MustNewConstMetric(
+				c.Info,
+				prometheus.GaugeValue,
+				1,
+				processName,
+				pid,
+				cpid,
+				c.owner(instance),
+				d.executablePath,
+				d.commandLine,
+				strings.Join(d.services, ","),
+			)
+		}
+	}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOOperationsTotal,
-			prometheus.CounterValue,
-			float64(process.IOOtherOperationsPersec),
-			processName,
-			pid,
-			cpid,
-			"other",
-		)
+	if c.groupByName {
+		c.collectGroups(ch, groups)
+	}
+	if c.reportInfo {
+		c.forgetOwners(live)
+	}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOBytesTotal,
-			prometheus.CounterValue,
-			float64(process.IOReadBytesPersec),
-			processName,
-			pid,
-			cpid,
-			"read",
-		)
+	return nil, nil
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOOperationsTotal,
-			prometheus.CounterValue,
-			float64(process.IOReadOperationsPersec),
-			processName,
-			pid,
-			cpid,
-			"read",
-		)
+// processValues holds the summable metrics of a process.
+type processValues struct {
+	CPUPrivileged     float64
+	CPUUser           float64
+	IOOtherBytes      float64
+	IOOtherOperations float64
+	IOReadBytes       float64
+	IOReadOperations  float64
+	IOWriteBytes      float64
+	IOWriteOperations float64
+	PageFaults        float64
+
+	HandleCount       float64
+	PageFileBytes     float64
+	PoolNonpagedBytes float64
+	PoolPagedBytes    float64
+	PrivateBytes      float64
+	ThreadCount       float64
+	VirtualBytes      float64
+	WorkingSet        float64
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOBytesTotal,
-			prometheus.CounterValue,
-			float64(process.IOWriteBytesPersec),
-			processName,
-			pid,
-			cpid,
-			"write",
-		)
+func newProcessValues(p perflibProcess) processValues {
+	return processValues{
+		CPUPrivileged:     p.PercentPrivilegedTime,
+		CPUUser:           p.PercentUserTime,
+		IOOtherBytes:      p.IOOtherBytesPerSec,
+		IOOtherOperations: p.IOOtherOperationsPerSec,
+		IOReadBytes:       p.IOReadBytesPerSec,
+		IOReadOperations:  p.IOReadOperationsPerSec,
+		IOWriteBytes:      p.IOWriteBytesPerSec,
+		IOWriteOperations: p.IOWriteOperationsPerSec,
+		PageFaults:        p.PageFaultsPerSec,
+		HandleCount:       p.HandleCount,
+		PageFileBytes:     p.PageFileBytes,
+		PoolNonpagedBytes: p.PoolNonpagedBytes,
+		PoolPagedBytes:    p.PoolPagedBytes,
+		PrivateBytes:      p.PrivateBytes,
+		ThreadCount:       p.ThreadCount,
+		VirtualBytes:      p.VirtualBytes,
+		WorkingSet:        p.WorkingSet,
+	}
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.IOOperationsTotal,
-			prometheus.CounterValue,
-			float64(process.IOWriteOperationsPersec),
-			processName,
-			pid,
-			cpid,
-			"write",
-		)
+// addCounters adds the counters of o, leaving the gauges untouched.
+func (v *processValues) addCounters(o processValues) {
+	v.CPUPrivileged += o.CPUPrivileged
+	v.CPUUser += o.CPUUser
+	v.IOOtherBytes += o.IOOtherBytes
+	v.IOOtherOperations += o.IOOtherOperations
+	v.IOReadBytes += o.IOReadBytes
+	v.IOReadOperations += o.IOReadOperations
+	v.IOWriteBytes += o.IOWriteBytes
+	v.IOWriteOperations += o.IOWriteOperations
+	v.PageFaults += o.PageFaults
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PageFaultsTotal,
-			prometheus.CounterValue,
-			float64(process.PageFaultsPersec),
-			processName,
-			pid,
-			cpid,
-		)
+func (v *processValues) add(o processValues) {
+	v.addCounters(o)
+	v.HandleCount += o.HandleCount
+	v.PageFileBytes += o.PageFileBytes
+	v.PoolNonpagedBytes += o.PoolNonpagedBytes
+	v.PoolPagedBytes += o.PoolPagedBytes
+	v.PrivateBytes += o.PrivateBytes
+	v.ThreadCount += o.ThreadCount
+	v.VirtualBytes += o.VirtualBytes
+	v.WorkingSet += o.WorkingSet
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PageFileBytes,
-			prometheus.GaugeValue,
-			float64(process.PageFileBytes),
-			processName,
-			pid,
-			cpid,
-		)
+// processInstance identifies a process across scrapes. The start time
+// guards against process IDs being reused.
+type processInstance struct {
+	pid       float64
+	startTime float64
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PoolBytes,
-			prometheus.GaugeValue,
-			float64(process.PoolNonpagedBytes),
-			processName,
-			pid,
-			cpid,
-			"nonpaged",
-		)
+// processGroup tracks the processes sharing a name. The counters of
+// processes that have exited are kept in exited, so that the counters of
+// the group never decrease, until the group expires.
+type processGroup struct {
+	exited   processValues
+	live     map[processInstance]processValues
+	lastSeen time.Time
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PoolBytes,
-			prometheus.GaugeValue,
-			float64(process.PoolPagedBytes),
-			processName,
-			pid,
-			cpid,
-			"paged",
-		)
+func (c *ProcessCollector) collectGroups(ch chan<- prometheus.Metric, current map[string]map[processInstance]processValues) {
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PriorityBase,
-			prometheus.GaugeValue,
-			float64(process.PriorityBase),
-			processName,
-			pid,
-			cpid,
-		)
+	now := c.now()
+	for name := range current {
+		if c.groups[name] == nil {
+			c.groups[name] = &processGroup{}
+		}
+		c.groups[name].lastSeen = now
+	}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.PrivateBytes,
-			prometheus.GaugeValue,
-			float64(process.PrivateBytes),
-			processName,
-			pid,
-			cpid,
-		)
+	for name, group := range c.groups {
+		instances := current[name]
+		if len(instances) == 0 && c.groupExpiry > 0 && now.Sub(group.lastSeen) >= c.groupExpiry {
+			// Forgetting the group resets its counters, should processes
+			// with the name start again.
+			delete(c.groups, name)
+			continue
+		}
+		for instance, last := range group.live {
+			if _, ok := instances[instance]; !ok {
+				group.exited.addCounters(last)
+			}
+		}
+		group.live = instances
 
-		ch <- prometheus.MustNewConstMetric(
-			c.ThreadCount,
-			prometheus.GaugeValue,
-			float64(process.ThreadCount),
-			processName,
-			pid,
-			cpid,
-		)
+		total := group.exited
+		for _, v := range instances {
+			total.add(v)
+		}
 
 		ch <- prometheus.MustNewConstMetric(
-			c.VirtualBytes,
+			c.GroupCount,
 			prometheus.GaugeValue,
-			float64(process.VirtualBytes),
-			processName,
-			pid,
-			cpid,
+			float64(len(instances)),
+			name,
 		)
+		c.collectValues(ch, total, name)
+	}
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			c.WorkingSet,
-			prometheus.GaugeValue,
-			float64(process.WorkingSet),
-			processName,
-			pid,
-			cpid,
-		)
+func (c *ProcessCollector) collectValues(ch chan<- prometheus.Metric, v processValues, labelValues ...string) {
+	label := func(extra string) []string {
+		return append(append([]string{}, labelValues...), extra)
 	}
 
-	return nil, nil
+	ch <- prometheus.MustNewConstMetric(
+		c.HandleCount,
+		prometheus.GaugeValue,
+		v.HandleCount,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.CPUTimeTotal,
+		prometheus.CounterValue,
+		v.CPUPrivileged,
+		label("privileged")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.CPUTimeTotal,
+		prometheus.CounterValue,
+		v.CPUUser,
+		label("user")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOBytesTotal,
+		prometheus.CounterValue,
+		v.IOOtherBytes,
+		label("other")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOOperationsTotal,
+		prometheus.CounterValue,
+		v.IOOtherOperations,
+		label("other")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOBytesTotal,
+		prometheus.CounterValue,
+		v.IOReadBytes,
+		label("read")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOOperationsTotal,
+		prometheus.CounterValue,
+		v.IOReadOperations,
+		label("read")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOBytesTotal,
+		prometheus.CounterValue,
+		v.IOWriteBytes,
+		label("write")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.IOOperationsTotal,
+		prometheus.CounterValue,
+		v.IOWriteOperations,
+		label("write")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PageFaultsTotal,
+		prometheus.CounterValue,
+		v.PageFaults,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PageFileBytes,
+		prometheus.GaugeValue,
+		v.PageFileBytes,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PoolBytes,
+		prometheus.GaugeValue,
+		v.PoolNonpagedBytes,
+		label("nonpaged")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PoolBytes,
+		prometheus.GaugeValue,
+		v.PoolPagedBytes,
+		label("paged")...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.PrivateBytes,
+		prometheus.GaugeValue,
+		v.PrivateBytes,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.ThreadCount,
+		prometheus.GaugeValue,
+		v.ThreadCount,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.VirtualBytes,
+		prometheus.GaugeValue,
+		v.VirtualBytes,
+		labelValues...,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.WorkingSet,
+		prometheus.GaugeValue,
+		v.WorkingSet,
+		labelValues...,
+	)
 }
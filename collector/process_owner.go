@@ -0,0 +1,38 @@
+// +build windows
+
+package collector
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// PROCESS_QUERY_LIMITED_INFORMATION is not defined by x/sys/windows.
+const processQueryLimitedInformation = 0x1000
+
+// processOwner returns the account running the process, as DOMAIN\user.
+func processOwner(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+
+	var token windows.Token
+	if err := windows.OpenProcessToken(h, windows.TOKEN_QUERY, &token); err != nil {
+		return "", err
+	}
+	defer token.Close()
+
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	account, domain, _, err := user.User.Sid.LookupAccount("")
+	if err != nil {
+		return "", err
+	}
+	if domain == "" {
+		return account, nil
+	}
+	return domain + `\` + account, nil
+}
@@ -0,0 +1,271 @@
+// +build windows
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	perflibCollector "github.com/leoluk/perflib_exporter/collector"
+)
+
+// processFixture returns a perflib Process instance. Times are in seconds.
+func processFixture(name string, pid, creatingPID int64, cpuUser, cpuPrivileged float64, startTime int64, workingSet int64) perflibInstanceFixture {
+	const frequency = 1e7
+	return perflibInstanceFixture{
+		name: name,
+		counters: []perflibCounterFixture{
+			{"% Processor Time", perflibCollector.PERF_100NSEC_TIMER, int64((cpuUser + cpuPrivileged) * 1e7)},
+			{"% User Time", perflibCollector.PERF_100NSEC_TIMER, int64(cpuUser * 1e7)},
+			{"% Privileged Time", perflibCollector.PERF_100NSEC_TIMER, int64(cpuPrivileged * 1e7)},
+			{"Elapsed Time", perflibCollector.PERF_ELAPSED_TIME, windowsEpoch + startTime*frequency},
+			{"ID Process", perflibCollector.PERF_COUNTER_RAWCOUNT, pid},
+			{"Creating Process ID", perflibCollector.PERF_COUNTER_RAWCOUNT, creatingPID},
+			{"Handle Count", perflibCollector.PERF_COUNTER_RAWCOUNT, 120},
+			{"Thread Count", perflibCollector.PERF_COUNTER_RAWCOUNT, 8},
+			{"Priority Base", perflibCollector.PERF_COUNTER_RAWCOUNT, 8},
+			{"IO Read Bytes/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 4096},
+			{"IO Read Operations/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 4},
+			{"IO Write Bytes/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 2048},
+			{"IO Write Operations/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 2},
+			{"IO Other Bytes/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 512},
+			{"IO Other Operations/sec", perflibCollector.PERF_COUNTER_BULK_COUNT, 1},
+			{"Page Faults/sec", perflibCollector.PERF_COUNTER_COUNTER, 300},
+			{"Page File Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, 1 << 20},
+			{"Pool Nonpaged Bytes", perflibCollector.PERF_COUNTER_RAWCOUNT, 1 << 10},
+			{"Pool Paged Bytes", perflibCollector.PERF_COUNTER_RAWCOUNT, 1 << 11},
+			{"Private Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, 1 << 21},
+			{"Virtual Bytes", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, 1 << 30},
+			{"Working Set", perflibCollector.PERF_COUNTER_LARGE_RAWCOUNT, workingSet},
+		},
+	}
+}
+
+// processWMI answers the WMI queries of the process collector.
+func processWMI(wherePIDs ...uint32) wmiQueryFunc {
+	return func(query string, dst interface{}, namespace string) error {
+		switch dst := dst.(type) {
+		case *[]WorkerProcess:
+			*dst = append(*dst, WorkerProcess{AppPoolName: "DefaultAppPool", ProcessId: 300})
+		case *[]Win32_Process:
+			exe, cmd := `C:\Windows\system32\svchost.exe`, `C:\Windows\system32\svchost.exe -k netsvcs -p`
+			*dst = append(*dst,
+				Win32_Process{ProcessId: 100, ExecutablePath: &exe, CommandLine: &cmd},
+				Win32_Process{ProcessId: 200},
+			)
+		case *[]win32ServiceProcess:
+			if !strings.Contains(query, "ProcessId <> 0") {
+				return errors.New("expected services to be filtered by process ID")
+			}
+			*dst = append(*dst,
+				win32ServiceProcess{Name: "Schedule", ProcessId: 100},
+				win32ServiceProcess{Name: "BITS", ProcessId: 100},
+				win32ServiceProcess{Name: "W3SVC", ProcessId: 300},
+			)
+		case *[]processID:
+			if !strings.Contains(query, " WHERE ") {
+				return errors.New("expected a where clause")
+			}
+			for _, pid := range wherePIDs {
+				*dst = append(*dst, processID{IDProcess: pid})
+			}
+		default:
+			return errors.New("unexpected query " + query)
+		}
+		return nil
+	}
+}
+
+func newProcessFixtureContext(wmi wmiQueryFunc, instances ...perflibInstanceFixture) *ScrapeContext {
+	if len(instances) == 0 {
+		instances = []perflibInstanceFixture{
+			processFixture("_Total", 0, 0, 10, 5, 0, 0),
+			processFixture("svchost", 100, 4, 2.5, 1, 1500000000, 1<<24),
+			processFixture("svchost#1", 200, 4, 0.5, 0.25, 1500000100, 1<<23),
+			processFixture("w3wp", 300, 100, 7, 3, 1500000200, 1<<25),
+		}
+	}
+	return newScrapeContext(perflibObjects{
+		"Process": newPerflibFixture("Process", 1e7, instances...),
+	}, wmi)
+}
+
+func TestProcessCollector(t *testing.T) {
+	c, err := NewProcessCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := gatherCollector(t, c, newProcessFixtureContext(processWMI()))
+
+	for _, line := range []string{
+		`wmi_process_cpu_time_total{creating_process_id="4",mode="privileged",process="svchost",process_id="100"} 1`,
+		`wmi_process_cpu_time_total{creating_process_id="4",mode="user",process="svchost",process_id="100"} 2.5`,
+		`wmi_process_cpu_time_total{creating_process_id="4",mode="user",process="svchost",process_id="200"} 0.5`,
+		`wmi_process_start_time{creating_process_id="4",process="svchost",process_id="100"} 1.5e+09`,
+		`wmi_process_working_set{creating_process_id="100",process="w3wp_DefaultAppPool",process_id="300"} 3.3554432e+07`,
+		`wmi_process_io_bytes_total{creating_process_id="4",mode="read",process="svchost",process_id="100"} 4096`,
+		`wmi_process_io_operations_total{creating_process_id="4",mode="other",process="svchost",process_id="100"} 1`,
+		`wmi_process_pool_bytes{creating_process_id="4",pool="paged",process="svchost",process_id="100"} 2048`,
+		`wmi_process_handle_count{creating_process_id="4",process="svchost",process_id="200"} 120`,
+		`wmi_process_page_faults_total{creating_process_id="4",process="svchost",process_id="200"} 300`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, `process="_Total"`) {
+		t.Errorf("Expected _Total to be skipped, output:\n%s", out)
+	}
+}
+
+func TestProcessCollectorFilters(t *testing.T) {
+	cases := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		where    string
+		expected []string
+	}{
+		{"include", []string{"w3wp.*"}, nil, "", []string{"300"}},
+		{"exclude", nil, []string{"svchost"}, "", []string{"300"}},
+		{"exclude by label", nil, []string{"process_id=~100"}, "", []string{"200", "300"}},
+		{"where", nil, nil, "IDProcess = 100", []string{"100"}},
+		{"where and include", []string{"svchost"}, nil, "IDProcess > 100", []string{"200"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewProcessCollector()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pc := c.(*ProcessCollector)
+			if pc.processFilter, err = newInstanceFilter("process", "process", tc.include, tc.exclude); err != nil {
+				t.Fatal(err)
+			}
+			pc.queryWhereClause = tc.where
+
+			wherePIDs := []uint32{100}
+			if tc.where == "IDProcess > 100" {
+				wherePIDs = []uint32{200, 300}
+			}
+			out := gatherCollector(t, c, newProcessFixtureContext(processWMI(wherePIDs...)))
+
+			var got []string
+			for _, pid := range []string{"100", "200", "300"} {
+				if strings.Contains(out, `,process_id="`+pid+`"`) {
+					got = append(got, pid)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("Expected processes %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestProcessCollectorGroupByName(t *testing.T) {
+	*processGroupByName = true
+	defer func() { *processGroupByName = false }()
+
+	c, err := NewProcessCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := gatherCollector(t, c, newProcessFixtureContext(processWMI()))
+	for _, line := range []string{
+		`wmi_process_group_count{process="svchost"} 2`,
+		`wmi_process_group_count{process="w3wp_DefaultAppPool"} 1`,
+		`wmi_process_cpu_time_total{mode="user",process="svchost"} 3`,
+		`wmi_process_working_set{process="svchost"} 2.5165824e+07`,
+		`wmi_process_thread_count{process="svchost"} 16`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "process_id=") || strings.Contains(out, "wmi_process_start_time") {
+		t.Errorf("Expected no per-process series, output:\n%s", out)
+	}
+
+	// Process 200 exits and its ID is reused by a new svchost, process 100
+	// keeps running, and the IIS worker process exits.
+	out = gatherCollector(t, c, newProcessFixtureContext(processWMI(),
+		processFixture("svchost", 100, 4, 3, 1.5, 1500000000, 1<<24),
+		processFixture("svchost#1", 200, 4, 0.125, 0, 1500000300, 1<<23),
+		processFixture("svchost#2", 400, 4, 0.25, 0, 1500000400, 1<<23),
+	))
+	for _, line := range []string{
+		`wmi_process_group_count{process="svchost"} 3`,
+		`wmi_process_group_count{process="w3wp_DefaultAppPool"} 0`,
+		`wmi_process_cpu_time_total{mode="user",process="svchost"} 3.875`,
+		`wmi_process_cpu_time_total{mode="privileged",process="svchost"} 1.75`,
+		`wmi_process_cpu_time_total{mode="user",process="w3wp_DefaultAppPool"} 7`,
+		`wmi_process_thread_count{process="w3wp_DefaultAppPool"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+
+	// The group of the IIS worker process expires an hour after it exited.
+	now := time.Now()
+	c.(*ProcessCollector).groupExpiry = time.Hour
+	c.(*ProcessCollector).now = func() time.Time { return now.Add(59 * time.Minute) }
+	out = gatherCollector(t, c, newProcessFixtureContext(processWMI(),
+		processFixture("svchost", 100, 4, 3, 1.5, 1500000000, 1<<24),
+	))
+	if !strings.Contains(out, `wmi_process_group_count{process="w3wp_DefaultAppPool"} 0`) {
+		t.Errorf("Expected group before expiry, output:\n%s", out)
+	}
+	c.(*ProcessCollector).now = func() time.Time { return now.Add(61 * time.Minute) }
+	out = gatherCollector(t, c, newProcessFixtureContext(processWMI(),
+		processFixture("svchost", 100, 4, 3, 1.5, 1500000000, 1<<24),
+	))
+	if strings.Contains(out, `process="w3wp_DefaultAppPool"`) {
+		t.Errorf("Expected expired group to be dropped, output:\n%s", out)
+	}
+	if !strings.Contains(out, `wmi_process_group_count{process="svchost"} 1`) {
+		t.Errorf("Expected live group to be kept, output:\n%s", out)
+	}
+}
+
+func TestProcessCollectorInfo(t *testing.T) {
+	*processReportInfo = true
+	*processCommandLineRegex = ` -k (\S+)`
+	defer func() {
+		*processReportInfo = false
+		*processCommandLineRegex = ""
+	}()
+
+	c, err := NewProcessCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := 0
+	c.(*ProcessCollector).lookupOwner = func(pid uint32) (string, error) {
+		lookups++
+		if pid == 200 {
+			return "", errors.New("access denied")
+		}
+		return `NT AUTHORITY\SYSTEM`, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		out := gatherCollector(t, c, newProcessFixtureContext(processWMI()))
+		for _, line := range []string{
+			`wmi_process_info{command_line="netsvcs",creating_process_id="4",executable_path="C:\\Windows\\system32\\svchost.exe",owner="NT AUTHORITY\\SYSTEM",process="svchost",process_id="100",services="BITS,Schedule"} 1`,
+			`wmi_process_info{command_line="",creating_process_id="4",executable_path="",owner="",process="svchost",process_id="200",services=""} 1`,
+			`wmi_process_info{command_line="",creating_process_id="100",executable_path="",owner="NT AUTHORITY\\SYSTEM",process="w3wp_DefaultAppPool",process_id="300",services="W3SVC"} 1`,
+		} {
+			if !strings.Contains(out, line+"\n") {
+				t.Errorf("Expected %q in output:\n%s", line, out)
+			}
+		}
+	}
+	if lookups != 3 {
+		t.Errorf("Expected owners to be looked up once per process, got %d lookups", lookups)
+	}
+}
@@ -0,0 +1,24 @@
+// +build windows
+
+package collector
+
+import (
+	"github.com/StackExchange/wmi"
+)
+
+// PrepareScrapeContext creates a ScrapeContext to be used during a single scrape
+func PrepareScrapeContext() (*ScrapeContext, error) {
+	objs, err := getPerflibSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return newScrapeContext(objs, queryWMI), nil
+}
+
+func queryWMI(query string, dst interface{}, namespace string) error {
+	if namespace == "" {
+		return wmi.Query(query, dst)
+	}
+	return wmi.QueryNamespace(query, dst, namespace)
+}
@@ -1,11 +1,12 @@
-// +build windows
-
 package collector
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
-	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,6 +21,15 @@ var (
 		"collector.service.services-where",
 		"WQL 'where' clause to use in WMI metrics query. Limits the response to the services you specify and reduces the size of the response.",
 	).Default("").String()
+	serviceFilterFlags = newInstanceFilterFlags("service", "service", "services")
+	serviceCompact     = kingpin.Flag(
+		"collector.service.compact",
+		"Report a single wmi_service_info series per service instead of one series per possible state, start mode and status.",
+	).Bool()
+	serviceUseAPI = kingpin.Flag(
+		"collector.service.use-api",
+		"Read the services from the Service Control Manager API instead of WMI. Also reports delayed auto-start, trigger-start and failure actions.",
+	).Bool()
 )
 
 // A serviceCollector is a Prometheus collector for WMI Win32_Service metrics
@@ -28,15 +38,43 @@ type serviceCollector struct {
 	StartMode *prometheus.Desc
 	Status    *prometheus.Desc
 
+	Info                    *prometheus.Desc
+	ProcessID               *prometheus.Desc
+	ProcessStartTime        *prometheus.Desc
+	ExitCode                *prometheus.Desc
+	ServiceSpecificExitCode *prometheus.Desc
+
+	DelayedAutoStart   *prometheus.Desc
+	TriggerStart       *prometheus.Desc
+	FailureActionDelay *prometheus.Desc
+	FailureResetPeriod *prometheus.Desc
+
 	queryWhereClause string
+	serviceFilter    *instanceFilter
+	compact          bool
+	useAPI           bool
+	openManager      func() (serviceManager, error)
 }
 
 // NewserviceCollector ...
 func NewserviceCollector() (Collector, error) {
 	const subsystem = "service"
 
-	if *serviceWhereClause == "" {
-		log.Warn("No where-clause specified for service collector. This will generate a very large number of metrics!")
+	if err := validateWhereClause(&[]Win32_Service{}, *serviceWhereClause); err != nil {
+		return nil, fmt.Errorf("--collector.service.services-where: %v", err)
+	}
+
+	if *serviceUseAPI && *serviceWhereClause != "" {
+		return nil, errors.New("--collector.service.services-where cannot be used with --collector.service.use-api, use --collector.service.service-include instead")
+	}
+
+	serviceFilter, err := serviceFilterFlags.newFilter(subsystem, "service", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if *serviceWhereClause == "" && len(serviceFilter.include) == 0 {
+		log.Warn("No where-clause or include filter specified for service collector. This will generate a very large number of metrics!")
 	}
 
 	return &serviceCollector{
@@ -58,14 +96,72 @@ func NewserviceCollector() (Collector, error) {
 			[]string{"name", "status"},
 			nil,
 		),
+		Info: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "info"),
+			"The state, start mode, display name and account of the service. Only reported in compact mode",
+			[]string{"name", "display_name", "state", "start_mode", "run_as"},
+			nil,
+		),
+		ProcessID: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "process_id"),
+			"The ID of the process hosting the service, 0 if the service is not running (ProcessId). Only reported in compact mode",
+			[]string{"name"},
+			nil,
+		),
+		ProcessStartTime: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "process_start_time"),
+			"Time of start of the process hosting the service. Only reported in compact mode",
+			[]string{"name"},
+			nil,
+		),
+		ExitCode: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "exit_code"),
+			"The Windows error code of the last start or stop of the service (ExitCode). Only reported in compact mode",
+			[]string{"name"},
+			nil,
+		),
+		ServiceSpecificExitCode: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "specific_exit_code"),
+			"The service-specific error code of the last start or stop of the service (ServiceSpecificExitCode). Only reported in compact mode",
+			[]string{"name"},
+			nil,
+		),
+		DelayedAutoStart: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "delayed_auto_start"),
+			"Whether the automatically started service is started after the other auto-start services. Only reported when using the service control manager API",
+			[]string{"name"},
+			nil,
+		),
+		TriggerStart: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "trigger_start"),
+			"Whether the service is started or stopped by trigger events. Only reported when using the service control manager API",
+			[]string{"name"},
+			nil,
+		),
+		FailureActionDelay: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "failure_action_delay_seconds"),
+			"Time to wait before performing the action on the given failure of the service. The last action is repeated on subsequent failures. Only reported when using the service control manager API",
+			[]string{"name", "failure", "action"},
+			nil,
+		),
+		FailureResetPeriod: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "failure_reset_period_seconds"),
+			"Time without failures after which the failure count of the service is reset. Only reported when using the service control manager API",
+			[]string{"name"},
+			nil,
+		),
 		queryWhereClause: *serviceWhereClause,
+		serviceFilter:    serviceFilter,
+		compact:          *serviceCompact,
+		useAPI:           *serviceUseAPI,
+		openManager:      openServiceManager,
 	}, nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *serviceCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
-	if desc, err := c.collect(ch); err != nil {
+	if desc, err := c.collect(ctx, ch); err != nil {
 		log.Error("failed collecting service metrics:", desc, err)
 		return err
 	}
@@ -75,10 +171,33 @@ func (c *serviceCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metr
 // Win32_Service docs:
 // - https://msdn.microsoft.com/en-us/library/aa394418(v=vs.85).aspx
 type Win32_Service struct {
-	Name      string
-	State     string
-	Status    string
-	StartMode string
+	Name                    string
+	DisplayName             string
+	State                   string
+	Status                  string
+	StartMode               string
+	StartName               *string
+	ProcessId               uint32
+	ExitCode                uint32
+	ServiceSpecificExitCode uint32
+}
+
+// serviceInfo describes a service, as read from WMI or the service control
+// manager.
+type serviceInfo struct {
+	Name                    string
+	DisplayName             string
+	State                   string
+	Status                  string
+	StartMode               string
+	RunAs                   string
+	ProcessID               uint32
+	ExitCode                uint32
+	ServiceSpecificExitCode uint32
+
+	// config is only set when the service is read from the service control
+	// manager.
+	config *scmServiceConfig
 }
 
 var (
@@ -115,55 +234,218 @@ var (
 	}
 )
 
-func (c *serviceCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
-	var dst []Win32_Service
-	q := queryAllWhere(&dst, c.queryWhereClause)
-	if err := wmi.Query(q, &dst); err != nil {
+func (c *serviceCollector) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+	var (
+		services []serviceInfo
+		err      error
+	)
+	if c.useAPI {
+		services, err = c.queryManager()
+	} else {
+		services, err = c.queryWMI(ctx)
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	for _, service := range dst {
+	var processStartTimes map[uint32]float64
+	if c.compact {
+		if processStartTimes, err = serviceProcessStartTimes(ctx); err != nil {
+			log.Debugf("Could not read the start time of service processes: %v", err)
+		}
+	}
+
+	for _, service := range services {
+		// Filters match the lowercase name in both modes. Compact mode
+		// reports the name as registered.
+		name := strings.ToLower(service.Name)
+		labels := map[string]string{
+			"name":       name,
+			"state":      service.State,
+			"start_mode": service.StartMode,
+		}
+		if service.Status != "" {
+			labels["status"] = service.Status
+		}
+		if !c.serviceFilter.keep(ctx, name, labels) {
+			continue
+		}
+
+		if c.compact {
+			name = service.Name
+		}
+
+		if service.config != nil {
+			c.collectConfig(ch, name, service.config)
+		}
+
+		if c.compact {
+			c.collectCompact(ch, name, service, processStartTimes)
+			continue
+		}
+
 		for _, state := range allStates {
 			isCurrentState := 0.0
-			if state == strings.ToLower(service.State) {
+			if state == service.State {
 				isCurrentState = 1.0
 			}
 			ch <- prometheus.MustNewConstMetric(
 				c.State,
 				prometheus.GaugeValue,
 				isCurrentState,
-				strings.ToLower(service.Name),
+				name,
 				state,
 			)
 		}
 
 		for _, startMode := range allStartModes {
 			isCurrentStartMode := 0.0
-			if startMode == strings.ToLower(service.StartMode) {
+			if startMode == service.StartMode {
 				isCurrentStartMode = 1.0
 			}
 			ch <- prometheus.MustNewConstMetric(
 				c.StartMode,
 				prometheus.GaugeValue,
 				isCurrentStartMode,
-				strings.ToLower(service.Name),
+				name,
 				startMode,
 			)
 		}
 
+		// The service control manager does not know the status of services.
+		if service.Status == "" {
+			continue
+		}
 		for _, status := range allStatuses {
 			isCurrentStatus := 0.0
-			if status == strings.ToLower(service.Status) {
+			if status == service.Status {
 				isCurrentStatus = 1.0
 			}
 			ch <- prometheus.MustNewConstMetric(
 				c.Status,
 				prometheus.GaugeValue,
 				isCurrentStatus,
-				strings.ToLower(service.Name),
+				name,
 				status,
 			)
 		}
 	}
 	return nil, nil
 }
+
+// collectCompact sends the info series of the service, and the process and
+// exit code metrics only reported in compact mode.
+func (c *serviceCollector) collectCompact(ch chan<- prometheus.Metric, name string, service serviceInfo, processStartTimes map[uint32]float64) {
+	ch <- prometheus.MustNewConstMetric(
+		c.Info,
+		prometheus.GaugeValue,
+		1.0,
+		name,
+		service.DisplayName,
+		service.State,
+		service.StartMode,
+		service.RunAs,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.ProcessID,
+		prometheus.GaugeValue,
+		float64(service.ProcessID),
+		name,
+	)
+	if startTime, ok := processStartTimes[service.ProcessID]; ok && service.ProcessID != 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.ProcessStartTime,
+			prometheus.GaugeValue,
+			startTime,
+			name,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.ExitCode,
+		prometheus.GaugeValue,
+		float64(service.ExitCode),
+		name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.ServiceSpecificExitCode,
+		prometheus.GaugeValue,
+		float64(service.ServiceSpecificExitCode),
+		name,
+	)
+}
+
+func (c *serviceCollector) collectConfig(ch chan<- prometheus.Metric, name string, config *scmServiceConfig) {
+	ch <- prometheus.MustNewConstMetric(
+		c.DelayedAutoStart,
+		prometheus.GaugeValue,
+		boolToFloat(config.DelayedAutoStart),
+		name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.TriggerStart,
+		prometheus.GaugeValue,
+		boolToFloat(config.TriggerStart),
+		name,
+	)
+
+	if len(config.FailureActions) == 0 {
+		return
+	}
+	for i, action := range config.FailureActions {
+		ch <- prometheus.MustNewConstMetric(
+			c.FailureActionDelay,
+			prometheus.GaugeValue,
+			float64(action.Delay)/1000,
+			name,
+			strconv.Itoa(i+1),
+			scmFailureActionName(action.Type),
+		)
+	}
+	resetPeriod := float64(config.FailureResetPeriod)
+	if config.FailureResetPeriod == scmInfinite {
+		resetPeriod = math.Inf(1)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.FailureResetPeriod,
+		prometheus.GaugeValue,
+		resetPeriod,
+		name,
+	)
+}
+
+func (c *serviceCollector) queryWMI(ctx *ScrapeContext) ([]serviceInfo, error) {
+	var dst []Win32_Service
+	q := queryAllWhere(&dst, c.queryWhereClause)
+	if err := ctx.wmiQuery(q, &dst); err != nil {
+		return nil, err
+	}
+
+	services := make([]serviceInfo, 0, len(dst))
+	for _, service := range dst {
+		info := serviceInfo{
+			Name:                    service.Name,
+			DisplayName:             service.DisplayName,
+			State:                   strings.ToLower(service.State),
+			Status:                  strings.ToLower(service.Status),
+			StartMode:               strings.ToLower(service.StartMode),
+			ProcessID:               service.ProcessId,
+			ExitCode:                service.ExitCode,
+			ServiceSpecificExitCode: service.ServiceSpecificExitCode,
+		}
+		if service.StartName != nil {
+			info.RunAs = *service.StartName
+		}
+		services = append(services, info)
+	}
+	return services, nil
+}
+
+func (c *serviceCollector) queryManager() ([]serviceInfo, error) {
+	m, err := c.openManager()
+	if err != nil {
+		return nil, err
+	}
+	defer m.close()
+
+	return scmServices(m)
+}
@@ -0,0 +1,16 @@
+// +build !windows
+
+package collector
+
+import (
+	"errors"
+)
+
+func openServiceManager() (serviceManager, error) {
+	return nil, errors.New("the service control manager is only available on Windows")
+}
+
+// serviceProcessStartTimes is not supported without perflib.
+func serviceProcessStartTimes(ctx *ScrapeContext) (map[uint32]float64, error) {
+	return nil, nil
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"errors"
+
+	"github.com/prometheus/common/log"
+)
+
+// errServiceNotFound is returned by serviceManager.config for services
+// deleted since they were listed.
+var errServiceNotFound = errors.New("the service does not exist")
+
+// serviceManager gives access to the services of the service control
+// manager. It is implemented on top of the Windows API, and faked in tests.
+type serviceManager interface {
+	// services returns the status of every Win32 service.
+	services() ([]scmServiceStatus, error)
+	// config returns the configuration of the named service.
+	config(name string) (scmServiceConfig, error)
+	close() error
+}
+
+// scmServiceStatus mirrors ENUM_SERVICE_STATUS_PROCESS.
+type scmServiceStatus struct {
+	Name                    string
+	DisplayName             string
+	State                   uint32
+	ProcessID               uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+}
+
+// scmServiceConfig holds the parts of QUERY_SERVICE_CONFIG and of the
+// optional configuration read by QueryServiceConfig2 used by the collector.
+type scmServiceConfig struct {
+	StartType          uint32
+	ServiceStartName   string
+	DelayedAutoStart   bool
+	TriggerStart       bool
+	FailureActions     []scmFailureAction
+	FailureResetPeriod uint32 // seconds, or scmInfinite
+}
+
+// scmFailureAction mirrors SC_ACTION.
+type scmFailureAction struct {
+	Type  uint32
+	Delay uint32 // milliseconds
+}
+
+// Values of the Windows API, which are also needed on other platforms to
+// test the collector.
+const (
+	scmServiceStopped         = 1
+	scmServiceStartPending    = 2
+	scmServiceStopPending     = 3
+	scmServiceRunning         = 4
+	scmServiceContinuePending = 5
+	scmServicePausePending    = 6
+	scmServicePaused          = 7
+
+	scmServiceBootStart   = 0
+	scmServiceSystemStart = 1
+	scmServiceAutoStart   = 2
+	scmServiceDemandStart = 3
+	scmServiceDisabled    = 4
+
+	scmActionNone       = 0
+	scmActionRestart    = 1
+	scmActionReboot     = 2
+	scmActionRunCommand = 3
+
+	scmInfinite = 0xffffffff
+)
+
+// scmServiceState returns the state as named by Win32_Service.
+func scmServiceState(state uint32) string {
+	switch state {
+	case scmServiceStopped:
+		return "stopped"
+	case scmServiceStartPending:
+		return "start pending"
+	case scmServiceStopPending:
+		return "stop pending"
+	case scmServiceRunning:
+		return "running"
+	case scmServiceContinuePending:
+		return "continue pending"
+	case scmServicePausePending:
+		return "pause pending"
+	case scmServicePaused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
+
+// scmStartMode returns the start type as named by Win32_Service.
+func scmStartMode(startType uint32) string {
+	switch startType {
+	case scmServiceBootStart:
+		return "boot"
+	case scmServiceSystemStart:
+		return "system"
+	case scmServiceAutoStart:
+		return "auto"
+	case scmServiceDemandStart:
+		return "manual"
+	case scmServiceDisabled:
+		return "disabled"
+	default:
+		return "unknown"
+	}
+}
+
+func scmFailureActionName(action uint32) string {
+	switch action {
+	case scmActionNone:
+		return "none"
+	case scmActionRestart:
+		return "restart"
+	case scmActionReboot:
+		return "reboot"
+	case scmActionRunCommand:
+		return "run_command"
+	default:
+		return "unknown"
+	}
+}
+
+// scmServices reads the status and configuration of all services. Services
+// deleted in the meantime are skipped. Services whose configuration cannot be
+// read otherwise, for example because access is denied, are reported with an
+// unknown start mode and without configuration.
+func scmServices(m serviceManager) ([]serviceInfo, error) {
+	statuses, err := m.services()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]serviceInfo, 0, len(statuses))
+	for _, status := range statuses {
+		service := serviceInfo{
+			Name:                    status.Name,
+			DisplayName:             status.DisplayName,
+			State:                   scmServiceState(status.State),
+			StartMode:               "unknown",
+			ProcessID:               status.ProcessID,
+			ExitCode:                status.Win32ExitCode,
+			ServiceSpecificExitCode: status.ServiceSpecificExitCode,
+		}
+
+		config, err := m.config(status.Name)
+		switch {
+		case err == errServiceNotFound:
+			continue
+		case err != nil:
+			log.Debugf("Could not read the configuration of service %s: %v", status.Name, err)
+		default:
+			service.StartMode = scmStartMode(config.StartType)
+			service.RunAs = config.ServiceStartName
+			service.config = &config
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
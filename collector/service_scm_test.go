@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeServiceManager struct {
+	statuses   []scmServiceStatus
+	configs    map[string]scmServiceConfig
+	configErrs map[string]error
+	closed     bool
+}
+
+func (m *fakeServiceManager) services() ([]scmServiceStatus, error) {
+	return m.statuses, nil
+}
+
+func (m *fakeServiceManager) config(name string) (scmServiceConfig, error) {
+	if err := m.configErrs[name]; err != nil {
+		return scmServiceConfig{}, err
+	}
+	config, ok := m.configs[name]
+	if !ok {
+		return scmServiceConfig{}, errServiceNotFound
+	}
+	return config, nil
+}
+
+func (m *fakeServiceManager) close() error {
+	m.closed = true
+	return nil
+}
+
+func newFakeServiceManager() *fakeServiceManager {
+	return &fakeServiceManager{
+		statuses: []scmServiceStatus{
+			{Name: "Schedule", DisplayName: "Task Scheduler", State: scmServiceRunning, ProcessID: 1200},
+			{Name: "Spooler", DisplayName: "Print Spooler", State: scmServiceStopped, Win32ExitCode: 1066, ServiceSpecificExitCode: 5},
+			{Name: "wuauserv", DisplayName: "Windows Update", State: scmServiceStartPending, ProcessID: 1300},
+			{Name: "Deleted", DisplayName: "Deleted in the meantime", State: scmServiceStopped},
+			{Name: "Restricted", DisplayName: "Restricted", State: scmServiceRunning, ProcessID: 1400},
+		},
+		configErrs: map[string]error{
+			"Restricted": errors.New("Access is denied."),
+		},
+		configs: map[string]scmServiceConfig{
+			"Schedule": {
+				StartType:        scmServiceAutoStart,
+				ServiceStartName: "LocalSystem",
+				FailureActions: []scmFailureAction{
+					{Type: scmActionRestart, Delay: 60000},
+					{Type: scmActionRestart, Delay: 120000},
+					{Type: scmActionNone},
+				},
+				FailureResetPeriod: 86400,
+			},
+			"Spooler": {
+				StartType:          scmServiceDemandStart,
+				ServiceStartName:   "LocalSystem",
+				FailureActions:     []scmFailureAction{{Type: scmActionReboot, Delay: 500}},
+				FailureResetPeriod: scmInfinite,
+			},
+			"wuauserv": {
+				StartType:        scmServiceAutoStart,
+				ServiceStartName: `NT AUTHORITY\LocalService`,
+				DelayedAutoStart: true,
+				TriggerStart:     true,
+			},
+		},
+	}
+}
+
+func newSCMServiceCollector(t *testing.T, m serviceManager) Collector {
+	*serviceUseAPI = true
+	defer func() { *serviceUseAPI = false }()
+
+	c, err := NewserviceCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(*serviceCollector).openManager = func() (serviceManager, error) {
+		return m, nil
+	}
+	return c
+}
+
+func TestServiceCollectorSCM(t *testing.T) {
+	m := newFakeServiceManager()
+	out := gatherCollector(t, newSCMServiceCollector(t, m), newScrapeContext(nil, nil))
+
+	for _, line := range []string{
+		`wmi_service_state{name="schedule",state="running"} 1`,
+		`wmi_service_state{name="wuauserv",state="start pending"} 1`,
+		`wmi_service_start_mode{name="spooler",start_mode="manual"} 1`,
+		`wmi_service_start_mode{name="wuauserv",start_mode="auto"} 1`,
+		`wmi_service_delayed_auto_start{name="schedule"} 0`,
+		`wmi_service_delayed_auto_start{name="wuauserv"} 1`,
+		`wmi_service_trigger_start{name="wuauserv"} 1`,
+		`wmi_service_failure_action_delay_seconds{action="restart",failure="1",name="schedule"} 60`,
+		`wmi_service_failure_action_delay_seconds{action="restart",failure="2",name="schedule"} 120`,
+		`wmi_service_failure_action_delay_seconds{action="none",failure="3",name="schedule"} 0`,
+		`wmi_service_failure_action_delay_seconds{action="reboot",failure="1",name="spooler"} 0.5`,
+		`wmi_service_failure_reset_period_seconds{name="schedule"} 86400`,
+		`wmi_service_failure_reset_period_seconds{name="spooler"} +Inf`,
+		`wmi_service_state{name="restricted",state="running"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	for _, unexpected := range []string{
+		`name="deleted"`,
+		`wmi_service_status{`,
+		`wmi_service_process_id{`,
+		`wmi_service_failure_reset_period_seconds{name="wuauserv"}`,
+		`wmi_service_start_mode{name="restricted",start_mode="auto"} 1`,
+		`wmi_service_delayed_auto_start{name="restricted"}`,
+	} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("Unexpected %q in output:\n%s", unexpected, out)
+		}
+	}
+	if !m.closed {
+		t.Error("Expected the service manager to be closed")
+	}
+}
+
+func TestServiceCollectorSCMCompact(t *testing.T) {
+	*serviceCompact = true
+	defer func() { *serviceCompact = false }()
+
+	out := gatherCollector(t, newSCMServiceCollector(t, newFakeServiceManager()), newScrapeContext(nil, nil))
+	for _, line := range []string{
+		`wmi_service_info{display_name="Task Scheduler",name="Schedule",run_as="LocalSystem",start_mode="auto",state="running"} 1`,
+		`wmi_service_info{display_name="Restricted",name="Restricted",run_as="",start_mode="unknown",state="running"} 1`,
+		`wmi_service_process_id{name="Restricted"} 1400`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestServiceCollectorSCMFilters(t *testing.T) {
+	cases := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{"include", []string{"sched.*"}, nil, []string{"schedule"}},
+		{"exclude", nil, []string{"schedule"}, []string{"spooler", "wuauserv"}},
+		{"include by state", []string{"state=~running|start pending"}, nil, []string{"schedule", "wuauserv"}},
+		{"exclude by start mode", nil, []string{"start_mode=~manual"}, []string{"schedule", "wuauserv"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newSCMServiceCollector(t, newFakeServiceManager())
+			sc := c.(*serviceCollector)
+			var err error
+			if sc.serviceFilter, err = newInstanceFilter("service", "service", tc.include, tc.exclude); err != nil {
+				t.Fatal(err)
+			}
+			out := gatherCollector(t, c, newScrapeContext(nil, nil))
+
+			var got []string
+			for _, name := range []string{"schedule", "spooler", "wuauserv"} {
+				if strings.Contains(out, `wmi_service_state{name="`+name+`",state="running"}`) {
+					got = append(got, name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("Expected services %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestServiceCollectorSCMWhereClause(t *testing.T) {
+	*serviceUseAPI = true
+	*serviceWhereClause = "Name='Schedule'"
+	defer func() {
+		*serviceUseAPI = false
+		*serviceWhereClause = ""
+	}()
+
+	if _, err := NewserviceCollector(); err == nil {
+		t.Error("Expected an error when combining services-where with the service control manager API")
+	}
+}
@@ -0,0 +1,101 @@
+// +build windows
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func serviceWMI(services ...Win32_Service) wmiQueryFunc {
+	return func(query string, dst interface{}, namespace string) error {
+		d, ok := dst.(*[]Win32_Service)
+		if !ok {
+			return errors.New("unexpected query " + query)
+		}
+		*d = append(*d, services...)
+		return nil
+	}
+}
+
+func serviceFixtureWMI() wmiQueryFunc {
+	account := "LocalSystem"
+	return serviceWMI(
+		Win32_Service{Name: "Schedule", DisplayName: "Task Scheduler", State: "Running", Status: "OK", StartMode: "Auto", StartName: &account, ProcessId: 1200},
+		Win32_Service{Name: "Spooler", DisplayName: "Print Spooler", State: "Stopped", Status: "OK", StartMode: "Manual", StartName: &account, ExitCode: 1066, ServiceSpecificExitCode: 5},
+		Win32_Service{Name: "Beep", DisplayName: "Beep", State: "Running", Status: "OK", StartMode: "System"},
+	)
+}
+
+func TestServiceCollector(t *testing.T) {
+	c, err := NewserviceCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := gatherCollector(t, c, newScrapeContext(nil, serviceFixtureWMI()))
+	for _, line := range []string{
+		`wmi_service_state{name="schedule",state="running"} 1`,
+		`wmi_service_state{name="spooler",state="running"} 0`,
+		`wmi_service_start_mode{name="spooler",start_mode="manual"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	for _, unexpected := range []string{
+		`wmi_service_info{`,
+		`wmi_service_process_id{`,
+		`wmi_service_process_start_time{`,
+		`wmi_service_exit_code{`,
+		`wmi_service_specific_exit_code{`,
+	} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("Unexpected %q in output:\n%s", unexpected, out)
+		}
+	}
+}
+
+func TestServiceCollectorCompact(t *testing.T) {
+	*serviceCompact = true
+	defer func() { *serviceCompact = false }()
+
+	c, err := NewserviceCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newScrapeContext(perflibObjects{
+		"Process": newPerflibFixture("Process", 1e7,
+			processFixture("svchost", 1200, 4, 1, 1, 1500000000, 1<<20),
+		),
+	}, serviceFixtureWMI())
+	out := gatherCollector(t, c, ctx)
+
+	for _, line := range []string{
+		`wmi_service_info{display_name="Task Scheduler",name="Schedule",run_as="LocalSystem",start_mode="auto",state="running"} 1`,
+		`wmi_service_info{display_name="Print Spooler",name="Spooler",run_as="LocalSystem",start_mode="manual",state="stopped"} 1`,
+		`wmi_service_info{display_name="Beep",name="Beep",run_as="",start_mode="system",state="running"} 1`,
+		`wmi_service_process_id{name="Schedule"} 1200`,
+		`wmi_service_process_id{name="Spooler"} 0`,
+		`wmi_service_process_start_time{name="Schedule"} 1.5e+09`,
+		`wmi_service_exit_code{name="Spooler"} 1066`,
+		`wmi_service_specific_exit_code{name="Spooler"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	for _, unexpected := range []string{
+		`wmi_service_process_start_time{name="Spooler"}`,
+		`wmi_service_process_start_time{name="Beep"}`,
+		`wmi_service_state{`,
+		`wmi_service_start_mode{`,
+		`wmi_service_status{`,
+	} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("Unexpected %q in output:\n%s", unexpected, out)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// +build windows
+
+package collector
+
+import (
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Configuration levels of QueryServiceConfig2 not defined by x/sys/windows.
+const (
+	serviceConfigDelayedAutoStartInfo = 3
+	serviceConfigTriggerInfo          = 8
+)
+
+// serviceDelayedAutoStartInfo mirrors SERVICE_DELAYED_AUTO_START_INFO.
+type serviceDelayedAutoStartInfo struct {
+	DelayedAutostart uint32
+}
+
+// serviceTriggerInfo mirrors SERVICE_TRIGGER_INFO.
+type serviceTriggerInfo struct {
+	Triggers  uint32
+	PTriggers uintptr
+	PReserved uintptr
+}
+
+// windowsServiceManager is a serviceManager connected to the local service
+// control manager with the least privileges needed.
+type windowsServiceManager struct {
+	handle windows.Handle
+}
+
+func openServiceManager() (serviceManager, error) {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT|windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	return &windowsServiceManager{handle: h}, nil
+}
+
+func (m *windowsServiceManager) close() error {
+	return windows.CloseServiceHandle(m.handle)
+}
+
+func (m *windowsServiceManager) services() ([]scmServiceStatus, error) {
+	var (
+		buf              []byte
+		bytesNeeded      uint32
+		servicesReturned uint32
+	)
+	for {
+		var p *byte
+		if len(buf) > 0 {
+			p = &buf[0]
+		}
+		err := windows.EnumServicesStatusEx(m.handle, windows.SC_ENUM_PROCESS_INFO,
+			windows.SERVICE_WIN32, windows.SERVICE_STATE_ALL,
+			p, uint32(len(buf)), &bytesNeeded, &servicesReturned, nil, nil)
+		if err == nil {
+			break
+		}
+		if err != syscall.ERROR_MORE_DATA || bytesNeeded <= uint32(len(buf)) {
+			return nil, err
+		}
+		buf = make([]byte, bytesNeeded)
+	}
+	if servicesReturned == 0 {
+		return nil, nil
+	}
+
+	entries := (*[1 << 20]windows.ENUM_SERVICE_STATUS_PROCESS)(unsafe.Pointer(&buf[0]))[:servicesReturned:servicesReturned]
+	statuses := make([]scmServiceStatus, 0, len(entries))
+	for _, e := range entries {
+		statuses = append(statuses, scmServiceStatus{
+			Name:                    utf16PtrToString(e.ServiceName),
+			DisplayName:             utf16PtrToString(e.DisplayName),
+			State:                   e.ServiceStatusProcess.CurrentState,
+			ProcessID:               e.ServiceStatusProcess.ProcessId,
+			Win32ExitCode:           e.ServiceStatusProcess.Win32ExitCode,
+			ServiceSpecificExitCode: e.ServiceStatusProcess.ServiceSpecificExitCode,
+		})
+	}
+	return statuses, nil
+}
+
+func (m *windowsServiceManager) config(name string) (scmServiceConfig, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return scmServiceConfig{}, err
+	}
+	h, err := windows.OpenService(m.handle, namePtr, windows.SERVICE_QUERY_CONFIG)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		return scmServiceConfig{}, errServiceNotFound
+	} else if err != nil {
+		return scmServiceConfig{}, err
+	}
+	defer windows.CloseServiceHandle(h)
+
+	b, err := queryServiceBuffer(func(p *byte, n uint32, needed *uint32) error {
+		return windows.QueryServiceConfig(h, (*windows.QUERY_SERVICE_CONFIG)(unsafe.Pointer(p)), n, needed)
+	})
+	if err != nil {
+		return scmServiceConfig{}, err
+	}
+	qsc := (*windows.QUERY_SERVICE_CONFIG)(unsafe.Pointer(&b[0]))
+	config := scmServiceConfig{
+		StartType:        qsc.StartType,
+		ServiceStartName: utf16PtrToString(qsc.ServiceStartName),
+	}
+
+	if b, err := queryServiceConfig2(h, serviceConfigDelayedAutoStartInfo); err == nil {
+		config.DelayedAutoStart = (*serviceDelayedAutoStartInfo)(unsafe.Pointer(&b[0])).DelayedAutostart != 0
+	}
+	// Trigger-start services do not exist before Windows 7 / Server 2008 R2,
+	// where the query fails with ERROR_INVALID_LEVEL.
+	if b, err := queryServiceConfig2(h, serviceConfigTriggerInfo); err == nil {
+		config.TriggerStart = (*serviceTriggerInfo)(unsafe.Pointer(&b[0])).Triggers > 0
+	}
+
+	b, err = queryServiceConfig2(h, windows.SERVICE_CONFIG_FAILURE_ACTIONS)
+	if err != nil {
+		return scmServiceConfig{}, err
+	}
+	sfa := (*windows.SERVICE_FAILURE_ACTIONS)(unsafe.Pointer(&b[0]))
+	if sfa.Actions != nil && sfa.ActionsCount > 0 {
+		config.FailureResetPeriod = sfa.ResetPeriod
+		actions := (*[1024]windows.SC_ACTION)(unsafe.Pointer(sfa.Actions))[:sfa.ActionsCount:sfa.ActionsCount]
+		for _, a := range actions {
+			config.FailureActions = append(config.FailureActions, scmFailureAction{Type: a.Type, Delay: a.Delay})
+		}
+	}
+
+	return config, nil
+}
+
+func queryServiceConfig2(h windows.Handle, infoLevel uint32) ([]byte, error) {
+	return queryServiceBuffer(func(p *byte, n uint32, needed *uint32) error {
+		return windows.QueryServiceConfig2(h, infoLevel, p, n, needed)
+	})
+}
+
+// queryServiceBuffer calls query with a buffer large enough for the result.
+func queryServiceBuffer(query func(p *byte, n uint32, needed *uint32) error) ([]byte, error) {
+	n := uint32(1024)
+	for {
+		b := make([]byte, n)
+		err := query(&b[0], n, &n)
+		if err == nil {
+			return b, nil
+		}
+		if err != syscall.ERROR_INSUFFICIENT_BUFFER || n <= uint32(len(b)) {
+			return nil, err
+		}
+	}
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	s := (*[1 << 29]uint16)(unsafe.Pointer(p))
+	n := 0
+	for s[n] != 0 {
+		n++
+	}
+	return string(utf16.Decode(s[:n:n]))
+}
+
+// serviceProcessStartTimes maps process IDs to the start time of the
+// process, as read from the perflib Process object.
+func serviceProcessStartTimes(ctx *ScrapeContext) (map[uint32]float64, error) {
+	var dst []perflibProcess
+	if err := unmarshalObject(ctx.perfObjects["Process"], &dst); err != nil {
+		return nil, err
+	}
+
+	startTimes := make(map[uint32]float64, len(dst))
+	for _, process := range dst {
+		if process.Name == "_Total" {
+			continue
+		}
+		startTimes[uint32(process.IDProcess)] = process.ElapsedTime
+	}
+	return startTimes, nil
+}
@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// subCollectorFunc collects the metrics of a single class of a multi-class
+// collector.
+type subCollectorFunc func(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error)
+
+// subCollectors runs the enabled classes of a multi-class collector (mssql,
+// hyperv, iis, ...) in parallel, reporting the duration and outcome of each
+// class separately. A failing class does not prevent the others from
+// reporting their metrics.
+type subCollectors struct {
+	subsystem    string
+	enabled      []string
+	durationDesc *prometheus.Desc
+	successDesc  *prometheus.Desc
+}
+
+// newSubCollectors validates the comma-separated list of enabled classes
+// against the available ones. extraLabels are appended to the "collector"
+// label of the per-class duration and success metrics; their values are
+// passed to collect.
+func newSubCollectors(subsystem string, available string, enabled string, extraLabels ...string) (*subCollectors, error) {
+	known := make(map[string]bool)
+	for _, name := range expandEnabledClasses(available) {
+		known[name] = true
+	}
+
+	classes := expandEnabledClasses(enabled)
+	for _, name := range classes {
+		if !known[name] {
+			return nil, fmt.Errorf("%s class collector %q not available, supported values are %s", subsystem, name, available)
+		}
+	}
+
+	labels := append([]string{"collector"}, extraLabels...)
+	return &subCollectors{
+		subsystem: subsystem,
+		enabled:   classes,
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "collector_duration_seconds"),
+			fmt.Sprintf("wmi_exporter: Duration of a %s child collection.", subsystem),
+			labels,
+			nil,
+		),
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, subsystem, "collector_success"),
+			fmt.Sprintf("wmi_exporter: Whether a %s child collector was successful.", subsystem),
+			labels,
+			nil,
+		),
+	}, nil
+}
+
+// collect runs every enabled class found in fns and waits for all of them to
+// finish. An error is returned if at least one class failed.
+func (s *subCollectors) collect(ctx *ScrapeContext, ch chan<- prometheus.Metric, fns map[string]subCollectorFunc, labelValues ...string) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+	)
+
+	for _, name := range s.enabled {
+		fn, ok := fns[name]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, fn subCollectorFunc) {
+			defer wg.Done()
+			if !s.execute(ctx, name, fn, ch, labelValues) {
+				mu.Lock()
+				failures = append(failures, name)
+				mu.Unlock()
+			}
+		}(name, fn)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%s class collectors failed: %s", s.subsystem, strings.Join(failures, ","))
+	}
+	return nil
+}
+
+func (s *subCollectors) execute(ctx *ScrapeContext, name string, fn subCollectorFunc, ch chan<- prometheus.Metric, labelValues []string) bool {
+	begin := time.Now()
+	_, err := fn(ctx, ch)
+	duration := time.Since(begin)
+
+	var success float64
+	if err != nil {
+		log.Errorf("%s class collector %s failed after %fs: %s", s.subsystem, name, duration.Seconds(), err)
+	} else {
+		log.Debugf("%s class collector %s succeeded after %fs.", s.subsystem, name, duration.Seconds())
+		success = 1
+	}
+
+	labels := append([]string{name}, labelValues...)
+	ch <- prometheus.MustNewConstMetric(
+		s.durationDesc,
+		prometheus.GaugeValue,
+		duration.Seconds(),
+		labels...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.successDesc,
+		prometheus.GaugeValue,
+		success,
+		labels...,
+	)
+	return err == nil
+}
+
+// expandEnabledClasses splits a comma-separated list of class names,
+// dropping empty entries and duplicates.
+func expandEnabledClasses(enabled string) []string {
+	separated := strings.Split(enabled, ",")
+	unique := map[string]bool{}
+	result := make([]string, 0, len(separated))
+	for _, s := range separated {
+		s = strings.TrimSpace(s)
+		if s != "" && !unique[s] {
+			unique[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
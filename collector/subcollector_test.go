@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestExpandEnabledClasses(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"a,b", []string{"a", "b"}},
+		{"a,,b,a", []string{"a", "b"}},
+		{" a , b ", []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		if got := expandEnabledClasses(c.input); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("For %q expected %v, got %v", c.input, c.expected, got)
+		}
+	}
+}
+
+func TestNewSubCollectorsUnknownClass(t *testing.T) {
+	if _, err := newSubCollectors("test", "a,b", "a,c"); err == nil {
+		t.Error("Expected an error for unknown class, but got ok")
+	}
+}
+
+func TestSubCollectorsPartialResults(t *testing.T) {
+	okDesc := prometheus.NewDesc("test_ok", "", nil, nil)
+	fns := map[string]subCollectorFunc{
+		"ok": func(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+			ch <- prometheus.MustNewConstMetric(okDesc, prometheus.GaugeValue, 1)
+			return nil, nil
+		},
+		"missing": func(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+			return nil, errors.New("class not found")
+		},
+		"disabled": func(ctx *ScrapeContext, ch chan<- prometheus.Metric) (*prometheus.Desc, error) {
+			t.Error("Disabled class collector was called")
+			return nil, nil
+		},
+	}
+
+	s, err := newSubCollectors("test", "ok,missing,disabled", "ok,missing", "instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run twice to make sure failures do not accumulate across scrapes.
+	for i := 0; i < 2; i++ {
+		ch := make(chan prometheus.Metric, 10)
+		err = s.collect(&ScrapeContext{}, ch, fns, "inst")
+		close(ch)
+		if err == nil {
+			t.Error("Expected an error for failing class, but got ok")
+		}
+
+		var okSeen bool
+		success := map[string]float64{}
+		for m := range ch {
+			if m.Desc() == okDesc {
+				okSeen = true
+				continue
+			}
+			if m.Desc() != s.successDesc {
+				continue
+			}
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			labels := map[string]string{}
+			for _, l := range pb.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["instance"] != "inst" {
+				t.Errorf("Unexpected instance label %q", labels["instance"])
+			}
+			success[labels["collector"]] = pb.GetGauge().GetValue()
+		}
+
+		if !okSeen {
+			t.Error("Metrics of succeeding class collector were not reported")
+		}
+		expected := map[string]float64{"ok": 1, "missing": 0}
+		if !reflect.DeepEqual(success, expected) {
+			t.Errorf("Expected success %v, got %v", expected, success)
+		}
+	}
+}
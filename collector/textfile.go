@@ -18,14 +18,13 @@ package collector
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/dimchansky/utfbom"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
@@ -36,21 +35,153 @@ import (
 var (
 	textFileDirectory = kingpin.Flag(
 		"collector.textfile.directory",
-		"Directory to read text files with metrics from.",
+		"Comma-separated list of directories, or glob patterns matching directories or files, to read text files with metrics from. Entries containing commas must be enclosed in double quotes.",
 	).Default("C:\\Program Files\\wmi_exporter\\textfile_inputs").String()
+	textFileRecursive = kingpin.Flag(
+		"collector.textfile.recursive",
+		"Also read text files in subdirectories of the textfile directories.",
+	).Bool()
+	textFileDefaultMaxAge = kingpin.Flag(
+		"collector.textfile.max-age",
+		"Text files not modified for longer than this are stale. 0 disables staleness detection.",
+	).Default("0s").Duration()
+	textFileMaxAgeOverrides = kingpin.Flag(
+		"collector.textfile.max-age-override",
+		"Max age of the text files matching a glob pattern, or in a directory matching it, given as pattern=duration. May be repeated; the first matching pattern applies.",
+	).Strings()
+	textFileStaleAction = kingpin.Flag(
+		"collector.textfile.stale-action",
+		"What to do with the metrics of stale text files: drop them, or keep exporting them.",
+	).Default("drop").Enum("drop", "keep")
+	textFileServeLastGood = kingpin.Flag(
+		"collector.textfile.serve-last-good",
+		"Export the metrics of the last successful read of a text file if the current version cannot be read.",
+	).Bool()
+	textFileConflictPolicy = kingpin.Flag(
+		"collector.textfile.conflict-policy",
+		"How to resolve metrics defined with a different type, or the same labels, in several text files: first-wins skips the conflicting metrics of later files, reject skips later files entirely.",
+	).Default("first-wins").Enum("first-wins", "reject")
+	textFileTimestamps = kingpin.Flag(
+		"collector.textfile.timestamps",
+		"Export the client-side timestamps of samples in text files, instead of skipping files containing them.",
+	).Bool()
+	textFileTimestampMaxAge = kingpin.Flag(
+		"collector.textfile.timestamp-max-age",
+		"Samples with a client-side timestamp older than this are dropped. 0 disables the check.",
+	).Default("1h").Duration()
+	textFilePathLabelRegex = kingpin.Flag(
+		"collector.textfile.path-label-regex",
+		"Regular expression matching the slash-separated path of text files relative to their textfile directory, whose named capture groups are added as labels to the metrics of the file.",
+	).String()
+	textFileAddSourceFile = kingpin.Flag(
+		"collector.textfile.source-file-label",
+		"Add the path of the text file as source_file label to its metrics.",
+	).Bool()
+	textFileLabelClashPolicy = kingpin.Flag(
+		"collector.textfile.label-clash-policy",
+		"What to do if a label derived from the path of a text file is already set in it: keep the value of the file, overwrite it, or reject the file.",
+	).Default(textFileLabelClashKeep).Enum(textFileLabelClashKeep, textFileLabelClashOverwrite, textFileLabelClashReject)
+	textFileWatch = kingpin.Flag(
+		"collector.textfile.watch",
+		"Load text files in the background when they change, instead of on every scrape: off, notify to watch the directories for changes, or poll to rescan them every poll interval.",
+	).Default("off").Enum("off", "notify", "poll")
+	textFilePollInterval = kingpin.Flag(
+		"collector.textfile.poll-interval",
+		"Interval at which the textfile directories are rescanned if text files are loaded in the background.",
+	).Default("15s").Duration()
+	textFileSettleDelay = kingpin.Flag(
+		"collector.textfile.settle-delay",
+		"Time to wait after the last change in a watched textfile directory before loading its files.",
+	).Default("250ms").Duration()
 
 	mtimeDesc = prometheus.NewDesc(
 		"wmi_textfile_mtime_seconds",
 		"Unixtime mtime of textfiles successfully read.",
-		[]string{"file"},
+		[]string{"directory", "file"},
+		nil,
+	)
+	staleDesc = prometheus.NewDesc(
+		"wmi_textfile_stale",
+		"1 if the textfile is older than its max age, 0 otherwise.",
+		[]string{"directory", "file"},
+		nil,
+	)
+	expiredFilesDesc = prometheus.NewDesc(
+		"wmi_textfile_expired_files",
+		"Number of stale textfiles whose metrics were dropped.",
+		nil,
+		nil,
+	)
+	fileErrorDesc = prometheus.NewDesc(
+		"wmi_textfile_file_error",
+		"1 if the textfile could not be read, with the reason in the reason label. Not exported for files read successfully.",
+		[]string{"directory", "file", "reason"},
 		nil,
 	)
 )
 
+// Reasons a text file could not be read, reported in the reason label of
+// wmi_textfile_file_error.
+const (
+	textFileErrorOpen            = "open"
+	textFileErrorEncoding        = "encoding"
+	textFileErrorParse           = "parse"
+	textFileErrorTimestamp       = "timestamp"
+	textFileErrorConflictingType = "conflicting_type"
+	textFileErrorDuplicateSeries = "duplicate_series"
+	textFileErrorLabelClash      = "label_clash"
+)
+
+// textFileError is an error reading a text file.
+type textFileError struct {
+	reason string
+	err    error
+}
+
+func (e *textFileError) Error() string {
+	return e.err.Error()
+}
+
 type textFileCollector struct {
-	path string
+	directories     []string
+	recursive       bool
+	maxAges         textFileMaxAges
+	dropStale       bool
+	serveLastGood   bool
+	rejectConflicts bool
+	allowTimestamps bool
+	timestampMaxAge time.Duration
+	pathLabels      *textFilePathLabels
 	// Only set for testing to get predictable output.
 	mtime *float64
+	now   func() time.Time
+
+	// watcher loads the files in the background if set.
+	watcher *textFileWatcher
+
+	// cache holds the result of the last read of each file, by path.
+	cacheMu sync.Mutex
+	cache   map[string]*textFileCacheEntry
+}
+
+// textFileContent is the parsed content of a text file.
+type textFileContent struct {
+	families []*dto.MetricFamily
+	modTime  time.Time
+}
+
+// textFileCacheEntry is the result of reading the version of a file with the
+// given modification time and size, along with the last successful read of
+// the file. Entries are not modified once created.
+type textFileCacheEntry struct {
+	modTime  time.Time
+	size     int64
+	err      *textFileError
+	lastGood *textFileContent
+}
+
+func (e *textFileCacheEntry) matches(f textFile) bool {
+	return e.modTime.Equal(f.modTime) && e.size == f.size
 }
 
 func init() {
@@ -60,9 +191,41 @@ func init() {
 // NewTextFileCollector returns a new Collector exposing metrics read from files
 // in the given textfile directory.
 func NewTextFileCollector() (Collector, error) {
-	return &textFileCollector{
-		path: *textFileDirectory,
-	}, nil
+	maxAges, err := parseTextFileMaxAges(*textFileDefaultMaxAge, *textFileMaxAgeOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	pathLabels, err := newTextFilePathLabels(*textFilePathLabelRegex, *textFileAddSourceFile, *textFileLabelClashPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &textFileCollector{
+		directories:     splitTextFilePaths(*textFileDirectory),
+		recursive:       *textFileRecursive,
+		maxAges:         maxAges,
+		dropStale:       *textFileStaleAction == "drop",
+		serveLastGood:   *textFileServeLastGood,
+		rejectConflicts: *textFileConflictPolicy == "reject",
+		allowTimestamps: *textFileTimestamps,
+		timestampMaxAge: *textFileTimestampMaxAge,
+		pathLabels:      pathLabels,
+		now:             time.Now,
+	}
+
+	if *textFileWatch != "off" {
+		if *textFilePollInterval <= 0 {
+			return nil, fmt.Errorf("--collector.textfile.poll-interval must be positive")
+		}
+		var watchDir watchDirFunc
+		if *textFileWatch == "notify" {
+			watchDir = watchTextFileDirectory
+		}
+		c.watcher = newTextFileWatcher(c, watchDir, *textFilePollInterval, *textFileSettleDelay)
+		c.watcher.start()
+	}
+	return c, nil
 }
 
 func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Metric) {
@@ -80,10 +243,6 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 	}
 
 	for _, metric := range metricFamily.Metric {
-		if metric.TimestampMs != nil {
-			log.Warnf("Ignoring unsupported custom timestamp on textfile collector metric %v", metric)
-		}
-
 		labels := metric.GetLabel()
 		var names []string
 		var values []string
@@ -106,6 +265,7 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 			}
 		}
 
+		var m prometheus.Metric
 		metricType := metricFamily.GetType()
 		switch metricType {
 		case dto.MetricType_COUNTER:
@@ -125,7 +285,7 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 			for _, q := range metric.Summary.Quantile {
 				quantiles[q.GetQuantile()] = q.GetValue()
 			}
-			ch <- prometheus.MustNewConstSummary(
+			m = prometheus.MustNewConstSummary(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
@@ -140,7 +300,7 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 			for _, b := range metric.Histogram.Bucket {
 				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
 			}
-			ch <- prometheus.MustNewConstHistogram(
+			m = prometheus.MustNewConstHistogram(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
@@ -155,7 +315,7 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 			continue
 		}
 		if metricType == dto.MetricType_GAUGE || metricType == dto.MetricType_COUNTER || metricType == dto.MetricType_UNTYPED {
-			ch <- prometheus.MustNewConstMetric(
+			m = prometheus.MustNewConstMetric(
 				prometheus.NewDesc(
 					*metricFamily.Name,
 					metricFamily.GetHelp(),
@@ -164,119 +324,537 @@ func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Me
 				valType, val, values...,
 			)
 		}
+		if metric.TimestampMs != nil {
+			m = prometheus.NewMetricWithTimestamp(time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond)), m)
+		}
+		ch <- m
 	}
 }
 
-func (c *textFileCollector) exportMTimes(mtimes map[string]time.Time, ch chan<- prometheus.Metric) {
+func (c *textFileCollector) exportMTimes(files []textFile, ch chan<- prometheus.Metric) {
 	// Export the mtimes of the successful files.
-	if len(mtimes) > 0 {
-		// Sorting is needed for predictable output comparison in tests.
-		filenames := make([]string, 0, len(mtimes))
-		for filename := range mtimes {
-			filenames = append(filenames, filename)
-		}
-		sort.Strings(filenames)
-
-		for _, filename := range filenames {
-			mtime := float64(mtimes[filename].UnixNano() / 1e9)
-			if c.mtime != nil {
-				mtime = *c.mtime
+	for _, f := range files {
+		mtime := float64(f.modTime.UnixNano() / 1e9)
+		if c.mtime != nil {
+			mtime = *c.mtime
+		}
+		ch <- prometheus.MustNewConstMetric(mtimeDesc, prometheus.GaugeValue, mtime, f.directory, f.name)
+	}
+}
+
+// textFile is a metrics file found in one of the textfile directories.
+type textFile struct {
+	// directory is the textfile directory the file was found in.
+	directory string
+	// name is the slash-separated path of the file relative to directory.
+	name    string
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func isTextFile(name string) bool {
+	return strings.HasSuffix(name, ".prom") || strings.HasSuffix(name, ".json")
+}
+
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// splitTextFilePaths splits a comma-separated list of paths. An entry may be
+// enclosed in double quotes to contain commas, surrounding white space is
+// removed, and empty or repeated entries are dropped.
+func splitTextFilePaths(list string) []string {
+	var (
+		paths   []string
+		seen    = map[string]bool{}
+		current strings.Builder
+		quoted  bool
+	)
+	add := func() {
+		path := strings.TrimSpace(current.String())
+		current.Reset()
+		if path == "" {
+			return
+		}
+		if strings.HasPrefix(path, `"`) && strings.HasSuffix(path, `"`) && len(path) > 1 {
+			path = path[1 : len(path)-1]
+		}
+		if path != "" && !seen[filepath.Clean(path)] {
+			seen[filepath.Clean(path)] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, r := range list {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == ',' && !quoted:
+			add()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	add()
+	return paths
+}
+
+// findTextFiles returns the text files in the given directories, or matched
+// by the given glob patterns, sorted by directory (in the order given) and
+// name. A file reachable through several directories is only returned once.
+func findTextFiles(directories []string, recursive bool) ([]textFile, error) {
+	var (
+		files   []textFile
+		seen    = map[string]bool{}
+		lastErr error
+	)
+	add := func(directory, path string, info os.FileInfo) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if seen[abs] {
+			return
+		}
+		seen[abs] = true
+
+		name, err := filepath.Rel(directory, path)
+		if err != nil {
+			name = info.Name()
+		}
+		files = append(files, textFile{
+			directory: directory,
+			name:      filepath.ToSlash(name),
+			path:      path,
+			modTime:   info.ModTime(),
+			size:      info.Size(),
+		})
+	}
+
+	for _, pattern := range directories {
+		matches := []string{pattern}
+		if isGlobPattern(pattern) {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				log.Errorf("Invalid textfile collector glob pattern %q: %s", pattern, err)
+				lastErr = err
+				continue
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				log.Errorf("Error reading textfile collector directory %q: %s", match, err)
+				lastErr = err
+				continue
+			}
+			if !info.IsDir() {
+				if isTextFile(info.Name()) {
+					add(filepath.Dir(match), match, info)
+				}
+				continue
+			}
+
+			dirFiles, err := readTextFileDir(match, recursive)
+			if err != nil {
+				log.Errorf("Error reading textfile collector directory %q: %s", match, err)
+				lastErr = err
+			}
+			for _, f := range dirFiles {
+				add(match, f.path, f.info)
+			}
+		}
+	}
+	return files, lastErr
+}
+
+// textFileMaxAges holds the max age of text files, which defaults to
+// defaultMaxAge unless overridden for the file or its directory.
+type textFileMaxAges struct {
+	defaultMaxAge time.Duration
+	overrides     []textFileMaxAge
+}
+
+type textFileMaxAge struct {
+	pattern string
+	maxAge  time.Duration
+}
+
+func parseTextFileMaxAges(defaultMaxAge time.Duration, overrides []string) (textFileMaxAges, error) {
+	m := textFileMaxAges{defaultMaxAge: defaultMaxAge}
+	for _, o := range overrides {
+		i := strings.LastIndex(o, "=")
+		if i < 0 {
+			return m, fmt.Errorf("invalid textfile max age override %q, must be pattern=duration", o)
+		}
+		pattern := o[:i]
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return m, fmt.Errorf("invalid textfile max age override pattern %q: %v", pattern, err)
+		}
+		maxAge, err := time.ParseDuration(o[i+1:])
+		if err != nil {
+			return m, fmt.Errorf("invalid textfile max age override %q: %v", o, err)
+		}
+		m.overrides = append(m.overrides, textFileMaxAge{pattern: pattern, maxAge: maxAge})
+	}
+	return m, nil
+}
+
+// forFile returns the max age of f, 0 if it never becomes stale.
+func (m textFileMaxAges) forFile(f textFile) time.Duration {
+	for _, o := range m.overrides {
+		for _, path := range []string{f.path, filepath.Dir(f.path), f.directory} {
+			if ok, _ := filepath.Match(o.pattern, path); ok {
+				return o.maxAge
+			}
+		}
+	}
+	return m.defaultMaxAge
+}
+
+type dirEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// readTextFileDir lists the text files in dir in lexical order, descending
+// into subdirectories if recursive is set. Entries which cannot be read are
+// skipped, and the last error is returned along with the other files.
+func readTextFileDir(dir string, recursive bool) ([]dirEntry, error) {
+	var (
+		entries []dirEntry
+		lastErr error
+	)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			lastErr = err
+			if info != nil && info.IsDir() && path != dir {
+				return filepath.SkipDir
 			}
-			ch <- prometheus.MustNewConstMetric(mtimeDesc, prometheus.GaugeValue, mtime, filename)
+			return nil
 		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isTextFile(info.Name()) {
+			entries = append(entries, dirEntry{path: path, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return entries, err
 	}
+	return entries, lastErr
 }
 
 type carriageReturnFilteringReader struct {
 	r io.Reader
+	// afterCR is set if the last byte read was \r, so that a \n following it
+	// in the next read is dropped.
+	afterCR bool
 }
 
-// Read returns data from the underlying io.Reader, but with \r filtered out
-func (cr carriageReturnFilteringReader) Read(p []byte) (int, error) {
-	buf := make([]byte, len(p))
-	n, err := cr.r.Read(buf)
+// Read returns data from the underlying io.Reader, but with \r\n and lone \r
+// line endings replaced by \n. The data is filtered in place, so no buffer is
+// allocated.
+func (cr *carriageReturnFilteringReader) Read(p []byte) (int, error) {
+	for {
+		n, err := cr.r.Read(p)
+
+		pi := 0
+		for i := 0; i < n; i++ {
+			c := p[i]
+			if c == '\n' && cr.afterCR {
+				cr.afterCR = false
+				continue
+			}
+			cr.afterCR = c == '\r'
+			if c == '\r' {
+				c = '\n'
+			}
+			p[pi] = c
+			pi++
+		}
 
-	if err != nil && err != io.EOF {
-		return n, err
+		// Only a dropped \n was read; read again rather than returning no data.
+		if pi == 0 && n > 0 && err == nil {
+			continue
+		}
+		return pi, err
 	}
+}
 
-	pi := 0
-	for i := 0; i < n; i++ {
-		if buf[i] != '\r' {
-			p[pi] = buf[i]
-			pi++
+// parseTextFile reads the metric families of the text file at path, in the
+// text exposition format, or in JSON if its name ends in .json. Files
+// containing client-side timestamps are rejected unless allowTimestamps is
+// set.
+func parseTextFile(path string, allowTimestamps bool) ([]*dto.MetricFamily, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &textFileError{textFileErrorOpen, err}
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Error closing file: %v", err)
+		}
+	}()
+
+	r, err := decodeTextFile(file)
+	if err != nil {
+		return nil, &textFileError{textFileErrorEncoding, err}
+	}
+
+	var parsedFamilies map[string]*dto.MetricFamily
+	if strings.HasSuffix(path, ".json") {
+		parsedFamilies, err = parseJSONMetrics(r)
+	} else {
+		var parser expfmt.TextParser
+		parsedFamilies, err = parser.TextToMetricFamilies(r)
+	}
+	if err != nil {
+		return nil, &textFileError{textFileErrorParse, err}
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(parsedFamilies))
+	for _, mf := range parsedFamilies {
+		for _, m := range mf.Metric {
+			if m.TimestampMs != nil && !allowTimestamps {
+				return nil, &textFileError{textFileErrorTimestamp, fmt.Errorf("contains unsupported client-side timestamps, skipping entire file")}
+			}
+		}
+		if mf.Help == nil {
+			help := fmt.Sprintf("Metric read from %s", path)
+			mf.Help = &help
 		}
+		families = append(families, mf)
 	}
 
-	return pi, err
+	// Sorting is needed for predictable output.
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, nil
+}
+
+// checkTimestamps returns families without the samples whose client-side
+// timestamp is after now, or older than maxAge if it is not 0, along with an
+// error if any sample was dropped. families is not modified.
+func checkTimestamps(families []*dto.MetricFamily, now time.Time, maxAge time.Duration) ([]*dto.MetricFamily, *textFileError) {
+	valid := func(m *dto.Metric) bool {
+		if m.TimestampMs == nil {
+			return true
+		}
+		ts := time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+		return !ts.After(now) && (maxAge == 0 || now.Sub(ts) <= maxAge)
+	}
+
+	var (
+		checked []*dto.MetricFamily
+		dropped int
+		first   string
+	)
+	for i, mf := range families {
+		var metrics []*dto.Metric
+		for j, m := range mf.Metric {
+			if valid(m) {
+				if metrics != nil {
+					metrics = append(metrics, m)
+				}
+				continue
+			}
+			if dropped == 0 {
+				first = mf.GetName()
+			}
+			dropped++
+			if metrics == nil {
+				metrics = append(make([]*dto.Metric, 0, len(mf.Metric)), mf.Metric[:j]...)
+			}
+		}
+		if metrics == nil {
+			if checked != nil {
+				checked = append(checked, mf)
+			}
+			continue
+		}
+
+		if checked == nil {
+			checked = append(make([]*dto.MetricFamily, 0, len(families)), families[:i]...)
+		}
+		if len(metrics) > 0 {
+			checked = append(checked, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics})
+		}
+	}
+	if dropped == 0 {
+		return families, nil
+	}
+	bounds := "in the future"
+	if maxAge > 0 {
+		bounds += fmt.Sprintf(" or older than %s", maxAge)
+	}
+	return checked, &textFileError{textFileErrorTimestamp, fmt.Errorf("dropped %d samples with a timestamp %s, the first of metric %s", dropped, bounds, first)}
+}
+
+// readTextFile reads f, unless the same version of the file was read before.
+// If the file cannot be read, the content of the last successful read is
+// returned along with the error if serveLastGood is set.
+func (c *textFileCollector) readTextFile(f textFile) (*textFileContent, *textFileError) {
+	c.cacheMu.Lock()
+	entry, cached := c.cache[f.path]
+	c.cacheMu.Unlock()
+
+	if !cached || !entry.matches(f) {
+		log.Debugf("Processing file %q", f.path)
+		families, err := parseTextFile(f.path, c.allowTimestamps)
+
+		next := &textFileCacheEntry{modTime: f.modTime, size: f.size}
+		if err == nil {
+			next.lastGood = &textFileContent{families: families, modTime: f.modTime}
+		} else {
+			next.err = err.(*textFileError)
+			if cached {
+				next.lastGood = entry.lastGood
+			}
+			if next.err.reason == textFileErrorOpen {
+				// Opening may succeed on the next attempt, e.g. once the
+				// file is no longer locked by the process writing it.
+				next.modTime = time.Time{}
+			}
+		}
+
+		c.cacheMu.Lock()
+		if c.cache == nil {
+			c.cache = make(map[string]*textFileCacheEntry)
+		}
+		c.cache[f.path] = next
+		c.cacheMu.Unlock()
+		entry = next
+	}
+
+	if entry.err == nil {
+		return entry.lastGood, nil
+	}
+	if entry.lastGood != nil && c.serveLastGood {
+		log.Warnf("Serving metrics of the last successful read of %q, last modified %s", f.path, entry.lastGood.modTime)
+		return entry.lastGood, entry.err
+	}
+	return nil, entry.err
+}
+
+// forgetRemovedFiles drops the cached content of files no longer found.
+func (c *textFileCollector) forgetRemovedFiles(files []textFile) {
+	found := make(map[string]bool, len(files))
+	for _, f := range files {
+		found[f.path] = true
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	for path := range c.cache {
+		if !found[path] {
+			delete(c.cache, path)
+		}
+	}
+}
+
+// exportTextFileError reports a file which could not be read. Nothing is
+// exported for files read successfully.
+func exportTextFileError(f textFile, reason string, ch chan<- prometheus.Metric) {
+	if reason == "" {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(fileErrorDesc, prometheus.GaugeValue, 1.0, f.directory, f.name, reason)
+}
+
+// textFiles returns the text files to export, as loaded by the watcher if
+// files are loaded in the background.
+func (c *textFileCollector) textFiles() ([]textFile, error) {
+	if c.watcher != nil {
+		return c.watcher.textFiles()
+	}
+	return findTextFiles(c.directories, c.recursive)
 }
 
 // Update implements the Collector interface.
 func (c *textFileCollector) Collect(ctx *ScrapeContext, ch chan<- prometheus.Metric) error {
 	error := 0.0
-	mtimes := map[string]time.Time{}
+	var succeeded []textFile
+	now := c.now()
+	expired := 0
+	merger := newTextFileMerger(c.rejectConflicts)
 
 	// Iterate over files and accumulate their metrics.
-	files, err := ioutil.ReadDir(c.path)
-	if err != nil && c.path != "" {
-		log.Errorf("Error reading textfile collector directory %q: %s", c.path, err)
+	files, err := c.textFiles()
+	if err != nil {
 		error = 1.0
 	}
+	defer c.forgetRemovedFiles(files)
 
-fileLoop:
 	for _, f := range files {
-		if !strings.HasSuffix(f.Name(), ".prom") {
-			continue
+		stale := 0.0
+		if maxAge := c.maxAges.forFile(f); maxAge > 0 && now.Sub(f.modTime) > maxAge {
+			stale = 1.0
 		}
-		path := filepath.Join(c.path, f.Name())
-		log.Debugf("Processing file %q", path)
-		file, err := os.Open(path)
-		if err != nil {
-			log.Errorf("Error opening %q: %v", path, err)
-			error = 1.0
+		ch <- prometheus.MustNewConstMetric(staleDesc, prometheus.GaugeValue, stale, f.directory, f.name)
+		if stale == 1.0 && c.dropStale {
+			log.Debugf("Dropping metrics of stale file %q, last modified %s", f.path, f.modTime)
+			expired++
 			continue
 		}
-		var parser expfmt.TextParser
-		r, encoding := utfbom.Skip(carriageReturnFilteringReader{r: file})
-		if err = checkBOM(encoding); err != nil {
-			log.Errorf("Invalid file encoding detected in %s: %s - file must be UTF8", path, err.Error())
-			error = 1.0
-			continue
-		}
-		parsedFamilies, err := parser.TextToMetricFamilies(r)
-		closeErr := file.Close()
-		if closeErr != nil {
-			log.Warnf("Error closing file: %v", err)
-		}
-		if err != nil {
-			log.Errorf("Error parsing %q: %v", path, err)
-			error = 1.0
-			continue
-		}
-		for _, mf := range parsedFamilies {
-			for _, m := range mf.Metric {
-				if m.TimestampMs != nil {
-					log.Errorf("Textfile %q contains unsupported client-side timestamps, skipping entire file", path)
-					error = 1.0
-					continue fileLoop
+
+		content, tfErr := c.readTextFile(f)
+		if content != nil {
+			families, err := c.pathLabels.apply(f, content.families)
+			if err != nil {
+				tfErr, content = err, nil
+			}
+			if err == nil && c.allowTimestamps {
+				families, err = checkTimestamps(families, now, c.timestampMaxAge)
+				if err != nil && tfErr == nil {
+					tfErr = err
 				}
 			}
-			if mf.Help == nil {
-				help := fmt.Sprintf("Metric read from %s", path)
-				mf.Help = &help
+			if content != nil {
+				if err := merger.add(families); err != nil {
+					tfErr = err
+					if c.rejectConflicts {
+						content = nil
+					}
+				}
 			}
 		}
 
+		var reason string
+		if tfErr != nil {
+			log.Errorf("Error reading %q: %v", f.path, tfErr)
+			reason = tfErr.reason
+			error = 1.0
+		}
+		exportTextFileError(f, reason, ch)
+		if content == nil {
+			continue
+		}
+
 		// Only set this once it has been parsed and validated, so that
 		// a failure does not appear fresh.
-		mtimes[f.Name()] = f.ModTime()
+		served := f
+		served.modTime = content.modTime
+		succeeded = append(succeeded, served)
+	}
 
-		for _, mf := range parsedFamilies {
-			convertMetricFamily(mf, ch)
-		}
+	for _, mf := range merger.result() {
+		convertMetricFamily(mf, ch)
 	}
 
-	c.exportMTimes(mtimes, ch)
+	c.exportMTimes(succeeded, ch)
+	ch <- prometheus.MustNewConstMetric(expiredFilesDesc, prometheus.GaugeValue, float64(expired))
 
 	// Export if there were errors.
 	ch <- prometheus.MustNewConstMetric(
@@ -289,11 +867,3 @@ fileLoop:
 	)
 	return nil
 }
-
-func checkBOM(encoding utfbom.Encoding) error {
-	if encoding == utfbom.Unknown || encoding == utfbom.UTF8 {
-		return nil
-	}
-
-	return fmt.Errorf(encoding.String())
-}
@@ -0,0 +1,154 @@
+// +build !notextfile
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/dimchansky/utfbom"
+)
+
+// textFileSniffLen is the number of bytes at the start of a text file which
+// are checked for binary content.
+const textFileSniffLen = 512
+
+// decodeTextFile returns a reader of the content of r as UTF-8, with \r\n and
+// lone \r line endings replaced by \n. Besides UTF-8, with or without a byte
+// order mark, UTF-16 is supported, as written by PowerShell's Out-File. UTF-16
+// without a byte order mark is recognised if the file starts with ASCII text.
+// Binary content is rejected.
+func decodeTextFile(r io.Reader) (io.Reader, error) {
+	r, encoding := utfbom.Skip(r)
+	if err := checkBOM(encoding); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(r, textFileSniffLen)
+	head, err := br.Peek(textFileSniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch encoding {
+	case utfbom.UTF16LittleEndian:
+		order = binary.LittleEndian
+	case utfbom.UTF16BigEndian:
+		order = binary.BigEndian
+	case utfbom.Unknown:
+		order = sniffUTF16(head)
+	}
+	if order != nil {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeUTF16(data, order)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(bytes.NewReader(decoded), textFileSniffLen)
+		head = decoded
+		if len(head) > textFileSniffLen {
+			head = head[:textFileSniffLen]
+		}
+	}
+
+	if err := checkTextContent(head); err != nil {
+		return nil, err
+	}
+	return &carriageReturnFilteringReader{r: br}, nil
+}
+
+// sniffUTF16 returns the byte order of head if it is the start of UTF-16
+// text without a byte order mark, nil otherwise. As text files start with an
+// ASCII character, the first code unit has one zero byte, and NUL characters
+// are invalid, so the other byte of no code unit is zero.
+func sniffUTF16(head []byte) binary.ByteOrder {
+	if len(head) < 2 {
+		return nil
+	}
+	var zero int
+	switch {
+	case head[0] != 0 && head[1] == 0:
+		zero = 1
+	case head[0] == 0 && head[1] != 0:
+		zero = 0
+	default:
+		return nil
+	}
+	for i := 1 - zero; i < len(head); i += 2 {
+		if head[i] == 0 {
+			return nil
+		}
+	}
+	if zero == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// decodeUTF16 converts data from UTF-16 in the given byte order to UTF-8.
+func decodeUTF16(data []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("invalid UTF-16 content, odd number of bytes")
+	}
+
+	out := make([]byte, 0, len(data)/2)
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(data); i += 2 {
+		r := rune(order.Uint16(data[i:]))
+		if utf16.IsSurrogate(r) {
+			if i+3 < len(data) {
+				r = utf16.DecodeRune(r, rune(order.Uint16(data[i+2:])))
+			} else {
+				r = unicode.ReplacementChar
+			}
+			if r == unicode.ReplacementChar {
+				return nil, fmt.Errorf("invalid UTF-16 surrogate pair at byte offset %d", i)
+			}
+			i += 2
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		out = append(out, buf[:n]...)
+	}
+	return out, nil
+}
+
+// checkTextContent returns an error if head, the start of a file, is not
+// valid UTF-8 or contains control characters other than whitespace, as is the
+// case for binary files.
+func checkTextContent(head []byte) error {
+	for i := 0; i < len(head); {
+		if !utf8.FullRune(head[i:]) {
+			// Truncated by the end of head.
+			break
+		}
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("binary content, invalid UTF-8 at byte offset %d", i)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return fmt.Errorf("binary content, control character %U at byte offset %d", r, i)
+		}
+		i += size
+	}
+	return nil
+}
+
+// checkBOM returns an error for encodings other than UTF-8 and UTF-16.
+func checkBOM(encoding utfbom.Encoding) error {
+	switch encoding {
+	case utfbom.UTF32BigEndian, utfbom.UTF32LittleEndian:
+		return fmt.Errorf("unsupported encoding %s, file must be UTF-8 or UTF-16", encoding)
+	}
+	return nil
+}
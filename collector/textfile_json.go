@@ -0,0 +1,230 @@
+// +build !notextfile
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// jsonSample is a sample in a JSON text file. See docs/collector.textfile.md
+// for the schema.
+type jsonSample struct {
+	Name        string             `json:"name"`
+	Help        *string            `json:"help"`
+	Type        string             `json:"type"`
+	Labels      map[string]string  `json:"labels"`
+	Value       *jsonFloat         `json:"value"`
+	Count       *uint64            `json:"count"`
+	Sum         *jsonFloat         `json:"sum"`
+	Buckets     map[string]uint64  `json:"buckets"`
+	Quantiles   map[string]float64 `json:"quantiles"`
+	TimestampMs *int64             `json:"timestamp_ms"`
+}
+
+// jsonFloat is a float given as a JSON number, or as a string to allow the
+// values NaN, +Inf and -Inf, which JSON numbers cannot represent.
+type jsonFloat float64
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var v float64
+		if err := json.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("invalid value %s, must be a number or a string", b)
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", s)
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+var jsonMetricTypes = map[string]dto.MetricType{
+	"":          dto.MetricType_UNTYPED,
+	"untyped":   dto.MetricType_UNTYPED,
+	"counter":   dto.MetricType_COUNTER,
+	"gauge":     dto.MetricType_GAUGE,
+	"summary":   dto.MetricType_SUMMARY,
+	"histogram": dto.MetricType_HISTOGRAM,
+}
+
+// parseJSONMetrics reads the metric families of a JSON text file: an array
+// of samples, or a single sample, as PowerShell's ConvertTo-Json writes an
+// array of one element. Samples of the same metric must agree on its type
+// and HELP text.
+func parseJSONMetrics(r io.Reader) (map[string]*dto.MetricFamily, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '[' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+
+	var samples []jsonSample
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&samples); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid JSON: data after the top-level value")
+	}
+
+	families := make(map[string]*dto.MetricFamily)
+	for i, s := range samples {
+		mf, err := s.addTo(families)
+		if err != nil {
+			if s.Name != "" {
+				return nil, fmt.Errorf("sample %d (%s): %v", i, s.Name, err)
+			}
+			return nil, fmt.Errorf("sample %d: %v", i, err)
+		}
+		families[mf.GetName()] = mf
+	}
+	return families, nil
+}
+
+// addTo converts s, and returns the family of families it belongs to with s
+// added.
+func (s jsonSample) addTo(families map[string]*dto.MetricFamily) (*dto.MetricFamily, error) {
+	if !model.IsValidMetricName(model.LabelValue(s.Name)) {
+		return nil, fmt.Errorf("invalid metric name %q", s.Name)
+	}
+	typ, ok := jsonMetricTypes[strings.ToLower(s.Type)]
+	if !ok {
+		return nil, fmt.Errorf("invalid metric type %q", s.Type)
+	}
+
+	mf, ok := families[s.Name]
+	if !ok {
+		mf = &dto.MetricFamily{Name: &s.Name, Help: s.Help, Type: &typ}
+	}
+	if mf.GetType() != typ {
+		return nil, fmt.Errorf("type %s differs from the type %s of a previous sample", typ, mf.GetType())
+	}
+	if s.Help != nil {
+		if mf.Help == nil {
+			mf.Help = s.Help
+		} else if *mf.Help != *s.Help {
+			return nil, fmt.Errorf("HELP text differs from the one of a previous sample")
+		}
+	}
+
+	m := &dto.Metric{TimestampMs: s.TimestampMs}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		name, value := name, s.Labels[name]
+		m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	if err := s.setValue(m, typ); err != nil {
+		return nil, err
+	}
+	mf.Metric = append(mf.Metric, m)
+	return mf, nil
+}
+
+// setValue sets the value of m of the given type from the fields of s,
+// checking that only the fields applying to the type are set.
+func (s jsonSample) setValue(m *dto.Metric, typ dto.MetricType) error {
+	switch typ {
+	case dto.MetricType_SUMMARY, dto.MetricType_HISTOGRAM:
+		if s.Value != nil {
+			return fmt.Errorf("%s samples have a count and sum instead of a value", strings.ToLower(typ.String()))
+		}
+		if s.Count == nil || s.Sum == nil {
+			return fmt.Errorf("%s samples must have a count and sum", strings.ToLower(typ.String()))
+		}
+	default:
+		if s.Value == nil {
+			return fmt.Errorf("missing value")
+		}
+		if s.Count != nil || s.Sum != nil || s.Buckets != nil || s.Quantiles != nil {
+			return fmt.Errorf("count, sum, buckets and quantiles are only allowed for summaries and histograms")
+		}
+	}
+	if typ != dto.MetricType_HISTOGRAM && s.Buckets != nil {
+		return fmt.Errorf("buckets are only allowed for histograms")
+	}
+	if typ != dto.MetricType_SUMMARY && s.Quantiles != nil {
+		return fmt.Errorf("quantiles are only allowed for summaries")
+	}
+
+	switch typ {
+	case dto.MetricType_UNTYPED:
+		v := float64(*s.Value)
+		m.Untyped = &dto.Untyped{Value: &v}
+	case dto.MetricType_GAUGE:
+		v := float64(*s.Value)
+		m.Gauge = &dto.Gauge{Value: &v}
+	case dto.MetricType_COUNTER:
+		v := float64(*s.Value)
+		if v < 0 {
+			return fmt.Errorf("counter value %v is negative", v)
+		}
+		m.Counter = &dto.Counter{Value: &v}
+	case dto.MetricType_SUMMARY:
+		sum := float64(*s.Sum)
+		m.Summary = &dto.Summary{SampleCount: s.Count, SampleSum: &sum}
+		keys := make([]string, 0, len(s.Quantiles))
+		for key := range s.Quantiles {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			q, err := strconv.ParseFloat(key, 64)
+			if err != nil || q < 0 || q > 1 {
+				return fmt.Errorf("invalid quantile %q, must be between 0 and 1", key)
+			}
+			v := s.Quantiles[key]
+			m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: &q, Value: &v})
+		}
+	case dto.MetricType_HISTOGRAM:
+		sum := float64(*s.Sum)
+		m.Histogram = &dto.Histogram{SampleCount: s.Count, SampleSum: &sum}
+		keys := make([]string, 0, len(s.Buckets))
+		for key := range s.Buckets {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			le, err := strconv.ParseFloat(key, 64)
+			if err != nil || math.IsNaN(le) {
+				return fmt.Errorf("invalid bucket upper bound %q", key)
+			}
+			count := s.Buckets[key]
+			if count > *s.Count {
+				return fmt.Errorf("bucket %q has a count of %d, more than the sample count %d", key, count, *s.Count)
+			}
+			m.Histogram.Bucket = append(m.Histogram.Bucket, &dto.Bucket{UpperBound: &le, CumulativeCount: &count})
+		}
+		sort.Slice(m.Histogram.Bucket, func(i, j int) bool {
+			return m.Histogram.Bucket[i].GetUpperBound() < m.Histogram.Bucket[j].GetUpperBound()
+		})
+	}
+	return nil
+}
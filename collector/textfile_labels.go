@@ -0,0 +1,149 @@
+// +build !notextfile
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+const textFileSourceFileLabel = "source_file"
+
+// Policies for labels derived from the path of a text file which are already
+// set in the file.
+const (
+	textFileLabelClashKeep      = "keep"
+	textFileLabelClashOverwrite = "overwrite"
+	textFileLabelClashReject    = "reject"
+)
+
+// textFilePathLabels adds labels derived from the path of a text file to its
+// metrics: the named capture groups of a regular expression matching the path
+// relative to the textfile directory, and optionally the path itself.
+type textFilePathLabels struct {
+	regex       *regexp.Regexp
+	sourceFile  bool
+	clashPolicy string
+}
+
+// newTextFilePathLabels returns nil if no labels are to be added.
+func newTextFilePathLabels(regex string, sourceFile bool, clashPolicy string) (*textFilePathLabels, error) {
+	if regex == "" && !sourceFile {
+		return nil, nil
+	}
+	l := &textFilePathLabels{sourceFile: sourceFile, clashPolicy: clashPolicy}
+	if regex == "" {
+		return l, nil
+	}
+
+	var err error
+	if l.regex, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+		return nil, fmt.Errorf("invalid textfile path label regex %q: %v", regex, err)
+	}
+	for _, name := range l.regex.SubexpNames()[1:] {
+		switch {
+		case name == "":
+			// Unnamed groups are only used for grouping.
+		case !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix):
+			return nil, fmt.Errorf("invalid label name %q in textfile path label regex", name)
+		case sourceFile && name == textFileSourceFileLabel:
+			return nil, fmt.Errorf("textfile path label regex must not capture %s if the %s label is added", name, name)
+		}
+	}
+	return l, nil
+}
+
+// labels returns the labels derived from the path of f, sorted by name.
+func (l *textFilePathLabels) labels(f textFile) []*dto.LabelPair {
+	var pairs []*dto.LabelPair
+	add := func(name, value string) {
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	if l.regex != nil {
+		if match := l.regex.FindStringSubmatch(f.name); match != nil {
+			for i, name := range l.regex.SubexpNames() {
+				if i > 0 && name != "" {
+					add(name, match[i])
+				}
+			}
+		}
+	}
+	if l.sourceFile {
+		add(textFileSourceFileLabel, f.path)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return pairs
+}
+
+// apply returns the families of f with the labels derived from its path
+// added. Labels already set in the file are kept or overwritten, or the file
+// is rejected with an error, according to the clash policy. families is not
+// modified. A nil l adds no labels.
+func (l *textFilePathLabels) apply(f textFile, families []*dto.MetricFamily) ([]*dto.MetricFamily, *textFileError) {
+	if l == nil {
+		return families, nil
+	}
+	pathLabels := l.labels(f)
+	if len(pathLabels) == 0 {
+		return families, nil
+	}
+
+	labelled := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		metrics := make([]*dto.Metric, 0, len(mf.Metric))
+		for _, m := range mf.Metric {
+			labels, err := l.merge(mf.GetName(), m.Label, pathLabels)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, &dto.Metric{
+				Label:       labels,
+				Gauge:       m.Gauge,
+				Counter:     m.Counter,
+				Summary:     m.Summary,
+				Untyped:     m.Untyped,
+				Histogram:   m.Histogram,
+				TimestampMs: m.TimestampMs,
+			})
+		}
+		labelled = append(labelled, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics})
+	}
+	return labelled, nil
+}
+
+// merge returns the labels of a metric with the path labels added, sorted by
+// name. Empty labels are equivalent to absent ones, so they never clash.
+func (l *textFilePathLabels) merge(metric string, labels, pathLabels []*dto.LabelPair) ([]*dto.LabelPair, *textFileError) {
+	merged := make([]*dto.LabelPair, 0, len(labels)+len(pathLabels))
+	set := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		if label.GetValue() != "" {
+			set[label.GetName()] = true
+		}
+	}
+	overwritten := make(map[string]bool, len(pathLabels))
+	for _, label := range pathLabels {
+		if set[label.GetName()] {
+			switch l.clashPolicy {
+			case textFileLabelClashReject:
+				return nil, &textFileError{textFileErrorLabelClash, fmt.Errorf("label %s of metric %s is also derived from the file path", label.GetName(), metric)}
+			case textFileLabelClashKeep:
+				continue
+			}
+		}
+		overwritten[label.GetName()] = true
+		merged = append(merged, label)
+	}
+	for _, label := range labels {
+		if !overwritten[label.GetName()] {
+			merged = append(merged, label)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].GetName() < merged[j].GetName() })
+	return merged, nil
+}
@@ -0,0 +1,117 @@
+// +build !notextfile
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// textFileMerger merges the metric families read from several text files,
+// so that a metric family defined in more than one file is exported once,
+// with the label sets of all files. Files are added in a fixed order; when
+// files conflict, the file added first takes precedence.
+type textFileMerger struct {
+	rejectConflicts bool
+
+	families map[string]*dto.MetricFamily
+	// series holds the label signatures of the metrics of each family.
+	series map[string]map[string]bool
+}
+
+func newTextFileMerger(rejectConflicts bool) *textFileMerger {
+	return &textFileMerger{
+		rejectConflicts: rejectConflicts,
+		families:        make(map[string]*dto.MetricFamily),
+		series:          make(map[string]map[string]bool),
+	}
+}
+
+// labelSignature identifies the series of m. Empty label values are ignored,
+// as they are equivalent to the label being absent.
+func labelSignature(m *dto.Metric) string {
+	pairs := make([]string, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		if l.GetValue() != "" {
+			pairs = append(pairs, l.GetName()+"\xff"+l.GetValue())
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// add merges the families of a file. Families with a type different from
+// the one already added, and series already added, are conflicts: if
+// conflicts are rejected nothing of the file is added, otherwise only the
+// conflicting families and series are skipped. In both cases an error
+// describing the first conflict is returned. A different HELP text is not a
+// conflict; the first one is kept.
+func (m *textFileMerger) add(families []*dto.MetricFamily) *textFileError {
+	var conflict *textFileError
+	fileSeries := make(map[string]map[string]bool)
+	for _, mf := range families {
+		name := mf.GetName()
+		if existing, ok := m.families[name]; ok && existing.GetType() != mf.GetType() {
+			if conflict == nil {
+				conflict = &textFileError{textFileErrorConflictingType, fmt.Errorf("metric %s has type %s, but was already read with type %s from another file", name, mf.GetType(), existing.GetType())}
+			}
+			continue
+		}
+
+		fileSeries[name] = make(map[string]bool)
+		for _, metric := range mf.Metric {
+			sig := labelSignature(metric)
+			if m.series[name][sig] || fileSeries[name][sig] {
+				if conflict == nil {
+					conflict = &textFileError{textFileErrorDuplicateSeries, fmt.Errorf("metric %s has a series with the same labels as one read before", name)}
+				}
+				continue
+			}
+			fileSeries[name][sig] = true
+		}
+	}
+	if conflict != nil && m.rejectConflicts {
+		return conflict
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		added, ok := fileSeries[name]
+		if !ok {
+			continue
+		}
+
+		merged, ok := m.families[name]
+		if !ok {
+			merged = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+			m.families[name] = merged
+			m.series[name] = make(map[string]bool)
+		}
+		for _, metric := range mf.Metric {
+			sig := labelSignature(metric)
+			if added[sig] && !m.series[name][sig] {
+				m.series[name][sig] = true
+				merged.Metric = append(merged.Metric, metric)
+			}
+		}
+	}
+	return conflict
+}
+
+// result returns the merged families sorted by name.
+func (m *textFileMerger) result() []*dto.MetricFamily {
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	families := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		families = append(families, m.families[name])
+	}
+	return families
+}
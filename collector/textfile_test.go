@@ -1,15 +1,29 @@
 package collector
 
 import (
-	"github.com/dimchansky/utfbom"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dimchansky/utfbom"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
 func TestCRFilter(t *testing.T) {
 	sr := strings.NewReader("line 1\r\nline 2")
-	cr := carriageReturnFilteringReader{r: sr}
+	cr := &carriageReturnFilteringReader{r: sr}
 	b, err := ioutil.ReadAll(cr)
 	if err != nil {
 		t.Error(err)
@@ -20,6 +34,39 @@ func TestCRFilter(t *testing.T) {
 	}
 }
 
+func TestCRFilterLineEndings(t *testing.T) {
+	testdata := []struct {
+		in, out string
+	}{
+		{"line 1\r\nline 2\r\n", "line 1\nline 2\n"},
+		{"line 1\rline 2\r", "line 1\nline 2\n"},
+		{"line 1\r\rline 2\n\r\n", "line 1\n\nline 2\n\n"},
+	}
+	for _, d := range testdata {
+		// Reading one byte at a time splits \r\n over two reads, and makes
+		// reads consisting of only a dropped \n.
+		cr := &carriageReturnFilteringReader{r: iotest.OneByteReader(strings.NewReader(d.in))}
+		var out []byte
+		p := make([]byte, 1)
+		for {
+			n, err := cr.Read(p)
+			if n == 0 && err == nil {
+				t.Fatalf("Empty read for %q", d.in)
+			}
+			out = append(out, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if string(out) != d.out {
+			t.Errorf("For %q expected %q, got %q", d.in, d.out, out)
+		}
+	}
+}
+
 func TestCheckBOM(t *testing.T) {
 	testdata := []struct {
 		encoding utfbom.Encoding
@@ -27,8 +74,8 @@ func TestCheckBOM(t *testing.T) {
 	}{
 		{utfbom.Unknown, ""},
 		{utfbom.UTF8, ""},
-		{utfbom.UTF16BigEndian, "UTF16BigEndian"},
-		{utfbom.UTF16LittleEndian, "UTF16LittleEndian"},
+		{utfbom.UTF16BigEndian, ""},
+		{utfbom.UTF16LittleEndian, ""},
 		{utfbom.UTF32BigEndian, "UTF32BigEndian"},
 		{utfbom.UTF32LittleEndian, "UTF32LittleEndian"},
 	}
@@ -45,3 +92,883 @@ func TestCheckBOM(t *testing.T) {
 		}
 	}
 }
+
+// encodeUTF16 encodes s as UTF-16 in the given byte order, prefixed by bom.
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) string {
+	var b []byte
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xfeff}, units...)
+	}
+	for _, u := range units {
+		var buf [2]byte
+		order.PutUint16(buf[:], u)
+		b = append(b, buf[:]...)
+	}
+	return string(b)
+}
+
+func TestDecodeTextFile(t *testing.T) {
+	const text = "# HELP test_metric Température in °C 🌡\r\ntest_metric{room=\"salle à manger\"} 21.5\r\n"
+	const expected = "# HELP test_metric Température in °C 🌡\ntest_metric{room=\"salle à manger\"} 21.5\n"
+
+	testdata := []struct {
+		name string
+		in   string
+		out  string
+		err  string
+	}{
+		{name: "UTF-8", in: text, out: expected},
+		{name: "UTF-8 with BOM", in: "\xef\xbb\xbf" + text, out: expected},
+		{name: "UTF-16LE with BOM", in: encodeUTF16(text, binary.LittleEndian, true), out: expected},
+		{name: "UTF-16BE with BOM", in: encodeUTF16(text, binary.BigEndian, true), out: expected},
+		{name: "UTF-16LE without BOM", in: encodeUTF16(text, binary.LittleEndian, false), out: expected},
+		{name: "UTF-16BE without BOM", in: encodeUTF16(text, binary.BigEndian, false), out: expected},
+		{name: "lone CR", in: "test_a 1\rtest_b 2\r", out: "test_a 1\ntest_b 2\n"},
+		{name: "empty", in: "", out: ""},
+		{name: "UTF-32BE", in: "\x00\x00\xfe\xfftest 1\n", err: "unsupported encoding UTF32BigEndian"},
+		{name: "UTF-32LE", in: "\xff\xfe\x00\x00test 1\n", err: "unsupported encoding UTF32LittleEndian"},
+		{name: "NUL bytes", in: "\x7fELF\x02\x01\x01\x00\x00", err: "binary content, control character U+0002 at byte offset 4"},
+		{name: "invalid UTF-8", in: "test 1\n\xff\xd8\xff\xe0", err: "binary content, invalid UTF-8 at byte offset 7"},
+		{name: "UTF-16 odd length", in: encodeUTF16("test 1\n", binary.LittleEndian, true) + "\n", err: "odd number of bytes"},
+		{name: "UTF-16 unpaired surrogate", in: encodeUTF16("test 1\n", binary.LittleEndian, true) + "\x00\xd8", err: "invalid UTF-16 surrogate pair at byte offset 14"},
+		{name: "UTF-16 binary", in: encodeUTF16("test\x01", binary.BigEndian, true), err: "binary content, control character U+0001 at byte offset 4"},
+	}
+	for _, d := range testdata {
+		t.Run(d.name, func(t *testing.T) {
+			r, err := decodeTextFile(strings.NewReader(d.in))
+			if d.err != "" {
+				if err == nil || !strings.Contains(err.Error(), d.err) {
+					t.Errorf("Expected error %q, got %v", d.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != d.out {
+				t.Errorf("Expected %q, got %q", d.out, out)
+			}
+		})
+	}
+}
+
+func TestTextFileUTF16(t *testing.T) {
+	// As written by PowerShell's Out-File.
+	dir := writeTextFiles(t, map[string]string{
+		"job.prom": encodeUTF16("# TYPE test_job gauge\r\ntest_job{status=\"ok\"} 1\r\n", binary.LittleEndian, true),
+	})
+	defer os.RemoveAll(dir)
+
+	out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}})
+	if !strings.Contains(out, `test_job{status="ok"} 1`) {
+		t.Errorf("Expected test_job in output:\n%s", out)
+	}
+	if strings.Contains(out, "wmi_textfile_file_error{") {
+		t.Errorf("Expected no file error in output:\n%s", out)
+	}
+}
+
+// textFileCollectorAdapter registers a textFileCollector with a prometheus
+// registry.
+type textFileCollectorAdapter struct {
+	c *textFileCollector
+}
+
+func (a textFileCollectorAdapter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (a textFileCollectorAdapter) Collect(ch chan<- prometheus.Metric) {
+	if err := a.c.Collect(newScrapeContext(nil, nil), ch); err != nil {
+		panic(err)
+	}
+}
+
+// gatherTextFiles runs c and returns its metrics in the text format.
+func gatherTextFiles(t *testing.T, c *textFileCollector) string {
+	t.Helper()
+	if c.mtime == nil {
+		mtime := 1.0
+		c.mtime = &mtime
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(textFileCollectorAdapter{c})
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	enc := expfmt.NewEncoder(&b, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
+
+// writeTextFiles creates a temporary directory containing the given files,
+// keyed by slash-separated relative path.
+func writeTextFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "textfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTextFileDirectories(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"a/metrics.prom":        "test_a 1\n",
+		"a/ignored.txt":         "test_ignored 1\n",
+		"a/sub/nested.prom":     "test_nested 1\n",
+		"b/metrics.prom":        "test_b 1\n",
+		"c1/metrics.prom":       "test_c1 1\n",
+		"c2/metrics.prom":       "test_c2 1\n",
+		"c2/other.prom":         "test_c2_other 1\n",
+		"c2/deeper/deeper.prom": "test_c2_deeper 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	dirA, dirB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	dirC1, dirC2 := filepath.Join(dir, "c1"), filepath.Join(dir, "c2")
+
+	cases := []struct {
+		name        string
+		directories []string
+		recursive   bool
+		files       map[string]string
+		missing     []string
+		once        string
+	}{
+		{
+			name:        "same file name in two directories",
+			directories: []string{dirA, dirB},
+			files:       map[string]string{dirA: "metrics.prom", dirB: "metrics.prom"},
+			missing:     []string{"test_nested", "test_ignored"},
+		},
+		{
+			name:        "recursive",
+			directories: []string{dirA},
+			recursive:   true,
+			files:       map[string]string{dirA: "sub/nested.prom"},
+		},
+		{
+			name:        "glob matching directories",
+			directories: []string{filepath.Join(dir, "c*")},
+			files:       map[string]string{dirC1: "metrics.prom", dirC2: "other.prom"},
+			missing:     []string{"test_a", "test_c2_deeper"},
+		},
+		{
+			name:        "glob matching files",
+			directories: []string{filepath.Join(dir, "c2", "o*.prom")},
+			files:       map[string]string{dirC2: "other.prom"},
+			missing:     []string{"test_c2 "},
+		},
+		{
+			name:        "overlapping directories",
+			directories: []string{dirC2, filepath.Join(dirC2, "deeper")},
+			recursive:   true,
+			files:       map[string]string{dirC2: "deeper/deeper.prom"},
+			once:        "test_c2_deeper 1",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := gatherTextFiles(t, &textFileCollector{directories: c.directories, recursive: c.recursive})
+			if !strings.Contains(out, "wmi_textfile_scrape_error 0") {
+				t.Errorf("Unexpected scrape error:\n%s", out)
+			}
+			for directory, file := range c.files {
+				mtime := `wmi_textfile_mtime_seconds{directory="` + directory + `",file="` + file + `"} 1`
+				if !strings.Contains(out, mtime) {
+					t.Errorf("Expected %s in output:\n%s", mtime, out)
+				}
+			}
+			for _, name := range c.missing {
+				if strings.Contains(out, name) {
+					t.Errorf("Unexpected %s in output:\n%s", name, out)
+				}
+			}
+			if c.once != "" && strings.Count(out, c.once) != 1 {
+				t.Errorf("Expected %s once in output:\n%s", c.once, out)
+			}
+		})
+	}
+}
+
+func TestSplitTextFilePaths(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"", nil},
+		{`C:\metrics`, []string{`C:\metrics`}},
+		{` C:\a , C:\b\*\metrics,,`, []string{`C:\a`, `C:\b\*\metrics`}},
+		{`metrics,./metrics,other`, []string{`metrics`, `other`}},
+		{`"C:\Program Files\a,b",C:\c`, []string{`C:\Program Files\a,b`, `C:\c`}},
+		{`""`, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			if got := splitTextFilePaths(c.input); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("Expected %q, got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestTextFileMissingDirectory(t *testing.T) {
+	out := gatherTextFiles(t, &textFileCollector{directories: []string{filepath.Join(os.TempDir(), "does-not-exist-textfile")}})
+	if !strings.Contains(out, "wmi_textfile_scrape_error 1") {
+		t.Errorf("Expected scrape error for missing directory:\n%s", out)
+	}
+}
+
+func TestTextFileMaxAge(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"fresh.prom":      "test_fresh 1\n",
+		"old.prom":        "test_old 1\n",
+		"jobs/daily.prom": "test_daily 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, name := range []string{"old.prom", "jobs/daily.prom"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	maxAges, err := parseTextFileMaxAges(time.Hour, []string{filepath.Join(dir, "jobs") + "=25h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		dropStale bool
+		expected  []string
+		missing   []string
+	}{
+		{
+			name:      "drop",
+			dropStale: true,
+			expected: []string{
+				"test_fresh 1",
+				"test_daily 1",
+				`wmi_textfile_stale{directory="` + dir + `",file="old.prom"} 1`,
+				`wmi_textfile_stale{directory="` + dir + `",file="fresh.prom"} 0`,
+				`wmi_textfile_stale{directory="` + dir + `",file="jobs/daily.prom"} 0`,
+				"wmi_textfile_expired_files 1",
+			},
+			missing: []string{"test_old", `wmi_textfile_mtime_seconds{directory="` + dir + `",file="old.prom"}`},
+		},
+		{
+			name: "keep",
+			expected: []string{
+				"test_old 1",
+				`wmi_textfile_stale{directory="` + dir + `",file="old.prom"} 1`,
+				"wmi_textfile_expired_files 0",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := gatherTextFiles(t, &textFileCollector{
+				directories: []string{dir},
+				recursive:   true,
+				maxAges:     maxAges,
+				dropStale:   c.dropStale,
+				now:         func() time.Time { return now },
+			})
+			for _, e := range c.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("Expected %s in output:\n%s", e, out)
+				}
+			}
+			for _, m := range c.missing {
+				if strings.Contains(out, m) {
+					t.Errorf("Unexpected %s in output:\n%s", m, out)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTextFileMaxAgesInvalid(t *testing.T) {
+	for _, o := range []string{"C:\\jobs", "C:\\jobs=soon", "[=1h"} {
+		if _, err := parseTextFileMaxAges(0, []string{o}); err == nil {
+			t.Errorf("Expected an error for %q, but got ok", o)
+		}
+	}
+}
+
+func TestTextFileErrors(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"a_good.prom":      "# TYPE test_type gauge\ntest_type 1\ntest_good 1\n",
+		"b_parse.prom":     "test_parse{ 1\n",
+		"c_encoding.prom":  "\x00\x00\xfe\xfftest_encoding 1\n",
+		"d_timestamp.prom": "test_timestamp 1 1500000000000\n",
+		"e_conflict.prom":  "# TYPE test_type counter\ntest_type 1\ntest_conflict 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{
+		"a_good.prom":      "",
+		"b_parse.prom":     textFileErrorParse,
+		"c_encoding.prom":  textFileErrorEncoding,
+		"d_timestamp.prom": textFileErrorTimestamp,
+		"e_conflict.prom":  textFileErrorConflictingType,
+	}
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "f_open.prom")); err == nil {
+		expected["f_open.prom"] = textFileErrorOpen
+	}
+
+	out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}, rejectConflicts: true})
+	for file, reason := range expected {
+		prefix := `wmi_textfile_file_error{directory="` + dir + `",file="` + file + `",`
+		series := 0
+		if reason != "" {
+			series = 1
+			if line := prefix + `reason="` + reason + `"} 1`; !strings.Contains(out, line) {
+				t.Errorf("Expected %s in output:\n%s", line, out)
+			}
+		}
+		if n := strings.Count(out, prefix); n != series {
+			t.Errorf("Expected %d error series for %s, got %d in output:\n%s", series, file, n, out)
+		}
+	}
+	if !strings.Contains(out, "test_good 1") || !strings.Contains(out, "wmi_textfile_scrape_error 1") {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+	for _, m := range []string{"test_parse", "test_encoding", "test_timestamp", "test_conflict"} {
+		if strings.Contains(out, m) {
+			t.Errorf("Unexpected %s in output:\n%s", m, out)
+		}
+	}
+}
+
+func TestTextFileServeLastGood(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{"job.prom": "test_job 1\n"})
+	defer os.RemoveAll(dir)
+
+	for _, serveLastGood := range []bool{false, true} {
+		c := &textFileCollector{directories: []string{dir}, serveLastGood: serveLastGood}
+		if err := ioutil.WriteFile(filepath.Join(dir, "job.prom"), []byte("test_job 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out := gatherTextFiles(t, c); !strings.Contains(out, "test_job 1") {
+			t.Fatalf("Expected test_job in output:\n%s", out)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "job.prom"), []byte("test_job{ 2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out := gatherTextFiles(t, c)
+		if !strings.Contains(out, `file="job.prom",reason="parse"} 1`) {
+			t.Errorf("Expected parse error in output:\n%s", out)
+		}
+		if served := strings.Contains(out, "test_job 1"); served != serveLastGood {
+			t.Errorf("Expected last good metrics served to be %v, output:\n%s", serveLastGood, out)
+		}
+	}
+}
+
+func TestTextFileTimestamps(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	dir := writeTextFiles(t, map[string]string{
+		"job.prom": `# TYPE test_job_last_run gauge
+test_job_last_run{job="current"} 1 1500000000000
+test_job_last_run{job="recent"} 2 1499999000000
+test_job_last_run{job="future"} 3 1500000001000
+test_job_last_run{job="old"} 4 1400000000000
+test_job_no_timestamp 5
+test_job_only_old 6 1400000000000
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	c := &textFileCollector{
+		directories:     []string{dir},
+		allowTimestamps: true,
+		timestampMaxAge: time.Hour,
+		now:             func() time.Time { return now },
+	}
+	out := gatherTextFiles(t, c)
+	for _, line := range []string{
+		`test_job_last_run{job="current"} 1 1500000000000`,
+		`test_job_last_run{job="recent"} 2 1499999000000`,
+		`test_job_no_timestamp 5`,
+		`wmi_textfile_file_error{directory="` + dir + `",file="job.prom",reason="timestamp"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	for _, s := range []string{`job="future"`, `job="old"`, "test_job_only_old"} {
+		if strings.Contains(out, s) {
+			t.Errorf("Expected samples matching %q to be dropped, output:\n%s", s, out)
+		}
+	}
+
+	// Without the option, the file is skipped entirely.
+	c = &textFileCollector{directories: []string{dir}, now: func() time.Time { return now }}
+	if out := gatherTextFiles(t, c); strings.Contains(out, "test_job") {
+		t.Errorf("Expected file with timestamps to be skipped, output:\n%s", out)
+	}
+}
+
+func TestCheckTimestampsDoesNotModifyInput(t *testing.T) {
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(strings.NewReader("test_a 1 1000\ntest_a{l=\"x\"} 1 5000\ntest_b 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := []*dto.MetricFamily{parsed["test_a"], parsed["test_b"]}
+
+	checked, tfErr := checkTimestamps(families, time.Unix(2, 0), 0)
+	if tfErr == nil || tfErr.reason != textFileErrorTimestamp {
+		t.Errorf("Expected a timestamp error, got %v", tfErr)
+	}
+	if len(checked) != 2 || len(checked[0].Metric) != 1 || len(families[0].Metric) != 2 {
+		t.Errorf("Expected one sample of test_a to be dropped from a copy, got %v from %v", checked, families)
+	}
+
+	if checked, tfErr := checkTimestamps(families, time.Unix(10, 0), 0); tfErr != nil || len(checked[0].Metric) != 2 {
+		t.Errorf("Expected no samples to be dropped, got %v: %v", checked, tfErr)
+	}
+}
+
+func TestTextFileJSON(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"backup.json": `[
+  {"name": "test_backup_last_success_timestamp_seconds", "help": "Time of the last successful backup.", "type": "gauge", "labels": {"job": "sql"}, "value": 1500000000},
+  {"name": "test_backup_last_success_timestamp_seconds", "type": "gauge", "labels": {"job": "files"}, "value": 1500000100},
+  {"name": "test_backup_errors_total", "type": "Counter", "value": 3},
+  {"name": "test_backup_ratio", "value": "NaN"},
+  {"name": "test_backup_duration_seconds", "type": "histogram", "count": 4, "sum": 130.5, "buckets": {"10": 1, "60": 3, "+Inf": 4}},
+  {"name": "test_backup_size_bytes", "type": "summary", "count": 4, "sum": 4000, "quantiles": {"0.5": 900, "0.9": 1500}}
+]`,
+		"single.json": `{"name": "test_backup_last_success_timestamp_seconds", "type": "gauge", "labels": {"job": "single"}, "value": 1500000200}`,
+		"other.prom":  "# TYPE test_backup_errors_total counter\ntest_backup_errors_total{job=\"other\"} 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}})
+	for _, expected := range []string{
+		"# HELP test_backup_last_success_timestamp_seconds Time of the last successful backup.\n# TYPE test_backup_last_success_timestamp_seconds gauge\n" +
+			"test_backup_last_success_timestamp_seconds{job=\"files\"} 1.5000001e+09\n" +
+			"test_backup_last_success_timestamp_seconds{job=\"single\"} 1.5000002e+09\n" +
+			"test_backup_last_success_timestamp_seconds{job=\"sql\"} 1.5e+09\n",
+		"# TYPE test_backup_errors_total counter\ntest_backup_errors_total{job=\"\"} 3\ntest_backup_errors_total{job=\"other\"} 1\n",
+		"test_backup_ratio NaN\n",
+		"# TYPE test_backup_duration_seconds histogram\n" +
+			"test_backup_duration_seconds_bucket{le=\"10\"} 1\n" +
+			"test_backup_duration_seconds_bucket{le=\"60\"} 3\n" +
+			"test_backup_duration_seconds_bucket{le=\"+Inf\"} 4\n" +
+			"test_backup_duration_seconds_sum 130.5\n" +
+			"test_backup_duration_seconds_count 4\n",
+		"test_backup_size_bytes{quantile=\"0.5\"} 900\ntest_backup_size_bytes{quantile=\"0.9\"} 1500\ntest_backup_size_bytes_sum 4000\ntest_backup_size_bytes_count 4\n",
+		`wmi_textfile_mtime_seconds{directory="` + dir + `",file="single.json"} 1`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "wmi_textfile_file_error{") {
+		t.Errorf("Expected no file error in output:\n%s", out)
+	}
+}
+
+func TestParseJSONMetricsInvalid(t *testing.T) {
+	testdata := []struct {
+		json string
+		err  string
+	}{
+		{`{"name": "test", "value": 1`, "invalid JSON"},
+		{`[{"name": "test", "value": 1}] []`, "invalid JSON"},
+		{`{"name": "test", "vaule": 1}`, `unknown field "vaule"`},
+		{`{"name": "test-metric", "value": 1}`, `invalid metric name "test-metric"`},
+		{`{"name": "test", "type": "gaguge", "value": 1}`, `invalid metric type "gaguge"`},
+		{`{"name": "test"}`, "sample 0 (test): missing value"},
+		{`{"name": "test", "value": "one"}`, `invalid value "one"`},
+		{`{"name": "test", "value": true}`, "must be a number or a string"},
+		{`{"name": "test", "labels": {"job": 1}, "value": 1}`, "invalid JSON"},
+		{`{"name": "test", "labels": {"__name__": "x"}, "value": 1}`, `invalid label name "__name__"`},
+		{`{"name": "test", "type": "counter", "value": -1}`, "counter value -1 is negative"},
+		{`[{"name": "test", "type": "gauge", "value": 1}, {"name": "test", "type": "counter", "value": 1}]`, "sample 1 (test): type COUNTER differs from the type GAUGE"},
+		{`[{"name": "test", "help": "a", "value": 1}, {"name": "test", "help": "b", "value": 1}]`, "HELP text differs"},
+		{`{"name": "test", "value": 1, "buckets": {"1": 1}}`, "only allowed for summaries and histograms"},
+		{`{"name": "test", "type": "histogram", "count": 1}`, "must have a count and sum"},
+		{`{"name": "test", "type": "histogram", "value": 1}`, "have a count and sum instead of a value"},
+		{`{"name": "test", "type": "histogram", "count": 1, "sum": 1, "buckets": {"x": 1}}`, `invalid bucket upper bound "x"`},
+		{`{"name": "test", "type": "histogram", "count": 1, "sum": 1, "buckets": {"1": 2}}`, "more than the sample count"},
+		{`{"name": "test", "type": "histogram", "count": 1, "sum": 1, "quantiles": {"0.5": 1}}`, "quantiles are only allowed for summaries"},
+		{`{"name": "test", "type": "summary", "count": 1, "sum": 1, "quantiles": {"2": 1}}`, `invalid quantile "2"`},
+	}
+	for _, d := range testdata {
+		t.Run(d.json, func(t *testing.T) {
+			_, err := parseJSONMetrics(strings.NewReader(d.json))
+			if err == nil || !strings.Contains(err.Error(), d.err) {
+				t.Errorf("Expected error %q, got %v", d.err, err)
+			}
+		})
+	}
+}
+
+func TestTextFileJSONErrors(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"a_parse.json":     `{"name": "test_parse"}`,
+		"b_timestamp.json": `{"name": "test_timestamp", "value": 1, "timestamp_ms": 1500000000000}`,
+		"c_utf16.json":     encodeUTF16(`{"name": "test_utf16", "value": 1}`, binary.LittleEndian, true),
+	})
+	defer os.RemoveAll(dir)
+
+	out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}})
+	for _, expected := range []string{
+		`wmi_textfile_file_error{directory="` + dir + `",file="a_parse.json",reason="parse"} 1`,
+		`wmi_textfile_file_error{directory="` + dir + `",file="b_timestamp.json",reason="timestamp"} 1`,
+		"test_utf16 1\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, `file="c_utf16.json",reason=`) {
+		t.Errorf("Expected no error for c_utf16.json in output:\n%s", out)
+	}
+}
+
+func TestTextFilePathLabels(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"payments/api.prom":      "test_up 1\ntest_requests_total{handler=\"/\"} 5\n",
+		"payments/worker.prom":   "test_up{app=\"queue-worker\"} 1\n",
+		"search/indexer.prom":    "test_up{team=\"\"} 0\n",
+		"unmatched/nested/x.txt": "",
+		"toplevel.prom":          "test_toplevel 1\n",
+	})
+	defer os.RemoveAll(dir)
+	regex := `(?P<team>[^/]+)/(?P<app>[^.]+)\.prom`
+
+	cases := []struct {
+		name        string
+		sourceFile  bool
+		clashPolicy string
+		expected    []string
+	}{
+		{
+			name:        "keep",
+			clashPolicy: textFileLabelClashKeep,
+			expected: []string{
+				`test_requests_total{app="api",handler="/",team="payments"} 5`,
+				`test_up{app="api",team="payments"} 1`,
+				`test_up{app="indexer",team="search"} 0`,
+				`test_up{app="queue-worker",team="payments"} 1`,
+				`test_toplevel 1`,
+			},
+		},
+		{
+			name:        "overwrite",
+			clashPolicy: textFileLabelClashOverwrite,
+			expected: []string{
+				`test_up{app="worker",team="payments"} 1`,
+				`test_up{app="indexer",team="search"} 0`,
+			},
+		},
+		{
+			name:        "reject",
+			clashPolicy: textFileLabelClashReject,
+			expected: []string{
+				`test_up{app="api",team="payments"} 1`,
+				`test_up{app="indexer",team="search"} 0`,
+				`wmi_textfile_file_error{directory="` + dir + `",file="payments/worker.prom",reason="label_clash"} 1`,
+			},
+		},
+		{
+			name:        "source file",
+			sourceFile:  true,
+			clashPolicy: textFileLabelClashKeep,
+			expected: []string{
+				`test_up{app="api",source_file="` + filepath.Join(dir, "payments", "api.prom") + `",team="payments"} 1`,
+				`test_toplevel{source_file="` + filepath.Join(dir, "toplevel.prom") + `"} 1`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pathLabels, err := newTextFilePathLabels(regex, c.sourceFile, c.clashPolicy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}, recursive: true, pathLabels: pathLabels})
+			for _, line := range c.expected {
+				if !strings.Contains(out, line+"\n") {
+					t.Errorf("Expected %q in output:\n%s", line, out)
+				}
+			}
+			if c.clashPolicy == textFileLabelClashReject && strings.Contains(out, "queue-worker") {
+				t.Errorf("Expected file with clashing label to be rejected, output:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestNewTextFilePathLabelsInvalid(t *testing.T) {
+	cases := []struct {
+		regex      string
+		sourceFile bool
+	}{
+		{`(?P<team>[^/]+`, false},
+		{`(?P<__team>[^/]+)/.*`, false},
+		{`(?P<source_file>.*)`, true},
+	}
+	for _, c := range cases {
+		if _, err := newTextFilePathLabels(c.regex, c.sourceFile, textFileLabelClashKeep); err == nil {
+			t.Errorf("Expected an error for %q, but got ok", c.regex)
+		}
+	}
+
+	if l, err := newTextFilePathLabels("", false, textFileLabelClashKeep); err != nil || l != nil {
+		t.Errorf("Expected no path labels, got %v, %v", l, err)
+	}
+}
+
+func TestTextFileCache(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{"job.prom": "test_job 1\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "job.prom")
+	modTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &textFileCollector{directories: []string{dir}}
+	if out := gatherTextFiles(t, c); !strings.Contains(out, "test_job 1") {
+		t.Fatalf("Expected test_job 1 in output:\n%s", out)
+	}
+
+	// A file with the same modification time and size is not read again.
+	if err := ioutil.WriteFile(path, []byte("test_job 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if out := gatherTextFiles(t, c); !strings.Contains(out, "test_job 1") {
+		t.Errorf("Expected cached test_job 1 in output:\n%s", out)
+	}
+
+	// A change of either is.
+	if err := os.Chtimes(path, modTime.Add(time.Second), modTime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if out := gatherTextFiles(t, c); !strings.Contains(out, "test_job 2") {
+		t.Errorf("Expected test_job 2 after modification time change in output:\n%s", out)
+	}
+	if err := ioutil.WriteFile(path, []byte("test_job 30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime.Add(time.Second), modTime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if out := gatherTextFiles(t, c); !strings.Contains(out, "test_job 30") {
+		t.Errorf("Expected test_job 30 after size change in output:\n%s", out)
+	}
+
+	// Removed files are dropped from the cache.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	gatherTextFiles(t, c)
+	if len(c.cache) != 0 {
+		t.Errorf("Expected removed file to be dropped from the cache, got %v", c.cache)
+	}
+}
+
+func TestTextFileMerge(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"a.prom": `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{app="a"} 1
+# TYPE test_type gauge
+test_type 1
+test_a 1
+`,
+		"b.prom": `# HELP test_requests_total Other help text.
+# TYPE test_requests_total counter
+test_requests_total{app="b",handler="/"} 2
+`,
+		"c.prom": `# TYPE test_requests_total counter
+test_requests_total{app="c"} 3
+test_requests_total{app="a"} 4
+test_c 1
+`,
+		"d.prom": `# TYPE test_type counter
+test_type 2
+test_d 1
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name            string
+		rejectConflicts bool
+		expected        []string
+		missing         []string
+	}{
+		{
+			name: "first wins",
+			expected: []string{
+				"# HELP test_requests_total Requests served.\n# TYPE test_requests_total counter\n" +
+					`test_requests_total{app="a",handler=""} 1` + "\n" +
+					`test_requests_total{app="b",handler="/"} 2` + "\n" +
+					`test_requests_total{app="c",handler=""} 3` + "\n",
+				"# TYPE test_type gauge\ntest_type 1\n",
+				"test_c 1",
+				"test_d 1",
+				`file="c.prom",reason="duplicate_series"} 1`,
+				`file="d.prom",reason="conflicting_type"} 1`,
+				`wmi_textfile_mtime_seconds{directory="` + dir + `",file="d.prom"}`,
+			},
+			missing: []string{`app="a",handler=""} 4`, "test_type 2"},
+		},
+		{
+			name:            "reject",
+			rejectConflicts: true,
+			expected: []string{
+				"# HELP test_requests_total Requests served.\n# TYPE test_requests_total counter\n" +
+					`test_requests_total{app="a",handler=""} 1` + "\n" +
+					`test_requests_total{app="b",handler="/"} 2` + "\n",
+				"# TYPE test_type gauge\ntest_type 1\n",
+				`file="c.prom",reason="duplicate_series"} 1`,
+				`file="d.prom",reason="conflicting_type"} 1`,
+			},
+			missing: []string{"test_c", "test_d", `app="c"`, `wmi_textfile_mtime_seconds{directory="` + dir + `",file="d.prom"}`},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := gatherTextFiles(t, &textFileCollector{directories: []string{dir}, rejectConflicts: c.rejectConflicts})
+			for _, e := range c.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("Expected %s in output:\n%s", e, out)
+				}
+			}
+			for _, m := range c.missing {
+				if strings.Contains(out, m) {
+					t.Errorf("Unexpected %s in output:\n%s", m, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTextFileMergerDoesNotModifyInput(t *testing.T) {
+	parse := func(text string) []*dto.MetricFamily {
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*dto.MetricFamily{families["test"]}
+	}
+	a, b := parse("test{a=\"1\"} 1\n"), parse("test{b=\"2\"} 2\n")
+
+	m := newTextFileMerger(false)
+	for _, families := range [][]*dto.MetricFamily{a, b} {
+		if err := m.add(families); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result := m.result(); len(result) != 1 || len(result[0].Metric) != 2 {
+		t.Errorf("Expected one family with two metrics, got %v", result)
+	}
+	if len(a[0].Metric) != 1 || len(b[0].Metric) != 1 {
+		t.Errorf("Input families were modified: %v, %v", a, b)
+	}
+}
+
+func BenchmarkCRFilter(b *testing.B) {
+	data := []byte(strings.Repeat("test_metric{label=\"value\"} 1\r\n", 1000))
+	p := make([]byte, 4096)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cr := &carriageReturnFilteringReader{r: bytes.NewReader(data)}
+		for {
+			if _, err := cr.Read(p); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func benchmarkTextFileCollect(b *testing.B, cached bool) {
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		var content strings.Builder
+		for j := 0; j < 100; j++ {
+			fmt.Fprintf(&content, "test_metric_%d{instance=\"%d\"} %d\r\n", i, j, j)
+		}
+		files[fmt.Sprintf("job%d.prom", i)] = content.String()
+	}
+	dir, err := ioutil.TempDir("", "textfile")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	mtime := 1.0
+	newCollector := func() *textFileCollector {
+		return &textFileCollector{directories: []string{dir}, mtime: &mtime, now: time.Now}
+	}
+	c := newCollector()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			c = newCollector()
+		}
+		ch := make(chan prometheus.Metric)
+		go func() {
+			if err := c.Collect(nil, ch); err != nil {
+				b.Error(err)
+			}
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+}
+
+func BenchmarkTextFileCollect(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) { benchmarkTextFileCollect(b, false) })
+	b.Run("cached", func(b *testing.B) { benchmarkTextFileCollect(b, true) })
+}
@@ -0,0 +1,179 @@
+// +build !notextfile
+
+package collector
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// watchDirFunc starts watching dir, and its subdirectories if recursive is
+// set, calling changed whenever files in it may have changed. The returned
+// function stops watching.
+type watchDirFunc func(dir string, recursive bool, changed func()) (stop func(), err error)
+
+// textFileWatcher loads the text files of a collector in the background, so
+// that scrapes are served from memory. Files are loaded when a change is
+// reported for one of their directories, once no further change has been
+// reported for the settle delay, so that files being written or renamed into
+// place are only read once complete. All directories are also rescanned every
+// poll interval, which is the only way changes are detected if watchDir is nil
+// or a directory cannot be watched, e.g. on a network share.
+type textFileWatcher struct {
+	c            *textFileCollector
+	watchDir     watchDirFunc
+	pollInterval time.Duration
+	settleDelay  time.Duration
+
+	changes chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+
+	mu    sync.Mutex
+	files []textFile
+	err   error
+	// watched holds the function stopping the watch of each directory.
+	watched map[string]func()
+}
+
+func newTextFileWatcher(c *textFileCollector, watchDir watchDirFunc, pollInterval, settleDelay time.Duration) *textFileWatcher {
+	return &textFileWatcher{
+		c:            c,
+		watchDir:     watchDir,
+		pollInterval: pollInterval,
+		settleDelay:  settleDelay,
+		changes:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		watched:      make(map[string]func()),
+	}
+}
+
+// start loads the text files, and keeps them loaded until stop is called.
+func (w *textFileWatcher) start() {
+	w.load()
+	w.stopped.Add(1)
+	go w.run()
+}
+
+// stop stops watching and loading the text files.
+func (w *textFileWatcher) stop() {
+	close(w.done)
+	w.stopped.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir, stop := range w.watched {
+		stop()
+		delete(w.watched, dir)
+	}
+}
+
+// changed reports that files may have changed. It does not block, as changes
+// reported while a load is pending are covered by it.
+func (w *textFileWatcher) changed() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (w *textFileWatcher) run() {
+	defer w.stopped.Done()
+
+	poll := time.NewTicker(w.pollInterval)
+	defer poll.Stop()
+	settle := time.NewTimer(w.settleDelay)
+	settle.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			settle.Stop()
+			return
+		case <-w.changes:
+			// Every change restarts the settle delay.
+			if !settle.Stop() {
+				select {
+				case <-settle.C:
+				default:
+				}
+			}
+			settle.Reset(w.settleDelay)
+		case <-settle.C:
+			w.load()
+		case <-poll.C:
+			w.load()
+		}
+	}
+}
+
+// load lists and reads the text files, and updates the watched directories.
+func (w *textFileWatcher) load() {
+	files, err := findTextFiles(w.c.directories, w.c.recursive)
+	for _, f := range files {
+		// Reading the file stores its content in the collector's cache, so
+		// that the scrape does not need to read it.
+		w.c.readTextFile(f)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files, w.err = files, err
+	if w.watchDir != nil {
+		w.updateWatches(files)
+	}
+}
+
+// updateWatches watches the directories of the configured textfile
+// directories which exist, and of the files found, and stops watching the
+// others. w.mu must be held.
+func (w *textFileWatcher) updateWatches(files []textFile) {
+	dirs := map[string]bool{}
+	for _, f := range files {
+		dirs[f.directory] = true
+	}
+	for _, dir := range w.c.directories {
+		if !isGlobPattern(dir) && isDirectory(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	for dir, stop := range w.watched {
+		if !dirs[dir] {
+			log.Debugf("Stopping to watch textfile directory %q", dir)
+			stop()
+			delete(w.watched, dir)
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	for _, dir := range sorted {
+		if _, ok := w.watched[dir]; ok {
+			continue
+		}
+		stop, err := w.watchDir(dir, w.c.recursive, w.changed)
+		if err != nil {
+			log.Warnf("Cannot watch textfile directory %q for changes, polling it every %s: %v", dir, w.pollInterval, err)
+			// Polling covers the directory; don't try watching again on
+			// every load.
+			stop = func() {}
+		} else {
+			log.Debugf("Watching textfile directory %q", dir)
+		}
+		w.watched[dir] = stop
+	}
+}
+
+// textFiles returns the text files found by the last load.
+func (w *textFileWatcher) textFiles() ([]textFile, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.files, w.err
+}
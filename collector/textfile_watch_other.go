@@ -0,0 +1,11 @@
+// +build !windows,!notextfile
+
+package collector
+
+import "errors"
+
+// watchTextFileDirectory is not supported on this platform, so that the
+// directories are polled instead.
+func watchTextFileDirectory(dir string, recursive bool, changed func()) (func(), error) {
+	return nil, errors.New("directory change notifications are not supported on this platform")
+}
@@ -0,0 +1,168 @@
+package collector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDirWatcher records the watched directories, and reports changes in
+// them when triggered.
+type fakeDirWatcher struct {
+	mu      sync.Mutex
+	fail    bool
+	watched map[string]func()
+	stopped []string
+}
+
+func (f *fakeDirWatcher) watch(dir string, recursive bool, changed func()) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return nil, errors.New("not supported")
+	}
+	if f.watched == nil {
+		f.watched = make(map[string]func())
+	}
+	f.watched[dir] = changed
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.watched, dir)
+		f.stopped = append(f.stopped, dir)
+	}, nil
+}
+
+func (f *fakeDirWatcher) trigger(dir string) bool {
+	f.mu.Lock()
+	changed, ok := f.watched[dir]
+	f.mu.Unlock()
+	if ok {
+		changed()
+	}
+	return ok
+}
+
+// waitForOutput gathers the collector until the output contains s.
+func waitForOutput(t *testing.T, c *textFileCollector, s string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := gatherTextFiles(t, c)
+		if strings.Contains(out, s) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q in output:\n%s", s, out)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTextFileWatcherNotify(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{"a.prom": "test_a 1\n"})
+	defer os.RemoveAll(dir)
+
+	fake := &fakeDirWatcher{}
+	c := &textFileCollector{directories: []string{dir}}
+	c.watcher = newTextFileWatcher(c, fake.watch, time.Hour, 10*time.Millisecond)
+	c.watcher.start()
+
+	if out := gatherTextFiles(t, c); !strings.Contains(out, "test_a 1") {
+		t.Fatalf("Expected test_a in output:\n%s", out)
+	}
+
+	// Files are served from memory until a change is reported.
+	tmp := filepath.Join(dir, "b.prom.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("test_b 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "b.prom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.prom")); err != nil {
+		t.Fatal(err)
+	}
+	out := gatherTextFiles(t, c)
+	if !strings.Contains(out, "test_a 1") || strings.Contains(out, "test_b") {
+		t.Errorf("Expected files loaded before the change in output:\n%s", out)
+	}
+
+	if !fake.trigger(dir) {
+		t.Fatalf("Expected %q to be watched, got %v", dir, fake.watched)
+	}
+	waitForOutput(t, c, "test_b 1")
+	if out := gatherTextFiles(t, c); strings.Contains(out, "test_a") {
+		t.Errorf("Expected removed file to be dropped from output:\n%s", out)
+	}
+
+	c.watcher.stop()
+	if len(fake.stopped) != 1 || fake.stopped[0] != dir {
+		t.Errorf("Expected watch of %q to be stopped, got %v", dir, fake.stopped)
+	}
+}
+
+func TestTextFileWatcherWatchesDirectories(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{
+		"empty/.keep":      "",
+		"jobs/a/job.prom":  "test_a 1\n",
+		"jobs/b/job.prom":  "test_b 1\n",
+		"jobs/c/other.txt": "",
+	})
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing")
+
+	fake := &fakeDirWatcher{}
+	c := &textFileCollector{directories: []string{filepath.Join(dir, "empty"), filepath.Join(dir, "jobs", "*"), missing}}
+	w := newTextFileWatcher(c, fake.watch, time.Hour, time.Hour)
+	w.load()
+
+	expected := []string{filepath.Join(dir, "empty"), filepath.Join(dir, "jobs", "a"), filepath.Join(dir, "jobs", "b")}
+	if len(fake.watched) != len(expected) {
+		t.Errorf("Expected %v to be watched, got %v", expected, fake.watched)
+	}
+	for _, d := range expected {
+		if _, ok := fake.watched[d]; !ok {
+			t.Errorf("Expected %q to be watched, got %v", d, fake.watched)
+		}
+	}
+
+	// Directories no longer matched are not watched anymore.
+	if err := os.RemoveAll(filepath.Join(dir, "jobs", "b")); err != nil {
+		t.Fatal(err)
+	}
+	w.load()
+	if _, ok := fake.watched[filepath.Join(dir, "jobs", "b")]; ok {
+		t.Errorf("Expected removed directory to no longer be watched, got %v", fake.watched)
+	}
+	if files, _ := w.textFiles(); len(files) != 1 {
+		t.Errorf("Expected a single file to be loaded, got %v", files)
+	}
+}
+
+func TestTextFileWatcherPoll(t *testing.T) {
+	dir := writeTextFiles(t, map[string]string{"a.prom": "test_a 1\n"})
+	defer os.RemoveAll(dir)
+
+	// Directories which cannot be watched are polled.
+	for _, watchDir := range []watchDirFunc{nil, (&fakeDirWatcher{fail: true}).watch} {
+		c := &textFileCollector{directories: []string{dir}}
+		c.watcher = newTextFileWatcher(c, watchDir, 10*time.Millisecond, time.Hour)
+		c.watcher.start()
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.prom"), []byte("test_a 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitForOutput(t, c, "test_a 1")
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.prom"), []byte("test_a 22\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitForOutput(t, c, "test_a 22")
+		c.watcher.stop()
+	}
+}
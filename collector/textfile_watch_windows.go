@@ -0,0 +1,83 @@
+// +build windows,!notextfile
+
+package collector
+
+import (
+	"github.com/prometheus/common/log"
+	"golang.org/x/sys/windows"
+)
+
+// watchTextFileDirectory watches dir for changes using ReadDirectoryChangesW.
+// The directory is read asynchronously, so that stopping the watch can cancel
+// the pending read and wait for it to complete before closing the handles.
+func watchTextFileDirectory(dir string, recursive bool, changed func()) (func(), error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(
+		path,
+		windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	readEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	stopEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(readEvent)
+		windows.CloseHandle(h)
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer windows.CloseHandle(readEvent)
+		defer windows.CloseHandle(h)
+
+		// The content of the notifications is not needed, as all files are
+		// listed on change; a buffer overflow is reported as a change too.
+		buf := make([]byte, 4096)
+		mask := uint32(windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+			windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE)
+		for {
+			overlapped := windows.Overlapped{HEvent: readEvent}
+			err := windows.ReadDirectoryChanges(h, &buf[0], uint32(len(buf)), recursive, mask, nil, &overlapped, 0)
+			if err != nil && err != windows.ERROR_IO_PENDING {
+				log.Warnf("Error watching textfile directory %q, relying on polling: %v", dir, err)
+				return
+			}
+
+			event, err := windows.WaitForMultipleObjects([]windows.Handle{readEvent, stopEvent}, false, windows.INFINITE)
+			var n uint32
+			if err != nil || event != windows.WAIT_OBJECT_0 {
+				// Stopped. The buffer may only be released once the
+				// cancelled read has completed.
+				windows.CancelIoEx(h, &overlapped)
+				windows.GetOverlappedResult(h, &overlapped, &n, true)
+				return
+			}
+			if err := windows.GetOverlappedResult(h, &overlapped, &n, false); err != nil {
+				log.Warnf("Error watching textfile directory %q, relying on polling: %v", dir, err)
+				return
+			}
+			changed()
+		}
+	}()
+
+	return func() {
+		windows.SetEvent(stopEvent)
+		<-done
+		windows.CloseHandle(stopEvent)
+	}, nil
+}
@@ -1,7 +1,6 @@
 package collector
 
 import (
-	"bytes"
 	"reflect"
 
 	"github.com/prometheus/common/log"
@@ -17,47 +16,20 @@ func className(src interface{}) string {
 }
 
 func queryAll(src interface{}) string {
-	var b bytes.Buffer
-	b.WriteString("SELECT * FROM ")
-	b.WriteString(className(src))
-
-	log.Debugf("Generated WMI query %s", b.String())
-	return b.String()
+	return queryAllForClassWhere(src, className(src), "")
 }
 
 func queryAllForClass(src interface{}, class string) string {
-	var b bytes.Buffer
-	b.WriteString("SELECT * FROM ")
-	b.WriteString(class)
-
-	log.Debugf("Generated WMI query %s", b.String())
-	return b.String()
+	return queryAllForClassWhere(src, class, "")
 }
 
 func queryAllWhere(src interface{}, where string) string {
-	var b bytes.Buffer
-	b.WriteString("SELECT * FROM ")
-	b.WriteString(className(src))
-
-	if where != "" {
-		b.WriteString(" WHERE ")
-		b.WriteString(where)
-	}
-
-	log.Debugf("Generated WMI query %s", b.String())
-	return b.String()
+	return queryAllForClassWhere(src, className(src), where)
 }
 
 func queryAllForClassWhere(src interface{}, class string, where string) string {
-	var b bytes.Buffer
-	b.WriteString("SELECT * FROM ")
-	b.WriteString(class)
-
-	if where != "" {
-		b.WriteString(" WHERE ")
-		b.WriteString(where)
-	}
+	q := newWQLQuery(src).from(class).and(where).String()
 
-	log.Debugf("Generated WMI query %s", b.String())
-	return b.String()
+	log.Debugf("Generated WMI query %s", q)
+	return q
 }
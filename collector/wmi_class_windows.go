@@ -0,0 +1,75 @@
+// +build windows
+
+package collector
+
+import (
+	"runtime"
+
+	"github.com/StackExchange/wmi"
+	ole "github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+func init() {
+	wmiClassProperties = readWMIClassProperties
+}
+
+// readWMIClassProperties reads the names of the properties of class, in the
+// default namespace, from its SWbemObject definition.
+func readWMIClassProperties(class string) ([]string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil {
+		if code := err.(*ole.OleError).Code(); code != ole.S_OK && code != wmi.S_FALSE {
+			return nil, err
+		}
+	}
+	defer ole.CoUninitialize()
+
+	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+	if err != nil {
+		return nil, err
+	} else if unknown == nil {
+		return nil, wmi.ErrNilCreateObject
+	}
+	defer unknown.Release()
+
+	locator, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, err
+	}
+	defer locator.Release()
+
+	serviceRaw, err := oleutil.CallMethod(locator, "ConnectServer")
+	if err != nil {
+		return nil, err
+	}
+	defer serviceRaw.Clear()
+
+	// The SWbemObject of the class itself, not of an instance.
+	classRaw, err := oleutil.CallMethod(serviceRaw.ToIDispatch(), "Get", class)
+	if err != nil {
+		return nil, err
+	}
+	defer classRaw.Clear()
+
+	propertiesRaw, err := oleutil.GetProperty(classRaw.ToIDispatch(), "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer propertiesRaw.Clear()
+
+	var names []string
+	err = oleutil.ForEach(propertiesRaw.ToIDispatch(), func(v *ole.VARIANT) error {
+		defer v.Clear()
+		name, err := oleutil.GetProperty(v.ToIDispatch(), "Name")
+		if err != nil {
+			return err
+		}
+		defer name.Clear()
+		names = append(names, name.ToString())
+		return nil
+	})
+	return names, err
+}
@@ -39,47 +39,47 @@ func TestCreateQuery(t *testing.T) {
 			desc:      "queryAll on single instance",
 			dst:       fakeWmiClass{},
 			queryFunc: mapQueryAll,
-			expected:  "SELECT * FROM fakeWmiClass",
+			expected:  "SELECT Name, SomeProperty FROM fakeWmiClass",
 		},
 		{
 			desc:      "queryAll on slice",
 			dst:       []fakeWmiClass{},
 			queryFunc: mapQueryAll,
-			expected:  "SELECT * FROM fakeWmiClass",
+			expected:  "SELECT Name, SomeProperty FROM fakeWmiClass",
 		},
 		{
 			desc:      "queryAllWhere on single instance",
 			dst:       fakeWmiClass{},
 			where:     "foo = bar",
 			queryFunc: mapQueryAllWhere,
-			expected:  "SELECT * FROM fakeWmiClass WHERE foo = bar",
+			expected:  "SELECT Name, SomeProperty FROM fakeWmiClass WHERE foo = bar",
 		},
 		{
 			desc:      "queryAllWhere on slice",
 			dst:       []fakeWmiClass{},
 			where:     "foo = bar",
 			queryFunc: mapQueryAllWhere,
-			expected:  "SELECT * FROM fakeWmiClass WHERE foo = bar",
+			expected:  "SELECT Name, SomeProperty FROM fakeWmiClass WHERE foo = bar",
 		},
 		{
 			desc:      "queryAllWhere on single instance with empty where",
 			dst:       fakeWmiClass{},
 			queryFunc: mapQueryAllWhere,
-			expected:  "SELECT * FROM fakeWmiClass",
+			expected:  "SELECT Name, SomeProperty FROM fakeWmiClass",
 		},
 		{
 			desc:      "queryAllForClass on single instance",
 			dst:       fakeWmiClass{},
 			class:     "someClass",
 			queryFunc: mapQueryAllForClass,
-			expected:  "SELECT * FROM someClass",
+			expected:  "SELECT Name, SomeProperty FROM someClass",
 		},
 		{
 			desc:      "queryAllForClass on slice",
 			dst:       []fakeWmiClass{},
 			class:     "someClass",
 			queryFunc: mapQueryAllForClass,
-			expected:  "SELECT * FROM someClass",
+			expected:  "SELECT Name, SomeProperty FROM someClass",
 		},
 		{
 			desc:      "queryAllForClassWhere on single instance",
@@ -87,7 +87,7 @@ func TestCreateQuery(t *testing.T) {
 			class:     "someClass",
 			where:     "foo = bar",
 			queryFunc: mapQueryAllForClassWhere,
-			expected:  "SELECT * FROM someClass WHERE foo = bar",
+			expected:  "SELECT Name, SomeProperty FROM someClass WHERE foo = bar",
 		},
 		{
 			desc:      "queryAllForClassWhere on slice",
@@ -95,14 +95,14 @@ func TestCreateQuery(t *testing.T) {
 			class:     "someClass",
 			where:     "foo = bar",
 			queryFunc: mapQueryAllForClassWhere,
-			expected:  "SELECT * FROM someClass WHERE foo = bar",
+			expected:  "SELECT Name, SomeProperty FROM someClass WHERE foo = bar",
 		},
 		{
 			desc:      "queryAllForClassWhere on single instance with empty where",
 			dst:       fakeWmiClass{},
 			class:     "someClass",
 			queryFunc: mapQueryAllForClassWhere,
-			expected:  "SELECT * FROM someClass",
+			expected:  "SELECT Name, SomeProperty FROM someClass",
 		},
 	}
 	for _, c := range cases {
@@ -0,0 +1,296 @@
+package collector
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	wmiQueriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_wmi_queries"),
+		"wmi_exporter: Number of WMI queries run by the collector during the scrape.",
+		[]string{"collector"},
+		nil,
+	)
+	wmiQueryDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_wmi_query_duration_seconds"),
+		"wmi_exporter: Total duration of the WMI queries run by the collector during the scrape.",
+		[]string{"collector"},
+		nil,
+	)
+	wmiCacheHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "exporter", "collector_wmi_cache_hits"),
+		"wmi_exporter: Number of WMI queries of the collector answered by a query already run during the scrape.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// wmiQueryFunc runs a WMI query in namespace, or in the default namespace if
+// it is empty, and loads the result into dst, a pointer to a slice of structs.
+type wmiQueryFunc func(query string, dst interface{}, namespace string) error
+
+// wmiClassKey identifies the instances returned by a query: the class, the
+// namespace and the where clause. Queries with the same key only differ in
+// the properties they select.
+type wmiClassKey struct {
+	namespace string
+	class     string
+	where     string
+}
+
+type wmiQueryResult struct {
+	// properties selected by the query, lower cased. nil for SELECT *.
+	properties map[string]struct{}
+	typ        reflect.Type
+
+	done  chan struct{}
+	value reflect.Value
+	err   error
+}
+
+// covers reports whether the result holds all of properties, loaded into
+// fields of the same type as those of the struct type typ.
+func (r *wmiQueryResult) covers(properties []string, typ reflect.Type) bool {
+	if r.typ != typ && (typ.Elem().Kind() != reflect.Struct || r.typ.Elem().Kind() != reflect.Struct) {
+		return false
+	}
+	if r.properties == nil {
+		return properties == nil && r.typ == typ
+	}
+	if properties == nil {
+		return false
+	}
+	for _, p := range properties {
+		if _, ok := r.properties[strings.ToLower(p)]; !ok {
+			return false
+		}
+		dst, ok := fieldByNameFold(typ.Elem(), p)
+		if !ok || dst.PkgPath != "" {
+			continue
+		}
+		src, ok := fieldByNameFold(r.typ.Elem(), p)
+		if !ok || src.Type != dst.Type {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldByNameFold(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	return t.FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, name) })
+}
+
+type wmiQueryStats struct {
+	queries   int
+	duration  time.Duration
+	cacheHits int
+}
+
+// wmiQueryCache shares the results of WMI queries between the collectors of a
+// single scrape. A query is answered by an earlier query for the same class,
+// namespace and where clause that selected all of its properties, even if
+// that query loaded them into a different struct. A query run while such a
+// query is still in flight waits for its result instead of running again.
+// Failed queries are cached as well.
+//
+// Results are shared without copying, so collectors must not modify them.
+type wmiQueryCache struct {
+	query wmiQueryFunc
+
+	mu      sync.Mutex
+	results map[wmiClassKey][]*wmiQueryResult
+	stats   map[string]*wmiQueryStats
+}
+
+func newWMIQueryCache(query wmiQueryFunc) *wmiQueryCache {
+	return &wmiQueryCache{
+		query:   query,
+		results: make(map[wmiClassKey][]*wmiQueryResult),
+		stats:   make(map[string]*wmiQueryStats),
+	}
+}
+
+// statsFor returns the statistics of collector. c.mu must be held.
+func (c *wmiQueryCache) statsFor(collector string) *wmiQueryStats {
+	s, ok := c.stats[collector]
+	if !ok {
+		s = &wmiQueryStats{}
+		c.stats[collector] = s
+	}
+	return s
+}
+
+func (c *wmiQueryCache) run(collector, namespace, query string, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("WMI query destination must be a pointer to a slice, got %T", dst)
+	}
+	typ := rv.Elem().Type()
+
+	class, properties, where, ok := parseWQLSelect(query)
+	key := wmiClassKey{
+		namespace: strings.ToLower(namespace),
+		class:     strings.ToLower(class),
+		where:     where,
+	}
+
+	c.mu.Lock()
+	stats := c.statsFor(collector)
+	var r *wmiQueryResult
+	if ok {
+		for _, cached := range c.results[key] {
+			if cached.covers(properties, typ) {
+				r = cached
+				break
+			}
+		}
+	}
+	if r != nil {
+		stats.cacheHits++
+		c.mu.Unlock()
+
+		<-r.done
+		if r.err != nil {
+			return r.err
+		}
+		rv.Elem().Set(convertWMIResult(r.value, typ))
+		return nil
+	}
+
+	r = &wmiQueryResult{typ: typ, done: make(chan struct{})}
+	if ok {
+		if properties != nil {
+			r.properties = make(map[string]struct{}, len(properties))
+			for _, p := range properties {
+				r.properties[strings.ToLower(p)] = struct{}{}
+			}
+		}
+		c.results[key] = append(c.results[key], r)
+	}
+	c.mu.Unlock()
+
+	// The query loads into dst directly. It must not append to a slice a
+	// previous result may still share.
+	rv.Elem().Set(reflect.Zero(typ))
+	begin := time.Now()
+	r.err = c.query(query, dst, namespace)
+	r.value = rv.Elem()
+	duration := time.Since(begin)
+	close(r.done)
+
+	c.mu.Lock()
+	stats.queries++
+	stats.duration += duration
+	c.mu.Unlock()
+
+	return r.err
+}
+
+// convertWMIResult returns the slice of structs src as a slice of type typ,
+// copying the fields by name. src is returned as is if it already has the
+// requested type.
+func convertWMIResult(src reflect.Value, typ reflect.Type) reflect.Value {
+	if src.Type() == typ {
+		return src
+	}
+
+	elem := typ.Elem()
+	fields := make([][]int, elem.NumField())
+	for i := range fields {
+		if elem.Field(i).PkgPath != "" {
+			continue
+		}
+		if f, ok := fieldByNameFold(src.Type().Elem(), elem.Field(i).Name); ok && f.Type == elem.Field(i).Type {
+			fields[i] = f.Index
+		}
+	}
+
+	out := reflect.MakeSlice(typ, src.Len(), src.Len())
+	for i := 0; i < src.Len(); i++ {
+		for j, index := range fields {
+			if index != nil {
+				out.Index(i).Field(j).Set(src.Index(i).FieldByIndex(index))
+			}
+		}
+	}
+	return out
+}
+
+// ForCollector returns a scrape context for the named collector. It shares
+// all state with ctx, but WMI queries run through it are attributed to the
+// collector.
+func (ctx *ScrapeContext) ForCollector(name string) *ScrapeContext {
+	c := *ctx
+	c.collector = name
+	if ctx.wmiQueries != nil {
+		ctx.wmiQueries.mu.Lock()
+		ctx.wmiQueries.statsFor(name)
+		ctx.wmiQueries.mu.Unlock()
+	}
+	return &c
+}
+
+// wmiQuery runs query in the default namespace, reusing the result of a query
+// for the same class already run during the scrape.
+func (ctx *ScrapeContext) wmiQuery(query string, dst interface{}) error {
+	return ctx.wmiQueryNamespace(query, dst, "")
+}
+
+// wmiQueryNamespace runs query in namespace, reusing the result of a query for
+// the same class already run during the scrape.
+func (ctx *ScrapeContext) wmiQueryNamespace(query string, dst interface{}, namespace string) error {
+	if ctx.wmiQueries == nil {
+		return fmt.Errorf("WMI queries are not available in this scrape context")
+	}
+	return ctx.wmiQueries.run(ctx.collector, namespace, query, dst)
+}
+
+// CollectWMIQueryStats sends the number and duration of the WMI queries run
+// by each collector during the scrape to the provided prometheus Metric
+// channel.
+func (ctx *ScrapeContext) CollectWMIQueryStats(ch chan<- prometheus.Metric) {
+	if ctx.wmiQueries == nil {
+		return
+	}
+
+	ctx.wmiQueries.mu.Lock()
+	defer ctx.wmiQueries.mu.Unlock()
+
+	names := make([]string, 0, len(ctx.wmiQueries.stats))
+	for name := range ctx.wmiQueries.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := ctx.wmiQueries.stats[name]
+		ch <- prometheus.MustNewConstMetric(
+			wmiQueriesDesc,
+			prometheus.GaugeValue,
+			float64(s.queries),
+			name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			wmiQueryDurationDesc,
+			prometheus.GaugeValue,
+			s.duration.Seconds(),
+			name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			wmiCacheHitsDesc,
+			prometheus.GaugeValue,
+			float64(s.cacheHits),
+			name,
+		)
+	}
+}
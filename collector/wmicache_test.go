@@ -0,0 +1,232 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type otherFakeWmiClass struct {
+	Name string
+}
+
+// fakeWMI answers every query with a single row named after the query and
+// namespace, counting how often each query was run.
+type fakeWMI struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	fail    bool
+	release chan struct{}
+}
+
+func (f *fakeWMI) query(query string, dst interface{}, namespace string) error {
+	if f.release != nil {
+		<-f.release
+	}
+
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[namespace+":"+query]++
+	f.mu.Unlock()
+
+	if f.fail {
+		return errors.New("query failed")
+	}
+	rv := reflect.ValueOf(dst).Elem()
+	row := reflect.New(rv.Type().Elem()).Elem()
+	row.FieldByName("Name").SetString(namespace + ":" + query)
+	rv.Set(reflect.Append(rv, row))
+	return nil
+}
+
+func TestWMIQueryCacheSharesResults(t *testing.T) {
+	wmi := &fakeWMI{}
+	ctx := newScrapeContext(nil, wmi.query)
+	process, iis := ctx.ForCollector("process"), ctx.ForCollector("iis")
+
+	var a, b []fakeWmiClass
+	if err := process.wmiQuery("SELECT Name, SomeProperty FROM Win32_Process", &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := iis.wmiQuery("SELECT Name, SomeProperty FROM Win32_Process", &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) || len(a) != 1 {
+		t.Errorf("Expected identical results, got %v and %v", a, b)
+	}
+
+	// A query selecting a subset of the properties of the same class is
+	// answered from the cache, even if it loads them into another struct.
+	var c []otherFakeWmiClass
+	if err := iis.wmiQuery("select name from win32_process", &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 1 || c[0].Name != a[0].Name {
+		t.Errorf("Expected result converted from %v, got %v", a, c)
+	}
+
+	// Another namespace, where clause or property, or SELECT *, runs a new
+	// query.
+	queries := []struct {
+		query, namespace string
+	}{
+		{"SELECT Name FROM Win32_Process", `root\WebAdministration`},
+		{"SELECT Name FROM Win32_Process WHERE Name = 'foo'", ""},
+		{"SELECT Name, Other FROM Win32_Process", ""},
+		{"SELECT * FROM Win32_Process", ""},
+	}
+	for _, q := range queries {
+		var dst []otherFakeWmiClass
+		if err := iis.wmiQueryNamespace(q.query, &dst, q.namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]int{
+		":SELECT Name, SomeProperty FROM Win32_Process":         1,
+		`root\WebAdministration:SELECT Name FROM Win32_Process`: 1,
+		":SELECT Name FROM Win32_Process WHERE Name = 'foo'":    1,
+		":SELECT Name, Other FROM Win32_Process":                1,
+		":SELECT * FROM Win32_Process":                          1,
+	}
+	if !reflect.DeepEqual(wmi.calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, wmi.calls)
+	}
+
+	// A new scrape does not reuse results.
+	var f []fakeWmiClass
+	if err := newScrapeContext(nil, wmi.query).wmiQuery("SELECT Name, SomeProperty FROM Win32_Process", &f); err != nil {
+		t.Fatal(err)
+	}
+	if wmi.calls[":SELECT Name, SomeProperty FROM Win32_Process"] != 2 {
+		t.Errorf("Expected a new scrape to run the query again, got %v", wmi.calls)
+	}
+}
+
+func TestParseWQLSelect(t *testing.T) {
+	cases := []struct {
+		query      string
+		class      string
+		properties []string
+		where      string
+		ok         bool
+	}{
+		{"SELECT Name FROM Win32_Service", "Win32_Service", []string{"Name"}, "", true},
+		{"select Name,State from Win32_Service where State = 'Running'", "Win32_Service", []string{"Name", "State"}, "State = 'Running'", true},
+		{"SELECT * FROM Win32_Process", "Win32_Process", nil, "", true},
+		{"SELECT COUNT(*) FROM Win32_Process", "", nil, "", false},
+		{"ASSOCIATORS OF {Win32_Service.Name='foo'}", "", nil, "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			class, properties, where, ok := parseWQLSelect(c.query)
+			if class != c.class || !reflect.DeepEqual(properties, c.properties) || where != c.where || ok != c.ok {
+				t.Errorf("Expected %q %v %q %v, got %q %v %q %v", c.class, c.properties, c.where, c.ok, class, properties, where, ok)
+			}
+		})
+	}
+}
+
+func TestWMIQueryCacheConcurrent(t *testing.T) {
+	wmi := &fakeWMI{release: make(chan struct{})}
+	ctx := newScrapeContext(nil, wmi.query)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(ctx *ScrapeContext) {
+			defer wg.Done()
+			var dst []fakeWmiClass
+			if err := ctx.wmiQuery("SELECT Name FROM Win32_Service", &dst); err != nil {
+				errs <- err
+			} else if len(dst) != 1 {
+				errs <- errors.New("unexpected result length")
+			}
+		}(ctx.ForCollector("service"))
+	}
+	close(wmi.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if wmi.calls[":SELECT Name FROM Win32_Service"] != 1 {
+		t.Errorf("Expected concurrent identical queries to run once, got %v", wmi.calls)
+	}
+}
+
+func TestWMIQueryCacheErrors(t *testing.T) {
+	wmi := &fakeWMI{fail: true}
+	ctx := newScrapeContext(nil, wmi.query).ForCollector("os")
+
+	for i := 0; i < 2; i++ {
+		var dst []fakeWmiClass
+		if err := ctx.wmiQuery("SELECT Name FROM Win32_OperatingSystem", &dst); err == nil {
+			t.Error("Expected an error for failing query, but got ok")
+		}
+	}
+	if wmi.calls[":SELECT Name FROM Win32_OperatingSystem"] != 1 {
+		t.Errorf("Expected failing query to run once, got %v", wmi.calls)
+	}
+
+	var dst fakeWmiClass
+	if err := ctx.wmiQuery("SELECT Name FROM Win32_OperatingSystem", &dst); err == nil {
+		t.Error("Expected an error for a destination that is not a slice, but got ok")
+	}
+}
+
+func TestCollectWMIQueryStats(t *testing.T) {
+	wmi := &fakeWMI{}
+	ctx := newScrapeContext(nil, wmi.query)
+	cs, os := ctx.ForCollector("cs"), ctx.ForCollector("os")
+	ctx.ForCollector("cpu")
+
+	var dst []fakeWmiClass
+	for _, q := range []string{"SELECT Name FROM A", "SELECT Name FROM B"} {
+		if err := cs.wmiQuery(q, &dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.wmiQuery("SELECT Name FROM A", &dst); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric, 20)
+	ctx.CollectWMIQueryStats(ch)
+	close(ch)
+
+	type stats struct{ queries, hits float64 }
+	got := map[string]stats{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		name := pb.GetLabel()[0].GetValue()
+		s := got[name]
+		switch m.Desc() {
+		case wmiQueriesDesc:
+			s.queries = pb.GetGauge().GetValue()
+		case wmiCacheHitsDesc:
+			s.hits = pb.GetGauge().GetValue()
+		}
+		got[name] = s
+	}
+
+	expected := map[string]stats{
+		"cs":  {queries: 2},
+		"os":  {hits: 1},
+		"cpu": {},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
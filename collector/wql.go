@@ -0,0 +1,469 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+type wqlTokenKind int
+
+const (
+	wqlEOF wqlTokenKind = iota
+	wqlIdent
+	wqlString
+	wqlNumber
+	wqlOperator
+	wqlLParen
+	wqlRParen
+)
+
+type wqlToken struct {
+	kind  wqlTokenKind
+	value string
+	pos   int
+}
+
+func (t wqlToken) String() string {
+	switch t.kind {
+	case wqlEOF:
+		return "end of clause"
+	case wqlString:
+		return strconv.Quote(t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// keyword reports whether the token is the given (case insensitive) keyword.
+func (t wqlToken) keyword(kw string) bool {
+	return t.kind == wqlIdent && strings.EqualFold(t.value, kw)
+}
+
+var wqlKeywords = []string{"AND", "OR", "NOT", "LIKE", "IS", "ISA", "NULL", "TRUE", "FALSE"}
+
+func isWQLKeyword(s string) bool {
+	for _, kw := range wqlKeywords {
+		if strings.EqualFold(s, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// lexWQL splits a WQL where clause into tokens. String literals are returned
+// unquoted and unescaped.
+func lexWQL(s string) ([]wqlToken, error) {
+	var tokens []wqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, wqlToken{wqlLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, wqlToken{wqlRParen, ")", i})
+			i++
+		case c == '=':
+			tokens = append(tokens, wqlToken{wqlOperator, "=", i})
+			i++
+		case c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				op += string(s[i+1])
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected character '!' at position %d", i)
+			}
+			tokens = append(tokens, wqlToken{wqlOperator, op, i})
+			i += len(op)
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", i)
+			}
+			tokens = append(tokens, wqlToken{wqlString, b.String(), i})
+			i = j + 1
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (isWQLIdentChar(s[j]) || s[j] == '.') {
+				j++
+			}
+			if !isWQLNumber(s[i:j]) {
+				return nil, fmt.Errorf("invalid number %q at position %d", s[i:j], i)
+			}
+			tokens = append(tokens, wqlToken{wqlNumber, s[i:j], i})
+			i = j
+		case isWQLIdentChar(c):
+			j := i + 1
+			for j < len(s) && isWQLIdentChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, wqlToken{wqlIdent, s[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, wqlToken{wqlEOF, "", len(s)}), nil
+}
+
+func isWQLIdentChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isWQLNumber(s string) bool {
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// wqlPredicate is a single comparison of a where clause, such as
+// Name LIKE 'svc%' or State <> 'Running'.
+type wqlPredicate struct {
+	property string
+	operator string
+	value    string
+}
+
+type wqlParser struct {
+	tokens     []wqlToken
+	pos        int
+	predicates []wqlPredicate
+}
+
+// parseWQLWhere parses a WQL where clause (without the WHERE keyword) and
+// returns the predicates it consists of.
+func parseWQLWhere(where string) ([]wqlPredicate, error) {
+	tokens, err := lexWQL(where)
+	if err != nil {
+		return nil, err
+	}
+	p := &wqlParser{tokens: tokens}
+	if err := p.parseOr(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != wqlEOF {
+		return nil, p.unexpected(t)
+	}
+	return p.predicates, nil
+}
+
+func (p *wqlParser) peek() wqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *wqlParser) next() wqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != wqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *wqlParser) unexpected(t wqlToken) error {
+	return fmt.Errorf("unexpected %s at position %d", t, t.pos)
+}
+
+func (p *wqlParser) parseOr() error {
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+	for p.peek().keyword("OR") {
+		p.next()
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *wqlParser) parseAnd() error {
+	if err := p.parseNot(); err != nil {
+		return err
+	}
+	for p.peek().keyword("AND") {
+		p.next()
+		if err := p.parseNot(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *wqlParser) parseNot() error {
+	if p.peek().keyword("NOT") {
+		p.next()
+		return p.parseNot()
+	}
+	if p.peek().kind == wqlLParen {
+		p.next()
+		if err := p.parseOr(); err != nil {
+			return err
+		}
+		if t := p.next(); t.kind != wqlRParen {
+			return p.unexpected(t)
+		}
+		return nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *wqlParser) parsePredicate() error {
+	t := p.next()
+
+	// A constant on the left hand side, e.g. 'foo' = Name.
+	if isWQLConstant(t) {
+		op := p.next()
+		if op.kind != wqlOperator {
+			return p.unexpected(op)
+		}
+		prop := p.next()
+		if !isWQLProperty(prop) {
+			return p.unexpected(prop)
+		}
+		p.predicates = append(p.predicates, wqlPredicate{prop.value, op.value, t.value})
+		return nil
+	}
+
+	if !isWQLProperty(t) {
+		return p.unexpected(t)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == wqlOperator:
+		v := p.next()
+		if !isWQLConstant(v) {
+			return p.unexpected(v)
+		}
+		p.predicates = append(p.predicates, wqlPredicate{t.value, op.value, v.value})
+	case op.keyword("LIKE") || op.keyword("ISA"):
+		v := p.next()
+		if v.kind != wqlString {
+			return p.unexpected(v)
+		}
+		p.predicates = append(p.predicates, wqlPredicate{t.value, strings.ToUpper(op.value), v.value})
+	case op.keyword("NOT"):
+		like := p.next()
+		if !like.keyword("LIKE") {
+			return p.unexpected(like)
+		}
+		v := p.next()
+		if v.kind != wqlString {
+			return p.unexpected(v)
+		}
+		p.predicates = append(p.predicates, wqlPredicate{t.value, "NOT LIKE", v.value})
+	case op.keyword("IS"):
+		operator := "IS"
+		if p.peek().keyword("NOT") {
+			p.next()
+			operator = "IS NOT"
+		}
+		if v := p.next(); !v.keyword("NULL") {
+			return p.unexpected(v)
+		}
+		p.predicates = append(p.predicates, wqlPredicate{t.value, operator, "NULL"})
+	default:
+		return p.unexpected(op)
+	}
+	return nil
+}
+
+func isWQLProperty(t wqlToken) bool {
+	return t.kind == wqlIdent && !isWQLKeyword(t.value)
+}
+
+func isWQLConstant(t wqlToken) bool {
+	return t.kind == wqlString || t.kind == wqlNumber ||
+		t.keyword("TRUE") || t.keyword("FALSE") || t.keyword("NULL")
+}
+
+// structProperties returns the names of the fields of the struct (or slice of
+// structs) src, which are the WMI properties loaded into it.
+func structProperties(src interface{}) []string {
+	t := reflect.Indirect(reflect.ValueOf(src)).Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
+
+// wmiClassProperties reads the names of the properties of a WMI class from
+// its definition. It is nil on platforms without WMI.
+var wmiClassProperties func(class string) ([]string, error)
+
+// validateWhereClause checks the syntax of a user supplied where clause, and
+// that it only refers to properties of the class loaded into src. The
+// properties are read from the class definition, so the clause may use
+// properties the exporter does not load; if the definition cannot be read,
+// the fields of src are used instead. System properties such as __CLASS are
+// always allowed.
+func validateWhereClause(src interface{}, where string) error {
+	if strings.TrimSpace(where) == "" {
+		return nil
+	}
+
+	predicates, err := parseWQLWhere(where)
+	if err != nil {
+		return fmt.Errorf("invalid where clause %q: %v", where, err)
+	}
+
+	class := className(src)
+	properties := structProperties(src)
+	if wmiClassProperties != nil {
+		if p, err := wmiClassProperties(class); err == nil {
+			properties = p
+		} else {
+			log.Debugf("Could not read the definition of %s, checking the where clause against the loaded properties: %v", class, err)
+		}
+	}
+	for _, p := range predicates {
+		if strings.HasPrefix(p.property, "__") || containsFold(properties, p.property) {
+			continue
+		}
+		sorted := append([]string{}, properties...)
+		sort.Strings(sorted)
+		return fmt.Errorf("invalid where clause %q: unknown property %q of %s, known properties are %s",
+			where, p.property, class, strings.Join(sorted, ", "))
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var wqlSelectPattern = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+(\w+)(?:\s+WHERE\s+(.+?))?\s*$`)
+
+// parseWQLSelect splits a SELECT query into its class, selected properties
+// and where clause. A nil properties list stands for SELECT *.
+func parseWQLSelect(query string) (class string, properties []string, where string, ok bool) {
+	m := wqlSelectPattern.FindStringSubmatch(query)
+	if m == nil {
+		return "", nil, "", false
+	}
+	if strings.TrimSpace(m[1]) != "*" {
+		for _, p := range strings.Split(m[1], ",") {
+			p = strings.TrimSpace(p)
+			if p == "" || strings.ContainsAny(p, " \t()*") {
+				return "", nil, "", false
+			}
+			properties = append(properties, p)
+		}
+	}
+	return m[2], properties, m[3], true
+}
+
+// wqlLiteral formats a value as a WQL constant, quoting and escaping strings.
+func wqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+		return "'" + r.Replace(v) + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case nil:
+		return "NULL"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// wqlCompare builds a predicate comparing property to value with one of the
+// WQL operators (=, <>, <, >, <=, >=, LIKE, NOT LIKE).
+func wqlCompare(property, operator string, value interface{}) string {
+	return fmt.Sprintf("%s %s %s", property, operator, wqlLiteral(value))
+}
+
+// wqlQuery builds a WQL SELECT query for the class loaded into a struct.
+// Only the properties matching the struct's fields are selected.
+type wqlQuery struct {
+	class      string
+	properties []string
+	where      []string
+}
+
+func newWQLQuery(src interface{}) *wqlQuery {
+	return &wqlQuery{
+		class:      className(src),
+		properties: structProperties(src),
+	}
+}
+
+// from overrides the class name derived from the struct.
+func (q *wqlQuery) from(class string) *wqlQuery {
+	q.class = class
+	return q
+}
+
+// and adds a where clause. Multiple clauses are combined with AND.
+func (q *wqlQuery) and(where string) *wqlQuery {
+	if strings.TrimSpace(where) != "" {
+		q.where = append(q.where, where)
+	}
+	return q
+}
+
+func (q *wqlQuery) String() string {
+	var b bytes.Buffer
+	b.WriteString("SELECT ")
+	if len(q.properties) == 0 {
+		b.WriteString("*")
+	} else {
+		b.WriteString(strings.Join(q.properties, ", "))
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(q.class)
+
+	for i, w := range q.where {
+		if i == 0 {
+			b.WriteString(" WHERE ")
+		} else {
+			b.WriteString(" AND ")
+		}
+		if len(q.where) > 1 {
+			b.WriteString("(" + w + ")")
+		} else {
+			b.WriteString(w)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseWQLWhere(t *testing.T) {
+	cases := []struct {
+		where    string
+		expected []wqlPredicate
+	}{
+		{
+			where:    "Name = 'firefox'",
+			expected: []wqlPredicate{{"Name", "=", "firefox"}},
+		},
+		{
+			where:    `Name LIKE "svc%" and not State <> 'Running'`,
+			expected: []wqlPredicate{{"Name", "LIKE", "svc%"}, {"State", "<>", "Running"}},
+		},
+		{
+			where:    "(IDProcess > 4 OR IDProcess <= 0x10) AND Name NOT LIKE 'w3wp%'",
+			expected: []wqlPredicate{{"IDProcess", ">", "4"}, {"IDProcess", "<=", "0x10"}, {"Name", "NOT LIKE", "w3wp%"}},
+		},
+		{
+			where:    "'wuauserv' = Name",
+			expected: []wqlPredicate{{"Name", "=", "wuauserv"}},
+		},
+		{
+			where:    "Name IS NOT NULL AND Started = TRUE AND Priority != -1.5",
+			expected: []wqlPredicate{{"Name", "IS NOT", "NULL"}, {"Started", "=", "TRUE"}, {"Priority", "!=", "-1.5"}},
+		},
+		{
+			where:    `Name = 'it\'s a \\ test'`,
+			expected: []wqlPredicate{{"Name", "=", `it's a \ test`}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.where, func(t *testing.T) {
+			predicates, err := parseWQLWhere(c.where)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(predicates, c.expected) {
+				t.Errorf("Expected %v, got %v", c.expected, predicates)
+			}
+		})
+	}
+}
+
+func TestParseWQLWhereInvalid(t *testing.T) {
+	cases := []string{
+		"Name = 'firefox",
+		"Name == 'firefox'",
+		"Name = firefox",
+		"Name 'firefox'",
+		"Name LIKE 5",
+		"(Name = 'a'",
+		"Name = 'a')",
+		"Name = 'a' AND",
+		"Name = 'a' OR OR State = 'b'",
+		"Name IS 'a'",
+		"AND = 'a'",
+		"Name = 1a",
+		"Name ! 'a'",
+		"Name = 'a'; DROP",
+		"Name = 'a' State = 'b'",
+	}
+	for _, where := range cases {
+		t.Run(where, func(t *testing.T) {
+			if _, err := parseWQLWhere(where); err == nil {
+				t.Errorf("Expected an error for %q, but got ok", where)
+			}
+		})
+	}
+}
+
+func TestValidateWhereClause(t *testing.T) {
+	cases := []struct {
+		where string
+		valid bool
+	}{
+		{"", true},
+		{"  ", true},
+		{"Name = 'foo'", true},
+		{"name = 'foo' AND someproperty > 3", true},
+		{"__CLASS = 'fakeWmiClass'", true},
+		{"Nmae = 'foo'", false},
+		{"Name = 'foo' OR Other = 1", false},
+		{"Name = ", false},
+		{"Name = 'foo' State = 'bar'", false},
+	}
+	for _, c := range cases {
+		t.Run(c.where, func(t *testing.T) {
+			err := validateWhereClause(&[]fakeWmiClass{}, c.where)
+			if c.valid && err != nil {
+				t.Errorf("Expected %q to be valid, got %v", c.where, err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Expected an error for %q, but got ok", c.where)
+			}
+		})
+	}
+}
+
+func TestValidateWhereClauseClassDefinition(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { wmiClassProperties = f }(wmiClassProperties)
+
+	// Properties of the class not loaded by the exporter are allowed.
+	wmiClassProperties = func(class string) ([]string, error) {
+		if class != "fakeWmiClass" {
+			return nil, errors.New("unexpected class " + class)
+		}
+		return []string{"Name", "SomeProperty", "PathName"}, nil
+	}
+	if err := validateWhereClause(&[]fakeWmiClass{}, "PathName LIKE '%foo%'"); err != nil {
+		t.Errorf("Expected a property of the class definition to be valid, got %v", err)
+	}
+	if err := validateWhereClause(&[]fakeWmiClass{}, "Nmae = 'foo'"); err == nil {
+		t.Error("Expected an error for an unknown property, but got ok")
+	}
+
+	// Without the definition, the fields of the struct are used.
+	wmiClassProperties = func(class string) ([]string, error) {
+		return nil, errors.New("access denied")
+	}
+	if err := validateWhereClause(&[]fakeWmiClass{}, "SomeProperty > 1"); err != nil {
+		t.Errorf("Expected a loaded property to be valid, got %v", err)
+	}
+	if err := validateWhereClause(&[]fakeWmiClass{}, "PathName LIKE '%foo%'"); err == nil {
+		t.Error("Expected an error for a property that is not loaded, but got ok")
+	}
+}
+
+func TestWQLLiteral(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"foo", "'foo'"},
+		{`it's`, `'it\'s'`},
+		{`C:\Windows`, `'C:\\Windows'`},
+		{true, "TRUE"},
+		{false, "FALSE"},
+		{nil, "NULL"},
+		{42, "42"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{-1.5, "-1.5"},
+	}
+	for _, c := range cases {
+		if got := wqlLiteral(c.value); got != c.expected {
+			t.Errorf("For %#v expected %s, got %s", c.value, c.expected, got)
+		}
+	}
+}
+
+func TestWQLCompareRoundTrip(t *testing.T) {
+	for _, value := range []string{"", "plain", `it's`, `back\slash`, `\'`, `' OR Name LIKE '%`, `"quoted"`} {
+		where := wqlCompare("Name", "=", value)
+		predicates, err := parseWQLWhere(where)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", where, err)
+		}
+		expected := []wqlPredicate{{"Name", "=", value}}
+		if !reflect.DeepEqual(predicates, expected) {
+			t.Errorf("For %q expected %v, got %v", value, expected, predicates)
+		}
+	}
+}
+
+func TestWQLQuery(t *testing.T) {
+	cases := []struct {
+		desc     string
+		query    *wqlQuery
+		expected string
+	}{
+		{
+			desc:     "struct fields",
+			query:    newWQLQuery(&[]fakeWmiClass{}),
+			expected: "SELECT Name, SomeProperty FROM fakeWmiClass",
+		},
+		{
+			desc:     "class override",
+			query:    newWQLQuery(&[]fakeWmiClass{}).from("Win32_Other"),
+			expected: "SELECT Name, SomeProperty FROM Win32_Other",
+		},
+		{
+			desc:     "single where",
+			query:    newWQLQuery(fakeWmiClass{}).and(wqlCompare("Name", "<>", "_Total")),
+			expected: "SELECT Name, SomeProperty FROM fakeWmiClass WHERE Name <> '_Total'",
+		},
+		{
+			desc:     "combined where",
+			query:    newWQLQuery(fakeWmiClass{}).and("Name = 'a' OR Name = 'b'").and("").and(wqlCompare("SomeProperty", ">", 1)),
+			expected: "SELECT Name, SomeProperty FROM fakeWmiClass WHERE (Name = 'a' OR Name = 'b') AND (SomeProperty > 1)",
+		},
+		{
+			desc: "unexported fields are not selected",
+			query: newWQLQuery(&[]struct {
+				Name   string
+				cached int
+			}{}).from("Win32_Anonymous"),
+			expected: "SELECT Name FROM Win32_Anonymous",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if q := c.query.String(); q != c.expected {
+				t.Errorf("Expected %q, got %q", c.expected, q)
+			}
+		})
+	}
+}
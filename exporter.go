@@ -129,15 +129,15 @@ func (coll WmiCollector) Collect(ch chan<- prometheus.Metric) {
 	}()
 
 	for name, c := range coll.collectors {
-		go func(name string, c collector.Collector) {
+		go func(name string, c collector.Collector, ctx *collector.ScrapeContext) {
 			defer wg.Done()
-			outcome := execute(name, c, scrapeContext, metricsBuffer)
+			outcome := execute(name, c, ctx, metricsBuffer)
 			l.Lock()
 			if !finished {
 				collectorOutcomes[name] = outcome
 			}
 			l.Unlock()
-		}(name, c)
+		}(name, c, scrapeContext.ForCollector(name))
 	}
 
 	allDone := make(chan struct{})
@@ -185,6 +185,9 @@ func (coll WmiCollector) Collect(ch chan<- prometheus.Metric) {
 		log.Warn("Collection timed out, still waiting for ", remainingCollectorNames)
 	}
 
+	scrapeContext.CollectFilteredInstances(ch)
+	scrapeContext.CollectWMIQueryStats(ch)
+
 	l.Unlock()
 }
 
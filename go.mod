@@ -7,7 +7,7 @@ require (
 	github.com/Microsoft/hcsshim v0.8.6
 	github.com/StackExchange/wmi v0.0.0-20180116203802-5d049714c4a6
 	github.com/dimchansky/utfbom v1.1.0
-	github.com/go-ole/go-ole v1.2.1 // indirect
+	github.com/go-ole/go-ole v1.2.1
 	github.com/leoluk/perflib_exporter v0.1.0
 	github.com/prometheus/client_golang v0.9.2
 	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910